@@ -3,8 +3,10 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -62,6 +64,20 @@ func TestAPIServer(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 
+	// Creating the database again conflicts, and an invalid configuration is rejected
+	for body, status := range map[string]int{
+		string(jsonBody): http.StatusConflict,
+		`{"name": "invalid", "config": {"durability": "sometimes"}}`: http.StatusBadRequest,
+	} {
+		req = httptest.NewRequest("POST", "/api/databases", bytes.NewBufferString(body))
+		w = httptest.NewRecorder()
+		server.HandleDatabases(w, req)
+
+		if w.Code != status {
+			t.Errorf("POST /api/databases %s: expected status %d, got %d", body, status, w.Code)
+		}
+	}
+
 	// Test database listing
 	req = httptest.NewRequest("GET", "/api/databases", nil)
 	w = httptest.NewRecorder()
@@ -99,6 +115,18 @@ func TestAPIServer(t *testing.T) {
 		t.Errorf("Failed to send add_vector message: %v", err)
 	}
 
+	// Test vector upsert through WebSocket
+	upsertVectorMsg := map[string]interface{}{
+		"type":     "upsert_vector",
+		"database": "test",
+		"id":       "test_vector",
+		"data":     make([]float32, 128),
+		"metadata": map[string]interface{}{"test": false},
+	}
+	if err := conn.WriteJSON(upsertVectorMsg); err != nil {
+		t.Errorf("Failed to send upsert_vector message: %v", err)
+	}
+
 	// Test vector search through WebSocket
 	searchMsg := map[string]interface{}{
 		"type":     "search",
@@ -110,12 +138,306 @@ func TestAPIServer(t *testing.T) {
 		t.Errorf("Failed to send search message: %v", err)
 	}
 
-	// Read response
-	var response map[string]interface{}
-	if err := conn.ReadJSON(&response); err != nil {
-		t.Errorf("Failed to read response: %v", err)
+	// Read add and upsert responses
+	for i := 0; i < 2; i++ {
+		var response map[string]interface{}
+		if err := conn.ReadJSON(&response); err != nil {
+			t.Errorf("Failed to read response: %v", err)
+		}
+		if response["error"] != nil {
+			t.Errorf("Received error in response: %v", response["error"])
+		}
+	}
+
+	// Read search response
+	var searchResults []db.SearchResult
+	if err := conn.ReadJSON(&searchResults); err != nil {
+		t.Errorf("Failed to read search response: %v", err)
+	}
+	if len(searchResults) != 1 || searchResults[0].ID != "test_vector" {
+		t.Errorf("Expected test_vector in search results, got %v", searchResults)
+	} else if searchResults[0].Distance != 0 || searchResults[0].Score != 1 {
+		t.Errorf("Expected distance 0 and score 1, got %f and %f", searchResults[0].Distance, searchResults[0].Score)
+	}
+
+	// Test batch search through WebSocket
+	batchMsg := map[string]interface{}{
+		"type":     "search_batch",
+		"database": "test",
+		"queries":  [][]float32{make([]float32, 128), {1}},
+		"k":        5,
+	}
+	if err := conn.WriteJSON(batchMsg); err != nil {
+		t.Errorf("Failed to send search_batch message: %v", err)
+	}
+	var batchResults []BatchSearchResult
+	if err := conn.ReadJSON(&batchResults); err != nil {
+		t.Errorf("Failed to read search_batch response: %v", err)
+	}
+	if len(batchResults) != 2 || len(batchResults[0].Results) != 1 || batchResults[0].Results[0].ID != "test_vector" {
+		t.Errorf("Expected test_vector for the first batch query, got %v", batchResults)
+	} else if batchResults[1].Error != db.ErrInvalidDimensions.Error() {
+		t.Errorf("Expected a dimensions error for the second batch query, got %+v", batchResults[1])
+	}
+
+	// Errors quoting user input are still valid JSON
+	badFilterMsg := map[string]interface{}{
+		"type":     "search",
+		"database": "test",
+		"query":    make([]float32, 128),
+		"k":        5,
+		"filter":   map[string]interface{}{"op": "bogus"},
+	}
+	if err := conn.WriteJSON(badFilterMsg); err != nil {
+		t.Errorf("Failed to send search message: %v", err)
+	}
+	var errorResponse map[string]interface{}
+	if err := conn.ReadJSON(&errorResponse); err != nil {
+		t.Fatalf("Failed to read error response: %v", err)
+	}
+	if message, _ := errorResponse["error"].(string); !strings.Contains(message, `"bogus"`) {
+		t.Errorf("Expected an error naming the unknown operator, got %v", errorResponse)
+	}
+
+	// Test vector upsert through REST
+	vectorBody, _ := json.Marshal(db.Vector{ID: "rest_vector", Data: make([]float32, 128)})
+	req = httptest.NewRequest("PUT", "/api/databases/test", bytes.NewBuffer(vectorBody))
+	w = httptest.NewRecorder()
+	server.handleDatabase(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	if _, err := manager.GetVector("test", "rest_vector"); err != nil {
+		t.Errorf("Upserted vector not found: %v", err)
+	}
+
+	// Test vector search through REST
+	query := make([]float32, 128)
+	query[0] = 0.5
+	searchBody, _ := json.Marshal(map[string]interface{}{
+		"query":           query,
+		"k":               5,
+		"ef":              50,
+		"max_visited":     1000,
+		"filter":          map[string]interface{}{"op": "eq", "key": "test", "value": false},
+		"include_vectors": false,
+	})
+	req = httptest.NewRequest("POST", "/api/databases/test/search", bytes.NewBuffer(searchBody))
+	w = httptest.NewRecorder()
+	server.handleDatabase(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var searchResponse SearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&searchResponse); err != nil {
+		t.Fatalf("Failed to decode search response: %v", err)
+	}
+	if len(searchResponse.Results) != 1 || searchResponse.Results[0].ID != "test_vector" {
+		t.Fatalf("Expected only test_vector to match the filter, got %v", searchResponse.Results)
+	}
+	if result := searchResponse.Results[0]; result.Distance != 0.5 || result.Data != nil || result.Metadata["test"] != false {
+		t.Errorf("Expected distance 0.5, no data and metadata, got %+v", result)
+	}
+
+	// A radius switches to a range search
+	rangeBody, _ := json.Marshal(map[string]interface{}{"query": query, "radius": 0.5, "limit": 10})
+	req = httptest.NewRequest("POST", "/api/databases/test/search", bytes.NewBuffer(rangeBody))
+	w = httptest.NewRecorder()
+	server.handleDatabase(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for range search, got %d: %s", w.Code, w.Body.String())
+	}
+	searchResponse = SearchResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&searchResponse); err != nil {
+		t.Fatalf("Failed to decode range search response: %v", err)
+	}
+	if len(searchResponse.Results) == 0 || searchResponse.Results[0].ID != "test_vector" {
+		t.Fatalf("Expected test_vector within the radius, got %v", searchResponse.Results)
+	}
+	for _, result := range searchResponse.Results {
+		if result.Distance > 0.5 {
+			t.Errorf("Range search returned %s at distance %f", result.ID, result.Distance)
+		}
+	}
+
+	// An id searches from a stored vector and leaves it out of the results
+	req = httptest.NewRequest("POST", "/api/databases/test/search", bytes.NewBufferString(`{"id": "test_vector", "k": 5}`))
+	w = httptest.NewRecorder()
+	server.handleDatabase(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for search by id, got %d: %s", w.Code, w.Body.String())
+	}
+	searchResponse = SearchResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&searchResponse); err != nil {
+		t.Fatalf("Failed to decode search by id response: %v", err)
+	}
+	if len(searchResponse.Results) != 1 || searchResponse.Results[0].ID != "rest_vector" {
+		t.Errorf("Expected only rest_vector as a neighbor of test_vector, got %v", searchResponse.Results)
+	}
+
+	// Recommendations leave the examples out of the results
+	req = httptest.NewRequest("POST", "/api/databases/test/recommend",
+		bytes.NewBufferString(`{"positive": ["test_vector"], "strategy": "best_score", "k": 5, "include_vectors": false}`))
+	w = httptest.NewRecorder()
+	server.handleDatabase(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for recommend, got %d: %s", w.Code, w.Body.String())
+	}
+	searchResponse = SearchResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&searchResponse); err != nil {
+		t.Fatalf("Failed to decode recommend response: %v", err)
+	}
+	if len(searchResponse.Results) != 1 || searchResponse.Results[0].ID != "rest_vector" || searchResponse.Results[0].Data != nil {
+		t.Errorf("Expected only rest_vector without data, got %v", searchResponse.Results)
+	}
+
+	// Batch search returns one entry per query, in order
+	batchBody, _ := json.Marshal(map[string]interface{}{
+		"queries": [][]float32{query, {1, 2}, query},
+		"k":       5,
+		"filter":  map[string]interface{}{"op": "eq", "key": "test", "value": false},
+	})
+	req = httptest.NewRequest("POST", "/api/databases/test/search/batch", bytes.NewBuffer(batchBody))
+	w = httptest.NewRecorder()
+	server.handleDatabase(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for batch search, got %d: %s", w.Code, w.Body.String())
+	}
+	var batchResponse BatchSearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&batchResponse); err != nil {
+		t.Fatalf("Failed to decode batch search response: %v", err)
+	}
+	if len(batchResponse.Results) != 3 {
+		t.Fatalf("Expected 3 batch entries, got %v", batchResponse.Results)
+	}
+	for _, i := range []int{0, 2} {
+		entry := batchResponse.Results[i]
+		if entry.Error != "" || len(entry.Results) != 1 || entry.Results[0].ID != "test_vector" {
+			t.Errorf("Entry %d: expected test_vector, got %+v", i, entry)
+		}
+	}
+	if entry := batchResponse.Results[1]; entry.Error != db.ErrInvalidDimensions.Error() || entry.Results != nil {
+		t.Errorf("Expected a dimensions error for entry 1, got %+v", entry)
+	}
+
+	// Search errors map to status codes
+	looseBody, _ := json.Marshal(map[string]interface{}{"query": query, "quality_threshold": 0.5})
+	negativeBody, _ := json.Marshal(map[string]interface{}{"query": query, "radius": -1})
+	searchErrors := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/api/databases/missing/search", `{"query": [1]}`, http.StatusNotFound},
+		{"/api/databases/test/search", `{"query": [1, 2]}`, http.StatusBadRequest},
+		{"/api/databases/test/search", `{"query": `, http.StatusBadRequest},
+		{"/api/databases/test/search", string(looseBody), http.StatusBadRequest},
+		{"/api/databases/test/search", string(negativeBody), http.StatusBadRequest},
+		{"/api/databases/test/search", `{"id": "missing"}`, http.StatusNotFound},
+		{"/api/databases/test/search", `{"id": "test_vector", "radius": 1}`, http.StatusBadRequest},
+		{"/api/databases/missing/search/batch", `{"queries": [[1]]}`, http.StatusNotFound},
+		{"/api/databases/test/recommend", `{"positive": ["missing"]}`, http.StatusNotFound},
+		{"/api/databases/test/recommend", `{"negative": ["test_vector"]}`, http.StatusBadRequest},
+		{"/api/databases/test/recommend", `{"positive": ["test_vector"], "strategy": "median"}`, http.StatusBadRequest},
+		{"/api/databases/test/search/batch", `{"queries": [[1]], "ef": -1}`, http.StatusBadRequest},
+		{"/api/databases/test/search/batch", `{"queries": `, http.StatusBadRequest},
+		{"/api/databases/test/unknown", `{}`, http.StatusNotFound},
+	}
+	for _, test := range searchErrors {
+		req = httptest.NewRequest("POST", test.path, bytes.NewBufferString(test.body))
+		w = httptest.NewRecorder()
+		server.handleDatabase(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("POST %s %s: expected status %d, got %d", test.path, test.body, test.status, w.Code)
+		}
+	}
+
+	// Test vector routes through REST
+	bulk := make([]db.Vector, 3)
+	for i := range bulk {
+		bulk[i] = db.Vector{ID: fmt.Sprintf("bulk_%d", i), Data: make([]float32, 128)}
+	}
+	bulkBody, _ := json.Marshal(bulk)
+	zeroData, _ := json.Marshal(make([]float32, 128))
+	vectorRequests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "/api/databases/test/vectors", string(bulkBody), http.StatusCreated},
+		{"POST", "/api/databases/test/vectors", `[{"id": "bulk_1", "data": [1]`, http.StatusBadRequest},
+		{"POST", "/api/databases/test/vectors", `"bulk"`, http.StatusBadRequest},
+		{"POST", "/api/databases/missing/vectors", string(bulkBody), http.StatusNotFound},
+		{"GET", "/api/databases/test/vectors/bulk_1", "", http.StatusOK},
+		{"GET", "/api/databases/test/vectors/missing", "", http.StatusNotFound},
+		{"GET", "/api/databases/missing/vectors/bulk_1", "", http.StatusNotFound},
+		{"PATCH", "/api/databases/test/vectors/bulk_1", `{"metadata": {"color": "red"}}`, http.StatusOK},
+		{"PATCH", "/api/databases/test/vectors/missing", `{"metadata": {}}`, http.StatusNotFound},
+		{"PUT", "/api/databases/test/vectors/bulk_2", `{"id": "other", "data": []}`, http.StatusBadRequest},
+		{"DELETE", "/api/databases/test/vectors/bulk_0", "", http.StatusNoContent},
+		{"DELETE", "/api/databases/test/vectors/bulk_0", "", http.StatusNotFound},
+		{"GET", "/api/databases/test/vectors?limit=abc", "", http.StatusBadRequest},
+		{"POST", "/api/databases/test/vectors/bulk_1", "{}", http.StatusMethodNotAllowed},
+		{"GET", "/api/databases/test/", "", http.StatusOK},
+		{"PUT", "/api/databases/test/", `{"id": "bulk_1", "data": ` + string(zeroData) + `, "metadata": {"color": "red"}}`, http.StatusOK},
+		{"POST", "/api/databases/test/", `{"id": "bulk_1", "data": ` + string(zeroData) + `}`, http.StatusConflict},
+		{"DELETE", "/api/databases/missing/", "", http.StatusNotFound},
+	}
+	for _, test := range vectorRequests {
+		req = httptest.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+		w = httptest.NewRecorder()
+		server.handleDatabase(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", test.method, test.path, test.status, w.Code, w.Body.String())
+		}
+	}
+
+	// Bulk inserts report rejected vectors individually, for arrays and NDJSON alike
+	vectorData, _ := json.Marshal(make([]float32, 128))
+	rejected := []string{
+		`{"id": "bulk_1", "data": ` + string(vectorData) + `}`,
+		`{"id": "", "data": ` + string(vectorData) + `}`,
+		`{"id": "bulk_5", "data": [1]}`,
+	}
+	for _, body := range []string{"[" + strings.Join(rejected, ",") + "]", strings.Join(rejected, "\n") + "\n"} {
+		req = httptest.NewRequest("POST", "/api/databases/test/vectors", bytes.NewBufferString(body))
+		w = httptest.NewRecorder()
+		server.handleDatabase(w, req)
+
+		var bulkResponse BulkInsertResponse
+		if err := json.NewDecoder(w.Body).Decode(&bulkResponse); err != nil {
+			t.Fatalf("Failed to decode bulk response: %v", err)
+		}
+		if w.Code != http.StatusOK || bulkResponse.Inserted != 0 || len(bulkResponse.Errors) != 3 {
+			t.Errorf("Expected 3 rejected vectors, got status %d and %+v", w.Code, bulkResponse)
+		} else if bulkResponse.Errors[2].Index != 2 || bulkResponse.Errors[2].ID != "bulk_5" {
+			t.Errorf("Expected errors in request order, got %+v", bulkResponse.Errors)
+		}
+	}
+
+	if vector, _ := manager.GetVector("test", "bulk_1"); vector.Metadata["color"] != "red" {
+		t.Errorf("Expected patched metadata, got %v", vector.Metadata)
+	}
+
+	req = httptest.NewRequest("GET", "/api/databases/test/vectors?offset=1&limit=2", nil)
+	w = httptest.NewRecorder()
+	server.handleDatabase(w, req)
+
+	var page VectorListResponse
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode vector list: %v", err)
 	}
-	if response["error"] != nil {
-		t.Errorf("Received error in response: %v", response["error"])
+	// bulk_1, bulk_2, rest_vector and test_vector remain, listed in ID order
+	if page.Total != 4 || len(page.Vectors) != 2 || page.Vectors[0].ID != "bulk_2" || page.Vectors[1].ID != "rest_vector" {
+		t.Errorf("Expected bulk_2 and rest_vector of 4, got %+v", page)
 	}
 }
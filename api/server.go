@@ -1,8 +1,14 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"vector-db/config"
@@ -63,18 +69,80 @@ func (s *Server) HandleDatabases(w http.ResponseWriter, r *http.Request) {
 handleDatabase handles database operations
 */
 func (s *Server) handleDatabase(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		s.getDatabase(w, r)
-	case http.MethodDelete:
-		s.deleteDatabase(w, r)
-	case http.MethodPost:
-		s.addVector(w, r)
+	dbName, route := splitDatabasePath(r.URL.Path)
+
+	switch route {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getDatabase(w, dbName)
+		case http.MethodDelete:
+			s.deleteDatabase(w, dbName)
+		case http.MethodPost:
+			s.addVector(w, r, dbName)
+		case http.MethodPut:
+			s.upsertVector(w, r, dbName)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "search":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.searchDatabase(w, r, dbName)
+	case "search/batch":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.searchDatabaseBatch(w, r, dbName)
+	case "recommend":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.recommend(w, r, dbName)
+	case "vectors":
+		switch r.Method {
+		case http.MethodGet:
+			s.listVectors(w, r, dbName)
+		case http.MethodPost:
+			s.addVectors(w, r, dbName)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		vectorID, ok := strings.CutPrefix(route, "vectors/")
+		if !ok || vectorID == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			s.getVector(w, dbName, vectorID)
+		case http.MethodPut:
+			s.putVector(w, r, dbName, vectorID)
+		case http.MethodPatch:
+			s.patchVector(w, r, dbName, vectorID)
+		case http.MethodDelete:
+			s.deleteVector(w, dbName, vectorID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
 	}
 }
 
+/*
+splitDatabasePath splits /api/databases/{name}/{route} into the database name and the
+remaining route, which is empty for requests on the database itself
+*/
+func splitDatabasePath(path string) (string, string) {
+	dbName, route, _ := strings.Cut(strings.TrimPrefix(path, "/api/databases/"), "/")
+	return dbName, route
+}
+
 /*
 handleWebSocket handles WebSocket connections
 */
@@ -111,8 +179,14 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		switch request["type"] {
 		case "search":
 			s.handleSearch(conn, messageType, request)
+		case "search_batch":
+			s.handleSearchBatch(conn, messageType, request)
+		case "recommend":
+			s.handleRecommend(conn, messageType, request)
 		case "add_vector":
 			s.handleAddVector(conn, messageType, request)
+		case "upsert_vector":
+			s.handleUpsertVector(conn, messageType, request)
 		default:
 			conn.WriteMessage(messageType, []byte(`{"error": "Unknown message type"}`))
 		}
@@ -142,7 +216,7 @@ func (s *Server) handleCreateDatabase(w http.ResponseWriter, r *http.Request) {
 
 	db, err := s.dbManager.CreateDatabase(request.Name, request.Config)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusForError(err))
 		return
 	}
 
@@ -152,9 +226,7 @@ func (s *Server) handleCreateDatabase(w http.ResponseWriter, r *http.Request) {
 /*
 GetDatabase gets a database by name
 */
-func (s *Server) getDatabase(w http.ResponseWriter, r *http.Request) {
-	// Extract database name from URL
-	dbName := r.URL.Path[len("/api/databases/"):]
+func (s *Server) getDatabase(w http.ResponseWriter, dbName string) {
 	db, err := s.dbManager.GetDatabase(dbName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
@@ -167,10 +239,9 @@ func (s *Server) getDatabase(w http.ResponseWriter, r *http.Request) {
 /*
 DeleteDatabase deletes a database by name
 */
-func (s *Server) deleteDatabase(w http.ResponseWriter, r *http.Request) {
-	dbName := r.URL.Path[len("/api/databases/"):]
+func (s *Server) deleteDatabase(w http.ResponseWriter, dbName string) {
 	if err := s.dbManager.DeleteDatabase(dbName); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusForError(err))
 		return
 	}
 
@@ -180,8 +251,7 @@ func (s *Server) deleteDatabase(w http.ResponseWriter, r *http.Request) {
 /*
 AddVector adds a vector to a specific database
 */
-func (s *Server) addVector(w http.ResponseWriter, r *http.Request) {
-	dbName := r.URL.Path[len("/api/databases/"):]
+func (s *Server) addVector(w http.ResponseWriter, r *http.Request, dbName string) {
 	var vector db.Vector
 	if err := json.NewDecoder(r.Body).Decode(&vector); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -189,24 +259,603 @@ func (s *Server) addVector(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.dbManager.AddVector(dbName, vector); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusForError(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
+/*
+UpsertVector adds or replaces a vector in a specific database
+*/
+func (s *Server) upsertVector(w http.ResponseWriter, r *http.Request, dbName string) {
+	var vector db.Vector
+	if err := json.NewDecoder(r.Body).Decode(&vector); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.dbManager.UpsertVector(dbName, vector); err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+VectorListResponse is the body returned by GET /api/databases/{name}/vectors
+*/
+type VectorListResponse struct {
+	// vectors of the requested page, in ID order
+	Vectors []db.Vector `json:"vectors"`
+	// total number of vectors in the database
+	Total int `json:"total"`
+	// position of the first vector of the page
+	Offset int `json:"offset"`
+	// maximum number of vectors in the page
+	Limit int `json:"limit"`
+}
+
+const (
+	// defaultPageSize is the number of vectors listed when no limit is given
+	defaultPageSize = 100
+	// maxPageSize caps the number of vectors listed per request
+	maxPageSize = 1000
+)
+
+/*
+listVectors lists the vectors of a database page by page, using the offset and limit query parameters
+*/
+func (s *Server) listVectors(w http.ResponseWriter, r *http.Request, dbName string) {
+	offset, limit := 0, defaultPageSize
+
+	query := r.URL.Query()
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxPageSize)
+	}
+
+	vectors, total, err := s.dbManager.ListVectors(dbName, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, VectorListResponse{
+		Vectors: vectors,
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+	})
+}
+
+/*
+BulkInsertError reports a vector of a bulk insert that was rejected
+*/
+type BulkInsertError struct {
+	// position of the vector in the request
+	Index int `json:"index"`
+	// ID of the vector
+	ID string `json:"id"`
+	// reason the vector was rejected
+	Error string `json:"error"`
+}
+
+/*
+BulkInsertResponse is the body returned by POST /api/databases/{name}/vectors
+*/
+type BulkInsertResponse struct {
+	// number of vectors added
+	Inserted int `json:"inserted"`
+	// vectors that were rejected
+	Errors []BulkInsertError `json:"errors"`
+}
+
+// bulkInsertChunk is the number of vectors decoded from a request before they are inserted
+const bulkInsertChunk = 10000
+
+/*
+addVectors inserts vectors sent as a JSON array or as newline-delimited JSON.
+
+The body is decoded and inserted in chunks, so arbitrarily large loads are streamed
+instead of being held in memory at once. Rejected vectors are reported individually
+and do not stop the load; a malformed body stops it, keeping the chunks already inserted.
+*/
+func (s *Server) addVectors(w http.ResponseWriter, r *http.Request, dbName string) {
+	response := BulkInsertResponse{Errors: []BulkInsertError{}}
+	offset := 0
+
+	insert := func(chunk []db.Vector) error {
+		errs, err := s.dbManager.AddVectors(dbName, chunk)
+		if err != nil {
+			return err
+		}
+		for i, itemErr := range errs {
+			if itemErr != nil {
+				response.Errors = append(response.Errors, BulkInsertError{Index: offset + i, ID: chunk[i].ID, Error: itemErr.Error()})
+			} else {
+				response.Inserted++
+			}
+		}
+		offset += len(chunk)
+		return nil
+	}
+
+	err := decodeVectorStream(r.Body, bulkInsertChunk, insert)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, errInvalidVectorStream),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		http.Error(w, fmt.Sprintf("Invalid request body after %d vectors: %v", offset, err), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	status := http.StatusCreated
+	if len(response.Errors) > 0 {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, response)
+}
+
+// errInvalidVectorStream is returned for bodies that are neither a JSON array nor NDJSON
+var errInvalidVectorStream = errors.New("expected a JSON array or newline-delimited JSON objects")
+
+/*
+decodeVectorStream decodes vectors from a JSON array or a stream of JSON objects,
+such as NDJSON, and passes them to handle in chunks of up to size vectors
+*/
+func decodeVectorStream(body io.Reader, size int, handle func([]db.Vector) error) error {
+	reader := bufio.NewReader(body)
+	decoder := json.NewDecoder(reader)
+
+	// Peek at the first significant byte to tell an array from a stream of objects
+	first, err := firstNonSpace(reader)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	array := first == '['
+	if array {
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	} else if first != '{' {
+		return errInvalidVectorStream
+	}
+
+	chunk := make([]db.Vector, 0, size)
+	for {
+		if array && !decoder.More() {
+			break
+		}
+
+		var vector db.Vector
+		err := decoder.Decode(&vector)
+		if err == io.EOF && !array {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		chunk = append(chunk, vector)
+		if len(chunk) == size {
+			if err := handle(chunk); err != nil {
+				return err
+			}
+			chunk = make([]db.Vector, 0, size)
+		}
+	}
+
+	if array {
+		// Consume the closing bracket so a truncated array is reported
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	}
+
+	if len(chunk) > 0 {
+		return handle(chunk)
+	}
+	return nil
+}
+
+/*
+firstNonSpace returns the first byte that is not JSON whitespace without consuming it
+*/
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, reader.UnreadByte()
+	}
+}
+
+/*
+getVector returns a single vector by ID
+*/
+func (s *Server) getVector(w http.ResponseWriter, dbName, vectorID string) {
+	vector, err := s.dbManager.GetVector(dbName, vectorID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, vector)
+}
+
+/*
+putVector adds or replaces the vector stored under the ID in the path
+*/
+func (s *Server) putVector(w http.ResponseWriter, r *http.Request, dbName, vectorID string) {
+	var vector db.Vector
+	if err := json.NewDecoder(r.Body).Decode(&vector); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// The ID in the body is optional but must agree with the path
+	if vector.ID == "" {
+		vector.ID = vectorID
+	} else if vector.ID != vectorID {
+		http.Error(w, "Vector ID does not match the path", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.dbManager.UpsertVector(dbName, vector); err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, vector)
+}
+
+/*
+patchVector merges the metadata in the request body into the metadata of a vector.
+Keys set to null are removed.
+*/
+func (s *Server) patchVector(w http.ResponseWriter, r *http.Request, dbName, vectorID string) {
+	var request struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	vector, err := s.dbManager.UpdateMetadata(dbName, vectorID, request.Metadata)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, vector)
+}
+
+/*
+deleteVector removes a single vector by ID
+*/
+func (s *Server) deleteVector(w http.ResponseWriter, dbName, vectorID string) {
+	if err := s.dbManager.DeleteVector(dbName, vectorID); err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+SearchRequest is the body of POST /api/databases/{name}/search
+*/
+type SearchRequest struct {
+	// query vector, with the dimensions of the database
+	Query []float32 `json:"query"`
+	// ID of a stored vector to find neighbors of instead of the query; the vector
+	// itself is left out of the results
+	ID string `json:"id,omitempty"`
+	// number of results (defaults to 10), ignored by range searches
+	K int `json:"k"`
+	// maximum distance of the results; when set, all vectors within it are returned
+	// instead of the k nearest
+	Radius *float32 `json:"radius,omitempty"`
+	// maximum number of results of a range search (0 means no limit)
+	Limit int `json:"limit,omitempty"`
+	// size of the candidate list, trading speed for recall (0 uses the database default)
+	Ef int `json:"ef,omitempty"`
+	// early-stop slack factor of at least 1 (0 uses the default of 1.1)
+	QualityThreshold float32 `json:"quality_threshold,omitempty"`
+	// maximum number of nodes to visit, bounding latency (0 means no limit)
+	MaxVisited int `json:"max_visited,omitempty"`
+	// metadata filter expression
+	Filter *db.Filter `json:"filter,omitempty"`
+	// whether to return the vector data of each result (defaults to true)
+	IncludeVectors *bool `json:"include_vectors,omitempty"`
+	// whether to return the metadata of each result (defaults to true)
+	IncludeMetadata *bool `json:"include_metadata,omitempty"`
+}
+
+/*
+SearchResponse is the body returned by POST /api/databases/{name}/search
+*/
+type SearchResponse struct {
+	// results ranked from nearest to farthest
+	Results []db.SearchResult `json:"results"`
+}
+
+/*
+searchDatabase performs a similarity search in a specific database
+*/
+func (s *Server) searchDatabase(w http.ResponseWriter, r *http.Request, dbName string) {
+	var request SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.K == 0 {
+		request.K = 10
+	}
+
+	opts := db.SearchOptions{
+		Filter:           request.Filter,
+		Ef:               request.Ef,
+		QualityThreshold: request.QualityThreshold,
+		MaxVisited:       request.MaxVisited,
+	}
+	opts.OmitVectors = request.IncludeVectors != nil && !*request.IncludeVectors
+	opts.OmitMetadata = request.IncludeMetadata != nil && !*request.IncludeMetadata
+
+	var results []db.SearchResult
+	var err error
+	switch {
+	case request.ID != "" && request.Radius != nil:
+		http.Error(w, "id cannot be combined with radius", http.StatusBadRequest)
+		return
+	case request.ID != "":
+		results, err = s.dbManager.SearchByIDWithOptions(dbName, request.ID, request.K, opts)
+	case request.Radius != nil:
+		results, err = s.dbManager.RangeSearch(dbName, request.Query, *request.Radius, request.Limit, opts)
+	default:
+		results, err = s.dbManager.SearchWithOptions(dbName, request.Query, request.K, opts)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SearchResponse{Results: results})
+}
+
+/*
+BatchSearchRequest is the body of POST /api/databases/{name}/search/batch
+*/
+type BatchSearchRequest struct {
+	// query vectors, each with the dimensions of the database
+	Queries [][]float32 `json:"queries"`
+	// number of results per query (defaults to 10)
+	K int `json:"k"`
+	// size of the candidate list, trading speed for recall (0 uses the database default)
+	Ef int `json:"ef,omitempty"`
+	// early-stop slack factor of at least 1 (0 uses the default of 1.1)
+	QualityThreshold float32 `json:"quality_threshold,omitempty"`
+	// maximum number of nodes to visit per query, bounding latency (0 means no limit)
+	MaxVisited int `json:"max_visited,omitempty"`
+	// metadata filter expression applied to every query
+	Filter *db.Filter `json:"filter,omitempty"`
+	// whether to return the vector data of each result (defaults to true)
+	IncludeVectors *bool `json:"include_vectors,omitempty"`
+	// whether to return the metadata of each result (defaults to true)
+	IncludeMetadata *bool `json:"include_metadata,omitempty"`
+}
+
+/*
+BatchSearchResult holds the outcome of one query of a batch search
+*/
+type BatchSearchResult struct {
+	// results ranked from nearest to farthest, null when the query failed
+	Results []db.SearchResult `json:"results"`
+	// reason the query failed
+	Error string `json:"error,omitempty"`
+}
+
+/*
+BatchSearchResponse is the body returned by POST /api/databases/{name}/search/batch
+*/
+type BatchSearchResponse struct {
+	// one entry per query, in request order
+	Results []BatchSearchResult `json:"results"`
+}
+
+/*
+searchDatabaseBatch runs many similarity searches with shared parameters in a single request.
+A failing query is reported in its own entry and does not fail the others.
+*/
+func (s *Server) searchDatabaseBatch(w http.ResponseWriter, r *http.Request, dbName string) {
+	var request BatchSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.K == 0 {
+		request.K = 10
+	}
+
+	opts := db.SearchOptions{
+		Filter:           request.Filter,
+		Ef:               request.Ef,
+		QualityThreshold: request.QualityThreshold,
+		MaxVisited:       request.MaxVisited,
+	}
+	opts.OmitVectors = request.IncludeVectors != nil && !*request.IncludeVectors
+	opts.OmitMetadata = request.IncludeMetadata != nil && !*request.IncludeMetadata
+
+	results, errs, err := s.dbManager.SearchBatch(dbName, request.Queries, request.K, opts)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BatchSearchResponse{Results: batchSearchResults(results, errs)})
+}
+
+/*
+batchSearchResults pairs the results of a batch search with the error of each query
+*/
+func batchSearchResults(results [][]db.SearchResult, errs []error) []BatchSearchResult {
+	response := make([]BatchSearchResult, len(results))
+	for i := range results {
+		if errs[i] != nil {
+			response[i].Error = errs[i].Error()
+			continue
+		}
+		response[i].Results = results[i]
+	}
+	return response
+}
+
+/*
+RecommendRequest is the body of POST /api/databases/{name}/recommend
+*/
+type RecommendRequest struct {
+	// positive and negative examples, by ID or as raw vectors, and the strategy
+	// combining them
+	db.RecommendQuery
+	// number of results (defaults to 10)
+	K int `json:"k"`
+	// size of the candidate list, trading speed for recall (0 uses the database default)
+	Ef int `json:"ef,omitempty"`
+	// metadata filter expression
+	Filter *db.Filter `json:"filter,omitempty"`
+	// whether to return the vector data of each result (defaults to true)
+	IncludeVectors *bool `json:"include_vectors,omitempty"`
+	// whether to return the metadata of each result (defaults to true)
+	IncludeMetadata *bool `json:"include_metadata,omitempty"`
+}
+
+/*
+recommend returns the vectors that best match a set of positive and negative examples
+*/
+func (s *Server) recommend(w http.ResponseWriter, r *http.Request, dbName string) {
+	var request RecommendRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.K == 0 {
+		request.K = 10
+	}
+
+	opts := db.SearchOptions{Filter: request.Filter, Ef: request.Ef}
+	opts.OmitVectors = request.IncludeVectors != nil && !*request.IncludeVectors
+	opts.OmitMetadata = request.IncludeMetadata != nil && !*request.IncludeMetadata
+
+	results, err := s.dbManager.Recommend(dbName, request.RecommendQuery, request.K, opts)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SearchResponse{Results: results})
+}
+
+/*
+statusForError maps errors returned by the database manager to HTTP status codes
+*/
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, db.ErrDatabaseNotFound), errors.Is(err, db.ErrVectorNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrDatabaseExists), errors.Is(err, db.ErrVectorExists):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrInvalidDimensions), errors.Is(err, db.ErrInvalidFilter),
+		errors.Is(err, db.ErrInvalidParameter), errors.Is(err, db.ErrEmptyVector),
+		errors.Is(err, db.ErrInvalidIndex):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+/*
+writeJSON writes value as a JSON response with the given status code
+*/
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+/*
+writeWebSocketError sends an error over a WebSocket connection. The message is
+marshaled, so quotes and control characters in the error text keep it valid JSON.
+*/
+func writeWebSocketError(conn *websocket.Conn, messageType int, err error) {
+	response, _ := json.Marshal(map[string]string{"error": err.Error()})
+	conn.WriteMessage(messageType, response)
+}
+
 /*
 Helper methods for WebSocket handlers
 */
 func (s *Server) handleSearch(conn *websocket.Conn, messageType int, request map[string]interface{}) {
-	dbName := request["database"].(string)
-	query := request["query"].([]float32)
-	k := int(request["k"].(float64))
+	dbName, _ := request["database"].(string)
+	k, _ := request["k"].(float64)
 
-	results, err := s.dbManager.Search(dbName, query, k)
+	opts, err := searchOptionsFromMessage(request)
 	if err != nil {
-		conn.WriteMessage(messageType, []byte(`{"error": "`+err.Error()+`"}`))
+		writeWebSocketError(conn, messageType, err)
+		return
+	}
+
+	var results []db.SearchResult
+	if id, ok := request["id"].(string); ok && id != "" {
+		// A stored vector can be searched from by ID instead of sending its data
+		results, err = s.dbManager.SearchByIDWithOptions(dbName, id, int(k), opts)
+	} else {
+		var query []float32
+		if query, err = float32SliceFromMessage(request["query"]); err != nil {
+			writeWebSocketError(conn, messageType, err)
+			return
+		}
+
+		if radius, ok := request["radius"].(float64); ok {
+			limit, _ := request["limit"].(float64)
+			results, err = s.dbManager.RangeSearch(dbName, query, float32(radius), int(limit), opts)
+		} else {
+			results, err = s.dbManager.SearchWithOptions(dbName, query, int(k), opts)
+		}
+	}
+	if err != nil {
+		writeWebSocketError(conn, messageType, err)
 		return
 	}
 
@@ -214,26 +863,193 @@ func (s *Server) handleSearch(conn *websocket.Conn, messageType int, request map
 	conn.WriteMessage(messageType, response)
 }
 
-func (s *Server) handleAddVector(conn *websocket.Conn, messageType int, request map[string]interface{}) {
-	dbName := request["database"].(string)
+func (s *Server) handleSearchBatch(conn *websocket.Conn, messageType int, request map[string]interface{}) {
+	dbName, _ := request["database"].(string)
 
-	// Convert interface{} to []float32
-	data := request["data"].([]interface{})
-	vectorData := make([]float32, len(data))
-	for i, v := range data {
-		vectorData[i] = float32(v.(float64))
+	items, ok := request["queries"].([]interface{})
+	if !ok {
+		conn.WriteMessage(messageType, []byte(`{"error": "missing queries"}`))
+		return
+	}
+	queries := make([][]float32, len(items))
+	for i, item := range items {
+		query, err := float32SliceFromMessage(item)
+		if err != nil {
+			writeWebSocketError(conn, messageType, err)
+			return
+		}
+		queries[i] = query
 	}
 
-	vector := db.Vector{
-		ID:       request["id"].(string),
-		Data:     vectorData,
-		Metadata: request["metadata"].(map[string]interface{}),
+	k, _ := request["k"].(float64)
+
+	opts, err := searchOptionsFromMessage(request)
+	if err != nil {
+		writeWebSocketError(conn, messageType, err)
+		return
+	}
+
+	results, errs, err := s.dbManager.SearchBatch(dbName, queries, int(k), opts)
+	if err != nil {
+		writeWebSocketError(conn, messageType, err)
+		return
+	}
+
+	response, _ := json.Marshal(batchSearchResults(results, errs))
+	conn.WriteMessage(messageType, response)
+}
+
+func (s *Server) handleRecommend(conn *websocket.Conn, messageType int, request map[string]interface{}) {
+	dbName, _ := request["database"].(string)
+	k, _ := request["k"].(float64)
+
+	// Round-trip through JSON so the examples are decoded by the RecommendQuery struct tags
+	var query db.RecommendQuery
+	raw, _ := json.Marshal(request)
+	if err := json.Unmarshal(raw, &query); err != nil {
+		conn.WriteMessage(messageType, []byte(`{"error": "invalid recommendation examples"}`))
+		return
+	}
+
+	opts, err := searchOptionsFromMessage(request)
+	if err != nil {
+		writeWebSocketError(conn, messageType, err)
+		return
+	}
+
+	results, err := s.dbManager.Recommend(dbName, query, int(k), opts)
+	if err != nil {
+		writeWebSocketError(conn, messageType, err)
+		return
+	}
+
+	response, _ := json.Marshal(results)
+	conn.WriteMessage(messageType, response)
+}
+
+func (s *Server) handleAddVector(conn *websocket.Conn, messageType int, request map[string]interface{}) {
+	dbName, _ := request["database"].(string)
+
+	vector, err := vectorFromMessage(request)
+	if err != nil {
+		writeWebSocketError(conn, messageType, err)
+		return
 	}
 
 	if err := s.dbManager.AddVector(dbName, vector); err != nil {
-		conn.WriteMessage(messageType, []byte(`{"error": "`+err.Error()+`"}`))
+		writeWebSocketError(conn, messageType, err)
 		return
 	}
 
 	conn.WriteMessage(messageType, []byte(`{"status": "success"}`))
 }
+
+func (s *Server) handleUpsertVector(conn *websocket.Conn, messageType int, request map[string]interface{}) {
+	dbName, _ := request["database"].(string)
+
+	vector, err := vectorFromMessage(request)
+	if err != nil {
+		writeWebSocketError(conn, messageType, err)
+		return
+	}
+
+	if err := s.dbManager.UpsertVector(dbName, vector); err != nil {
+		writeWebSocketError(conn, messageType, err)
+		return
+	}
+
+	conn.WriteMessage(messageType, []byte(`{"status": "success"}`))
+}
+
+/*
+vectorFromMessage builds a vector from the id, data and metadata fields of a WebSocket message
+*/
+func vectorFromMessage(request map[string]interface{}) (db.Vector, error) {
+	id, ok := request["id"].(string)
+	if !ok || id == "" {
+		return db.Vector{}, errors.New("missing vector id")
+	}
+
+	vectorData, err := float32SliceFromMessage(request["data"])
+	if err != nil {
+		return db.Vector{}, err
+	}
+
+	metadata, _ := request["metadata"].(map[string]interface{})
+
+	return db.Vector{
+		ID:       id,
+		Data:     vectorData,
+		Metadata: metadata,
+	}, nil
+}
+
+/*
+float32SliceFromMessage converts a decoded JSON array into a float32 vector
+*/
+func float32SliceFromMessage(value interface{}) ([]float32, error) {
+	// Decoded JSON arrays arrive as []interface{} holding float64 values
+	data, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("missing vector data")
+	}
+
+	vectorData := make([]float32, len(data))
+	for i, v := range data {
+		number, ok := v.(float64)
+		if !ok {
+			return nil, errors.New("vector data must be numeric")
+		}
+		vectorData[i] = float32(number)
+	}
+
+	return vectorData, nil
+}
+
+/*
+searchOptionsFromMessage reads the search options shared by the search messages
+*/
+func searchOptionsFromMessage(request map[string]interface{}) (db.SearchOptions, error) {
+	opts := db.SearchOptions{}
+	opts.OmitVectors, _ = request["omit_vectors"].(bool)
+	opts.OmitMetadata, _ = request["omit_metadata"].(bool)
+	if ef, ok := request["ef"].(float64); ok {
+		opts.Ef = int(ef)
+	}
+	if threshold, ok := request["quality_threshold"].(float64); ok {
+		opts.QualityThreshold = float32(threshold)
+	}
+	if maxVisited, ok := request["max_visited"].(float64); ok {
+		opts.MaxVisited = int(maxVisited)
+	}
+
+	filter, err := filterFromMessage(request["filter"])
+	if err != nil {
+		return db.SearchOptions{}, err
+	}
+	opts.Filter = filter
+
+	return opts, nil
+}
+
+/*
+filterFromMessage converts a decoded JSON filter expression into a db.Filter
+*/
+func filterFromMessage(value interface{}) (*db.Filter, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	// Round-trip through JSON so the expression is decoded by the Filter struct tags
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter db.Filter
+	if err := json.Unmarshal(raw, &filter); err != nil {
+		return nil, errors.New("invalid filter")
+	}
+
+	return &filter, nil
+}
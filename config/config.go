@@ -42,6 +42,51 @@ type HNSWConfig struct {
 	EfSearch int `json:"ef_search"`
 	// distance function
 	DistanceType DistanceType `json:"distance_type"`
+	// compression of the vectors traversed by the graph
+	Quantization QuantizationConfig `json:"quantization"`
+}
+
+/*
+QuantizationType is the codec used to compress the vectors traversed by the graph.
+*/
+type QuantizationType string
+
+const (
+	// QuantizationNone traverses the graph with the full-precision vectors
+	QuantizationNone QuantizationType = ""
+	// QuantizationScalar encodes every dimension as an 8-bit code between the
+	// dimension's minimum and maximum
+	QuantizationScalar QuantizationType = "scalar"
+	// QuantizationProduct splits vectors into subspaces and encodes each subspace as
+	// the nearest of 256 centroids learned by k-means, one byte per subspace
+	QuantizationProduct QuantizationType = "product"
+	// QuantizationBinary keeps the sign of every dimension as one bit and compares
+	// codes by their Hamming distance; it needs no training
+	QuantizationBinary QuantizationType = "binary"
+)
+
+/*
+QuantizationConfig is the configuration for vector quantization.
+
+The codec is trained in the background on a random sample of TrainingSize vectors
+once the database holds that many; until it is trained the graph uses the
+full-precision vectors. Searches traverse the graph with approximate distances on
+the codes and rerank their candidate list with the full-precision vectors, unless
+NoRescore is set.
+*/
+type QuantizationConfig struct {
+	// codec, empty to disable quantization
+	Type QuantizationType `json:"type,omitempty"`
+	// number of vectors the codec is trained on (0 uses the default)
+	TrainingSize int `json:"training_size,omitempty"`
+	// number of subspaces of the product codec, which must divide the dimensions
+	// (0 picks subspaces of at least 4 dimensions)
+	Subspaces int `json:"subspaces,omitempty"`
+	// return k-nearest results in the order and with the distances measured on the
+	// codes, skipping the rerank with the full-precision vectors; binary codes are
+	// then scored 1 - 2*hamming/dimensions. Range searches always rerank, since
+	// their radius is a full-precision distance.
+	NoRescore bool `json:"no_rescore,omitempty"`
 }
 
 /*
@@ -51,29 +96,99 @@ type DistanceType int
 
 const (
 	DistanceTypeEuclidean DistanceType = iota
+	// Vectors of cosine databases are compared normalized to unit length, and
+	// stored as they were written
 	DistanceTypeCosine    DistanceType = iota
 	DistanceTypeManhattan DistanceType = iota
 	DistanceTypeHamming   DistanceType = iota
 )
 
+/*
+WALSyncPolicy decides how often the write-ahead log is forced to disk.
+*/
+type WALSyncPolicy string
+
+const (
+	// WALSyncAlways fsyncs after every write
+	WALSyncAlways WALSyncPolicy = "always"
+	// WALSyncBatch fsyncs after every WALBatchSize writes
+	WALSyncBatch WALSyncPolicy = "batch"
+	// WALSyncInterval fsyncs every WALSyncInterval milliseconds
+	WALSyncInterval WALSyncPolicy = "interval"
+)
+
 /*
 StorageConfig is the configuration for the storage.
 */
 type StorageConfig struct {
 	// path to the data file
 	DataPath string `json:"data_path"`
-	// whether to use persistence engine
+	// whether to use persistence engine; when false all databases live in memory only
 	PersistenceEngine bool `json:"persistence_engine"`
 	// interval to persist data [seconds]
 	PersistenceInterval int `json:"persistence_interval"`
+	// whether to record writes in a write-ahead log between snapshots
+	WAL bool `json:"wal"`
+	// fsync policy for the write-ahead log
+	WALSyncPolicy WALSyncPolicy `json:"wal_sync_policy"`
+	// number of writes between fsyncs for the batch policy
+	WALBatchSize int `json:"wal_batch_size"`
+	// interval between fsyncs for the interval policy [milliseconds]
+	WALSyncInterval int `json:"wal_sync_interval"`
+	// number of snapshots kept per database, the newest first
+	SnapshotRetention int `json:"snapshot_retention"`
 }
 
+/*
+IndexType is the kind of secondary index kept on a metadata field.
+*/
+type IndexType string
+
+const (
+	// IndexTypeHash supports equality and in-list lookups on keyword-like values
+	IndexTypeHash IndexType = "hash"
+	// IndexTypeSorted supports equality and range lookups on numbers and timestamps
+	IndexTypeSorted IndexType = "sorted"
+)
+
+/*
+IndexConfig declares a secondary index on a metadata field.
+*/
+type IndexConfig struct {
+	// metadata key to index
+	Field string `json:"field"`
+	// kind of index
+	Type IndexType `json:"type"`
+}
+
+/*
+Durability decides how a database is kept on disk.
+*/
+type Durability string
+
+const (
+	// DurabilityDefault follows the storage configuration
+	DurabilityDefault Durability = ""
+	// DurabilityEphemeral keeps the database in memory only
+	DurabilityEphemeral Durability = "ephemeral"
+	// DurabilitySnapshot persists the database by periodic snapshots without a write-ahead log
+	DurabilitySnapshot Durability = "snapshot"
+	// DurabilityWAL persists the database by snapshots and a write-ahead log
+	DurabilityWAL Durability = "wal"
+)
+
 /*
 DatabaseConfig represents the configuration for a single vector database.
 */
 type DatabaseConfig struct {
 	HNSW HNSWConfig `json:"hnsw"`
-	// Additional database-specific settings can be added here
+	// secondary indexes on metadata fields
+	Indexes []IndexConfig `json:"indexes,omitempty"`
+	// largest fraction of the database a filter may select for a brute-force scan
+	// to be used instead of a graph traversal (0 uses the default)
+	ScanThreshold float64 `json:"scan_threshold,omitempty"`
+	// how the database is kept on disk; ignored when the persistence engine is disabled
+	Durability Durability `json:"durability,omitempty"`
 }
 
 /*
@@ -91,6 +206,11 @@ func DefaultConfig() *Config {
 			DataPath:            "./data",
 			PersistenceEngine:   true,
 			PersistenceInterval: 5,
+			WAL:                 true,
+			WALSyncPolicy:       WALSyncInterval,
+			WALBatchSize:        64,
+			WALSyncInterval:     1000,
+			SnapshotRetention:   3,
 		},
 		// default database configuration
 		Databases: map[string]DatabaseConfig{
@@ -194,6 +314,16 @@ func LoadFromEnv() (*Config, error) {
 		}
 	}
 
+	if walStr := os.Getenv("GORAC_WAL_ENABLED"); walStr != "" {
+		if wal, err := strconv.ParseBool(walStr); err == nil {
+			config.Storage.WAL = wal
+		}
+	}
+
+	if policy := os.Getenv("GORAC_WAL_SYNC_POLICY"); policy != "" {
+		config.Storage.WALSyncPolicy = WALSyncPolicy(policy)
+	}
+
 	return config, nil
 }
 
@@ -207,6 +337,24 @@ func (c *Config) Validate() error {
 	if c.DefaultDatabase.HNSW.Dimensions <= 0 {
 		return fmt.Errorf("invalid dimensions: %d", c.DefaultDatabase.HNSW.Dimensions)
 	}
+	if c.DefaultDatabase.HNSW.EfSearch < 0 {
+		return fmt.Errorf("invalid efSearch value: %d", c.DefaultDatabase.HNSW.EfSearch)
+	}
+	return nil
+}
+
+/*
+Validate checks if the storage configuration is valid
+*/
+func (s *StorageConfig) Validate() error {
+	switch s.WALSyncPolicy {
+	case WALSyncAlways, WALSyncBatch, WALSyncInterval:
+	default:
+		return fmt.Errorf("invalid WAL sync policy: %q", s.WALSyncPolicy)
+	}
+	if s.SnapshotRetention < 1 {
+		return fmt.Errorf("invalid snapshot retention: %d", s.SnapshotRetention)
+	}
 	return nil
 }
 
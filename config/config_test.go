@@ -91,3 +91,21 @@ func TestParseDistanceType(t *testing.T) {
 		}
 	}
 }
+
+func TestStorageConfigValidation(t *testing.T) {
+	storage := DefaultConfig().Storage
+	if err := storage.Validate(); err != nil {
+		t.Errorf("Default storage config should be valid: %v", err)
+	}
+
+	storage.WALSyncPolicy = "sometimes"
+	if err := storage.Validate(); err == nil {
+		t.Error("Storage config with unknown WAL sync policy should return error")
+	}
+
+	storage = DefaultConfig().Storage
+	storage.SnapshotRetention = 0
+	if err := storage.Validate(); err == nil {
+		t.Error("Storage config keeping no snapshots should return error")
+	}
+}
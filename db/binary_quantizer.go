@@ -0,0 +1,86 @@
+package db
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"vector-db/config"
+)
+
+/*
+binaryQuantizer keeps one bit per dimension, set when the value is positive, packed
+into 64-bit words stored little-endian in the code. A code takes 1/32 of the memory
+of the full-precision vector.
+
+The distance between a query and a code is the Hamming distance between their bits,
+counted a word at a time. It only approximates the configured metric, which suits
+centered embeddings compared by cosine best, so it is meant as a coarse first pass
+before the candidates are rescored with the full-precision vectors.
+*/
+type binaryQuantizer struct {
+	// number of dimensions of the vectors
+	dimensions int
+	// number of 64-bit words per code
+	words int
+}
+
+/*
+newBinaryQuantizer builds a binary codec for vectors of the given dimensions
+*/
+func newBinaryQuantizer(dimensions int) *binaryQuantizer {
+	return &binaryQuantizer{dimensions: dimensions, words: (dimensions + 63) / 64}
+}
+
+func (q *binaryQuantizer) encode(vector []float32) []byte {
+	code := make([]byte, 8*q.words)
+	for i, value := range vector {
+		if value > 0 {
+			code[i/8] |= 1 << (i % 8)
+		}
+	}
+	return code
+}
+
+/*
+decode maps every bit to 1 or -1, so distances between decoded vectors grow with the
+Hamming distance between their codes
+*/
+func (q *binaryQuantizer) decode(code []byte) []float32 {
+	vector := make([]float32, q.dimensions)
+	for i := range vector {
+		if code[i/8]&(1<<(i%8)) != 0 {
+			vector[i] = 1
+		} else {
+			vector[i] = -1
+		}
+	}
+	return vector
+}
+
+func (q *binaryQuantizer) queryDistance(query []float32) func(code []byte) float32 {
+	queryBits := q.encode(query)
+	queryWords := make([]uint64, q.words)
+	for w := range queryWords {
+		queryWords[w] = binary.LittleEndian.Uint64(queryBits[8*w:])
+	}
+
+	return func(code []byte) float32 {
+		distance := 0
+		for w, word := range queryWords {
+			distance += bits.OnesCount64(word ^ binary.LittleEndian.Uint64(code[8*w:]))
+		}
+		return float32(distance)
+	}
+}
+
+/*
+score maps a Hamming distance to 1 - 2*hamming/dimensions, which runs from 1 for
+codes with the same signs to -1 for opposite ones like a cosine similarity
+*/
+func (q *binaryQuantizer) score(hamming float32) float32 {
+	return 1 - 2*hamming/float32(q.dimensions)
+}
+
+func (q *binaryQuantizer) file() *quantizerFile {
+	return &quantizerFile{Type: config.QuantizationBinary}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"math"
+
+	"vector-db/config"
+)
+
+// unitNormTolerance is how far the squared norm of a vector may be from 1 for the
+// vector to be treated as already normalized
+const unitNormTolerance = 1e-5
+
+/*
+distanceFunc resolves the distance between two vectors compared in a graph of the
+given distance type. Cosine graphs compare vectors normalized to unit length, so the
+cosine distance between two of them is a single dot product.
+*/
+func distanceFunc(distanceType config.DistanceType) func(a, b []float32) float32 {
+	switch distanceType {
+	case config.DistanceTypeCosine:
+		return unitCosineDistance
+	case config.DistanceTypeManhattan:
+		return l1Distance
+	case config.DistanceTypeHamming:
+		return hammingDistance
+	default:
+		return euclideanDistance
+	}
+}
+
+/*
+euclideanDistance calculates the Euclidean distance between two vectors
+*/
+func euclideanDistance(a, b []float32) float32 {
+	return float32(math.Sqrt(float64(l2Squared(a, b))))
+}
+
+/*
+cosineDistance calculates the cosine distance between two vectors of any length
+*/
+func cosineDistance(a, b []float32) float32 {
+	return cosineDistanceFromParts(innerProduct(a, b), innerProduct(a, a), innerProduct(b, b))
+}
+
+/*
+unitCosineDistance calculates the cosine distance between two vectors of unit length,
+or between a unit vector and the zero vector
+*/
+func unitCosineDistance(a, b []float32) float32 {
+	return similarityDistance(innerProduct(a, b))
+}
+
+/*
+similarityDistance turns a cosine similarity into a cosine distance
+*/
+func similarityDistance(similarity float32) float32 {
+	// Clamp similarity to [-1, 1] due to floating point precision
+	if similarity > 1.0 {
+		similarity = 1.0
+	} else if similarity < -1.0 {
+		similarity = -1.0
+	}
+	return 1.0 - similarity
+}
+
+/*
+hammingDistance counts the dimensions in which two vectors differ
+*/
+func hammingDistance(a, b []float32) float32 {
+	b = b[:len(a)]
+	var sum float32
+	for i := range a {
+		if a[i] != b[i] {
+			sum++
+		}
+	}
+	return sum
+}
+
+/*
+normalize scales a vector to unit length in place. Zero vectors, and vectors already
+of unit length, are left as they are, so normalizing is idempotent.
+*/
+func normalize(v []float32) {
+	scale := unitScale(v)
+	if scale == 1 {
+		return
+	}
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+/*
+unitScale returns the factor normalize scales a vector by: the inverse of its norm,
+or 1 for zero vectors and vectors already of unit length
+*/
+func unitScale(v []float32) float32 {
+	norm := innerProduct(v, v)
+	if norm == 0 || math.Abs(float64(norm)-1) <= unitNormTolerance {
+		return 1
+	}
+	return float32(1 / math.Sqrt(float64(norm)))
+}
+
+/*
+l2SquaredGeneric is the portable squared Euclidean distance kernel. It keeps four
+independent sums, so consecutive additions do not wait for each other, and reslices
+b to the length of a, so the compiler drops the bounds checks of the loop.
+*/
+func l2SquaredGeneric(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		d0 := a[i] - b[i]
+		d1 := a[i+1] - b[i+1]
+		d2 := a[i+2] - b[i+2]
+		d3 := a[i+3] - b[i+3]
+		s0 += d0 * d0
+		s1 += d1 * d1
+		s2 += d2 * d2
+		s3 += d3 * d3
+	}
+	for ; i < len(a); i++ {
+		d := a[i] - b[i]
+		s0 += d * d
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+/*
+innerProductGeneric is the portable dot product kernel, unrolled like l2SquaredGeneric
+*/
+func innerProductGeneric(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+/*
+l1DistanceGeneric is the portable Manhattan distance kernel, unrolled like l2SquaredGeneric
+*/
+func l1DistanceGeneric(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += abs32(a[i] - b[i])
+		s1 += abs32(a[i+1] - b[i+1])
+		s2 += abs32(a[i+2] - b[i+2])
+		s3 += abs32(a[i+3] - b[i+3])
+	}
+	for ; i < len(a); i++ {
+		s0 += abs32(a[i] - b[i])
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+/*
+abs32 returns the absolute value of x by clearing its sign bit
+*/
+func abs32(x float32) float32 {
+	return math.Float32frombits(math.Float32bits(x) &^ (1 << 31))
+}
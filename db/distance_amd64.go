@@ -0,0 +1,54 @@
+//go:build amd64 && !purego
+
+package db
+
+import "golang.org/x/sys/cpu"
+
+// avx2MinLength is the shortest vector the AVX2 kernels are used for; below it the
+// call overhead outweighs the wider registers
+const avx2MinLength = 16
+
+// useAVX2 reports whether the processor runs the AVX2 kernels, which also need FMA
+var useAVX2 = cpu.X86.HasAVX2 && cpu.X86.HasFMA
+
+/*
+l2Squared returns the squared Euclidean distance between two vectors
+*/
+func l2Squared(a, b []float32) float32 {
+	if useAVX2 && len(a) >= avx2MinLength {
+		return l2SquaredAVX2(a, b[:len(a)])
+	}
+	return l2SquaredGeneric(a, b)
+}
+
+/*
+innerProduct returns the dot product of two vectors
+*/
+func innerProduct(a, b []float32) float32 {
+	if useAVX2 && len(a) >= avx2MinLength {
+		return innerProductAVX2(a, b[:len(a)])
+	}
+	return innerProductGeneric(a, b)
+}
+
+/*
+l1Distance returns the Manhattan distance between two vectors
+*/
+func l1Distance(a, b []float32) float32 {
+	if useAVX2 && len(a) >= avx2MinLength {
+		return l1DistanceAVX2(a, b[:len(a)])
+	}
+	return l1DistanceGeneric(a, b)
+}
+
+// The AVX2 kernels are implemented in distance_amd64.s. They read len(a) values from
+// both slices, so b must be at least as long as a.
+
+//go:noescape
+func l2SquaredAVX2(a, b []float32) float32
+
+//go:noescape
+func innerProductAVX2(a, b []float32) float32
+
+//go:noescape
+func l1DistanceAVX2(a, b []float32) float32
@@ -0,0 +1,24 @@
+//go:build !amd64 || purego
+
+package db
+
+/*
+l2Squared returns the squared Euclidean distance between two vectors
+*/
+func l2Squared(a, b []float32) float32 {
+	return l2SquaredGeneric(a, b)
+}
+
+/*
+innerProduct returns the dot product of two vectors
+*/
+func innerProduct(a, b []float32) float32 {
+	return innerProductGeneric(a, b)
+}
+
+/*
+l1Distance returns the Manhattan distance between two vectors
+*/
+func l1Distance(a, b []float32) float32 {
+	return l1DistanceGeneric(a, b)
+}
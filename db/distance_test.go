@@ -0,0 +1,239 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"vector-db/config"
+)
+
+// The straightforward loops the kernels replace, used as reference and baseline
+
+func naiveL2Squared(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		diff := a[i] - b[i]
+		sum += diff * diff
+	}
+	return sum
+}
+
+func naiveInnerProduct(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func naiveL1Distance(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += float32(math.Abs(float64(a[i] - b[i])))
+	}
+	return sum
+}
+
+func naiveCosineDistance(a, b []float32) float32 {
+	var dot, normA, normB float32
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	return cosineDistanceFromParts(dot, normA, normB)
+}
+
+func TestDistanceKernels(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	kernels := []struct {
+		name      string
+		kernel    func(a, b []float32) float32
+		generic   func(a, b []float32) float32
+		reference func(a, b []float64) float64
+	}{
+		{"l2Squared", l2Squared, l2SquaredGeneric, func(a, b []float64) float64 {
+			var sum float64
+			for i := range a {
+				sum += (a[i] - b[i]) * (a[i] - b[i])
+			}
+			return sum
+		}},
+		{"innerProduct", innerProduct, innerProductGeneric, func(a, b []float64) float64 {
+			var sum float64
+			for i := range a {
+				sum += a[i] * b[i]
+			}
+			return sum
+		}},
+		{"l1Distance", l1Distance, l1DistanceGeneric, func(a, b []float64) float64 {
+			var sum float64
+			for i := range a {
+				sum += math.Abs(a[i] - b[i])
+			}
+			return sum
+		}},
+	}
+
+	// Every length up to a few unrolled blocks exercises each tail, and b is longer
+	// than a to check that only len(a) values are read
+	lengths := []int{1000, 1536}
+	for n := 0; n <= 100; n++ {
+		lengths = append(lengths, n)
+	}
+	for _, n := range lengths {
+		a, b := make([]float32, n), make([]float32, n+3)
+		a64, b64 := make([]float64, n), make([]float64, n)
+		for i := range b {
+			b[i] = rng.Float32()*2 - 1
+			if i < n {
+				a[i] = rng.Float32()*2 - 1
+				a64[i], b64[i] = float64(a[i]), float64(b[i])
+			}
+		}
+
+		for _, k := range kernels {
+			want := k.reference(a64, b64)
+			tolerance := 1e-5 * (1 + float64(n))
+			if got := k.kernel(a, b); math.Abs(float64(got)-want) > tolerance {
+				t.Errorf("%s of length %d: got %f, want %f", k.name, n, got, want)
+			}
+			if got := k.generic(a, b); math.Abs(float64(got)-want) > tolerance {
+				t.Errorf("generic %s of length %d: got %f, want %f", k.name, n, got, want)
+			}
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	v := []float32{3, 0, 4}
+	normalize(v)
+	if want := []float32{0.6, 0, 0.8}; naiveL2Squared(v, want) > 1e-12 {
+		t.Errorf("Expected %v, got %v", want, v)
+	}
+
+	// Normalizing a unit vector again leaves it as it is
+	again := append([]float32(nil), v...)
+	normalize(again)
+	if !vectorDataEqual(again, v) {
+		t.Errorf("Normalizing %v again gave %v", v, again)
+	}
+
+	zero := []float32{0, 0, 0}
+	normalize(zero)
+	if !vectorDataEqual(zero, []float32{0, 0, 0}) {
+		t.Errorf("Expected the zero vector to stay zero, got %v", zero)
+	}
+}
+
+func TestHNSWCosineNormalization(t *testing.T) {
+	const dimensions = 32
+	graph := NewHNSWGraph(8, 100, config.DistanceTypeCosine)
+	vectors := randomVectors(100, dimensions)
+	for i := range vectors {
+		// Scale the vectors so they are far from unit length
+		for j := range vectors[i].Data {
+			vectors[i].Data[j] *= 10
+		}
+		if err := graph.Insert(vectors[i]); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// The inserted components are stored as they are, with the factor normalizing
+	// them, and the inserted vectors are left untouched
+	stored := graphVector(graph, "0").Data
+	if !vectorDataEqual(stored, vectors[0].Data) {
+		t.Errorf("Expected the inserted components to be stored, got %v", stored)
+	}
+	unit := graph.unitVector(stored, graph.nodeByID("0").scale)
+	if norm := innerProduct(unit, unit); math.Abs(float64(norm)-1) > unitNormTolerance {
+		t.Errorf("Expected the stored factor to normalize the vector, got squared norm %f", norm)
+	}
+	if norm := innerProduct(vectors[0].Data, vectors[0].Data); norm < 2 {
+		t.Errorf("Expected the inserted vector to be left unnormalized, got squared norm %f", norm)
+	}
+
+	// Distances do not depend on the length of the vectors
+	if exact := naiveCosineDistance(vectors[0].Data, vectors[1].Data); math.Abs(float64(graph.Distance(vectors[0].Data, vectors[1].Data)-exact)) > 1e-5 {
+		t.Errorf("Expected distance %f, got %f", exact, graph.Distance(vectors[0].Data, vectors[1].Data))
+	}
+	query := make([]float32, dimensions)
+	for j := range query {
+		query[j] = vectors[0].Data[j] / 1000
+	}
+	results, err := graph.SearchWithOptions(query, 5, SearchOptions{})
+	if err != nil || len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %v (err %v)", results, err)
+	}
+	if results[0].ID != "0" || results[0].Distance > 1e-6 {
+		t.Errorf("Expected vector 0 at distance 0 first, got %s at %f", results[0].ID, results[0].Distance)
+	}
+	for _, result := range results {
+		exact := naiveCosineDistance(query, result.Data)
+		if math.Abs(float64(result.Distance-exact)) > 1e-5 {
+			t.Errorf("Result %s has distance %f, exact distance is %f", result.ID, result.Distance, exact)
+		}
+	}
+
+	// Updating a vector with the same data only replaces its metadata
+	arena := graph.nodeByID("0").vector.Data
+	if err := graph.Update(Vector{ID: "0", Data: vectors[0].Data, Metadata: map[string]interface{}{"updated": true}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if updated := graph.nodeByID("0").vector.Data; &updated[0] != &arena[0] {
+		t.Errorf("Expected a metadata-only update to keep the stored vector")
+	}
+
+	// The zero vector is stored as it is, at the maximum distance from everything
+	if err := graph.Insert(Vector{ID: "zero", Data: make([]float32, dimensions)}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if distance := graph.Distance(graphVector(graph, "zero").Data, stored); distance != 1 {
+		t.Errorf("Expected distance 1 from the zero vector, got %f", distance)
+	}
+}
+
+// distanceSink keeps benchmarked distances from being optimized away
+var distanceSink float32
+
+func BenchmarkDistance(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	for _, dimensions := range []int{128, 768} {
+		x, y := make([]float32, dimensions), make([]float32, dimensions)
+		for i := range x {
+			x[i], y[i] = rng.Float32(), rng.Float32()
+		}
+		unitX, unitY := append([]float32(nil), x...), append([]float32(nil), y...)
+		normalize(unitX)
+		normalize(unitY)
+
+		benchmarks := []struct {
+			name     string
+			distance func(a, b []float32) float32
+			a, b     []float32
+		}{
+			{"l2Squared/naive", naiveL2Squared, x, y},
+			{"l2Squared/generic", l2SquaredGeneric, x, y},
+			{"l2Squared/kernel", l2Squared, x, y},
+			{"innerProduct/naive", naiveInnerProduct, x, y},
+			{"innerProduct/generic", innerProductGeneric, x, y},
+			{"innerProduct/kernel", innerProduct, x, y},
+			{"l1Distance/naive", naiveL1Distance, x, y},
+			{"l1Distance/generic", l1DistanceGeneric, x, y},
+			{"l1Distance/kernel", l1Distance, x, y},
+			// Cosine recomputing both norms against a dot product of unit vectors
+			{"cosine/naive", naiveCosineDistance, x, y},
+			{"cosine/normalized", unitCosineDistance, unitX, unitY},
+		}
+		for _, bm := range benchmarks {
+			b.Run(fmt.Sprintf("%s/%d", bm.name, dimensions), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					distanceSink = bm.distance(bm.a, bm.b)
+				}
+			})
+		}
+	}
+}
@@ -9,9 +9,18 @@ var (
 	// ErrDatabaseNotFound is returned when trying to access a non-existent database
 	ErrDatabaseNotFound = errors.New("database not found")
 
+	// ErrVectorExists is returned when trying to add a vector whose ID is already taken
+	ErrVectorExists = errors.New("vector already exists")
+
 	// ErrVectorNotFound is returned when trying to access a non-existent vector
 	ErrVectorNotFound = errors.New("vector not found")
 
 	// ErrInvalidDimensions is returned when vector dimensions don't match the database configuration
 	ErrInvalidDimensions = errors.New("invalid vector dimensions")
+
+	// ErrInvalidFilter is returned when a metadata filter expression is malformed
+	ErrInvalidFilter = errors.New("invalid filter")
+
+	// ErrInvalidIndex is returned when a secondary index declaration is malformed
+	ErrInvalidIndex = errors.New("invalid index configuration")
 )
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+/*
+FilterOp is the operator of a metadata filter expression
+*/
+type FilterOp string
+
+const (
+	FilterEq     FilterOp = "eq"
+	FilterNe     FilterOp = "ne"
+	FilterGt     FilterOp = "gt"
+	FilterGte    FilterOp = "gte"
+	FilterLt     FilterOp = "lt"
+	FilterLte    FilterOp = "lte"
+	FilterIn     FilterOp = "in"
+	FilterExists FilterOp = "exists"
+	FilterAnd    FilterOp = "and"
+	FilterOr     FilterOp = "or"
+	FilterNot    FilterOp = "not"
+)
+
+/*
+Filter is a boolean expression over vector metadata.
+
+Leaf expressions compare the metadata value stored under Key:
+- eq, ne: equality against Value (numbers compare by value regardless of their Go type)
+- gt, gte, lt, lte: range comparison against Value, for numbers or strings
+- in: equality against any of Values
+- exists: the key is present with a non-nil value
+
+Compound expressions combine the expressions in Filters:
+- and, or: all or any of the sub-filters match
+- not: the single sub-filter does not match
+
+Filters are plain data so they can be decoded directly from JSON, e.g.
+
+	{"op": "and", "filters": [
+		{"op": "eq", "key": "tenant", "value": "acme"},
+		{"op": "gte", "key": "year", "value": 2020}
+	]}
+*/
+type Filter struct {
+	Op      FilterOp      `json:"op"`
+	Key     string        `json:"key,omitempty"`
+	Value   interface{}   `json:"value,omitempty"`
+	Values  []interface{} `json:"values,omitempty"`
+	Filters []*Filter     `json:"filters,omitempty"`
+}
+
+/*
+Validate checks that the filter expression is well formed
+*/
+func (f *Filter) Validate() error {
+	if f == nil {
+		return fmt.Errorf("%w: empty expression", ErrInvalidFilter)
+	}
+
+	switch f.Op {
+	case FilterEq, FilterNe, FilterGt, FilterGte, FilterLt, FilterLte:
+		if f.Key == "" {
+			return fmt.Errorf("%w: %s requires a key", ErrInvalidFilter, f.Op)
+		}
+		if f.Value == nil {
+			return fmt.Errorf("%w: %s requires a value", ErrInvalidFilter, f.Op)
+		}
+	case FilterIn:
+		if f.Key == "" {
+			return fmt.Errorf("%w: %s requires a key", ErrInvalidFilter, f.Op)
+		}
+		if len(f.Values) == 0 {
+			return fmt.Errorf("%w: %s requires values", ErrInvalidFilter, f.Op)
+		}
+	case FilterExists:
+		if f.Key == "" {
+			return fmt.Errorf("%w: %s requires a key", ErrInvalidFilter, f.Op)
+		}
+	case FilterAnd, FilterOr:
+		if len(f.Filters) == 0 {
+			return fmt.Errorf("%w: %s requires at least one sub-filter", ErrInvalidFilter, f.Op)
+		}
+	case FilterNot:
+		if len(f.Filters) != 1 {
+			return fmt.Errorf("%w: %s requires exactly one sub-filter", ErrInvalidFilter, f.Op)
+		}
+	default:
+		return fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, f.Op)
+	}
+
+	for _, sub := range f.Filters {
+		if err := sub.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Match reports whether the given metadata satisfies the filter.
+
+A nil filter matches everything. Comparisons against a missing key never match,
+except for ne, which treats a missing value as different from anything.
+*/
+func (f *Filter) Match(metadata map[string]interface{}) bool {
+	if f == nil {
+		return true
+	}
+
+	switch f.Op {
+	case FilterAnd:
+		for _, sub := range f.Filters {
+			if !sub.Match(metadata) {
+				return false
+			}
+		}
+		return true
+	case FilterOr:
+		for _, sub := range f.Filters {
+			if sub.Match(metadata) {
+				return true
+			}
+		}
+		return false
+	case FilterNot:
+		return len(f.Filters) == 1 && !f.Filters[0].Match(metadata)
+	}
+
+	value, exists := metadata[f.Key]
+	if exists && value == nil {
+		exists = false
+	}
+
+	switch f.Op {
+	case FilterExists:
+		return exists
+	case FilterNe:
+		return !exists || !valuesEqual(value, f.Value)
+	}
+
+	if !exists {
+		return false
+	}
+
+	switch f.Op {
+	case FilterEq:
+		return valuesEqual(value, f.Value)
+	case FilterIn:
+		for _, candidate := range f.Values {
+			if valuesEqual(value, candidate) {
+				return true
+			}
+		}
+		return false
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		cmp, ok := compareValues(value, f.Value)
+		if !ok {
+			return false
+		}
+		switch f.Op {
+		case FilterGt:
+			return cmp > 0
+		case FilterGte:
+			return cmp >= 0
+		case FilterLt:
+			return cmp < 0
+		default:
+			return cmp <= 0
+		}
+	}
+
+	return false
+}
+
+/*
+valuesEqual compares two metadata values, treating all numeric types as equivalent
+*/
+func valuesEqual(a, b interface{}) bool {
+	if af, ok := toFloat64(a); ok {
+		bf, ok := toFloat64(b)
+		return ok && af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+/*
+compareValues orders two numbers or two strings, returning false for any other combination
+*/
+func compareValues(a, b interface{}) (int, bool) {
+	if af, ok := toFloat64(a); ok {
+		bf, ok := toFloat64(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	as, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+	bs, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case as < bs:
+		return -1, true
+	case as > bs:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+/*
+toFloat64 converts any Go numeric type (and json.Number) to float64
+*/
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	metadata := map[string]interface{}{
+		"tenant": "acme",
+		"year":   2021,
+		"score":  0.75,
+		"lang":   "en",
+		"draft":  false,
+		"empty":  nil,
+	}
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		expect bool
+	}{
+		{"nil filter", nil, true},
+		{"eq string", &Filter{Op: FilterEq, Key: "tenant", Value: "acme"}, true},
+		{"eq string mismatch", &Filter{Op: FilterEq, Key: "tenant", Value: "other"}, false},
+		{"eq int against float", &Filter{Op: FilterEq, Key: "year", Value: 2021.0}, true},
+		{"eq bool", &Filter{Op: FilterEq, Key: "draft", Value: false}, true},
+		{"eq missing key", &Filter{Op: FilterEq, Key: "missing", Value: "x"}, false},
+		{"ne", &Filter{Op: FilterNe, Key: "lang", Value: "de"}, true},
+		{"ne missing key", &Filter{Op: FilterNe, Key: "missing", Value: "x"}, true},
+		{"gt", &Filter{Op: FilterGt, Key: "year", Value: 2020}, true},
+		{"gt equal", &Filter{Op: FilterGt, Key: "year", Value: 2021}, false},
+		{"gte equal", &Filter{Op: FilterGte, Key: "year", Value: 2021}, true},
+		{"lt float", &Filter{Op: FilterLt, Key: "score", Value: 0.8}, true},
+		{"lte", &Filter{Op: FilterLte, Key: "score", Value: 0.5}, false},
+		{"range on strings", &Filter{Op: FilterGte, Key: "lang", Value: "de"}, true},
+		{"range type mismatch", &Filter{Op: FilterGt, Key: "tenant", Value: 1}, false},
+		{"in", &Filter{Op: FilterIn, Key: "lang", Values: []interface{}{"de", "en"}}, true},
+		{"in mismatch", &Filter{Op: FilterIn, Key: "lang", Values: []interface{}{"de", "fr"}}, false},
+		{"exists", &Filter{Op: FilterExists, Key: "tenant"}, true},
+		{"exists nil value", &Filter{Op: FilterExists, Key: "empty"}, false},
+		{"and", &Filter{Op: FilterAnd, Filters: []*Filter{
+			{Op: FilterEq, Key: "tenant", Value: "acme"},
+			{Op: FilterGte, Key: "year", Value: 2022},
+		}}, false},
+		{"or", &Filter{Op: FilterOr, Filters: []*Filter{
+			{Op: FilterEq, Key: "tenant", Value: "other"},
+			{Op: FilterGte, Key: "year", Value: 2020},
+		}}, true},
+		{"not", &Filter{Op: FilterNot, Filters: []*Filter{
+			{Op: FilterEq, Key: "draft", Value: true},
+		}}, true},
+	}
+
+	for _, test := range tests {
+		if got := test.filter.Match(metadata); got != test.expect {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expect, got)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	valid := []*Filter{
+		{Op: FilterEq, Key: "a", Value: 1},
+		{Op: FilterIn, Key: "a", Values: []interface{}{1, 2}},
+		{Op: FilterExists, Key: "a"},
+		{Op: FilterNot, Filters: []*Filter{{Op: FilterExists, Key: "a"}}},
+	}
+	for _, filter := range valid {
+		if err := filter.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", filter, err)
+		}
+	}
+
+	invalid := []*Filter{
+		{Op: "like", Key: "a", Value: 1},
+		{Op: FilterEq, Value: 1},
+		{Op: FilterGt, Key: "a"},
+		{Op: FilterIn, Key: "a"},
+		{Op: FilterAnd},
+		{Op: FilterNot, Filters: []*Filter{{Op: FilterExists, Key: "a"}, {Op: FilterExists, Key: "b"}}},
+		{Op: FilterOr, Filters: []*Filter{{Op: FilterEq, Key: "a"}}},
+	}
+	for _, filter := range invalid {
+		if err := filter.Validate(); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected ErrInvalidFilter for %+v, got %v", filter, err)
+		}
+	}
+}
+
+func TestFilterFromJSON(t *testing.T) {
+	raw := `{"op": "and", "filters": [
+		{"op": "eq", "key": "tenant", "value": "acme"},
+		{"op": "in", "key": "year", "values": [2020, 2021]}
+	]}`
+
+	var filter Filter
+	if err := json.Unmarshal([]byte(raw), &filter); err != nil {
+		t.Fatalf("Failed to decode filter: %v", err)
+	}
+	if err := filter.Validate(); err != nil {
+		t.Fatalf("Decoded filter is invalid: %v", err)
+	}
+	if !filter.Match(map[string]interface{}{"tenant": "acme", "year": 2021}) {
+		t.Error("Expected decoded filter to match")
+	}
+}
@@ -1,17 +1,23 @@
 package db
 
 import (
-	"container/heap"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"sort"
 	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
 
 	"vector-db/config"
 )
 
+// defaultQualityThreshold lets candidates up to 10% worse than the worst result be
+// explored before a layer search stops
+const defaultQualityThreshold = 1.1
+
 /*
 HNSWGraph represents the Hierarchical Navigable Small World graph structure.
 
@@ -24,6 +30,23 @@ Key parameters:
 - M: Controls the maximum number of connections per node in the graph
 - EfConstruction: Controls the size of the dynamic candidate list during graph construction
 - EfSearch: Controls the size of the dynamic candidate list during search
+
+Storage: vector IDs are mapped to dense uint32 internal IDs, which index nodes and
+vector components kept in chunks of contiguous memory. Adjacency lists hold internal
+IDs, so a search never touches the string IDs until it builds its results, and it
+tracks visited nodes in a pooled bitset instead of a map. The IDs of deleted
+vectors are handed out again, so the arena does not grow with deletions and updates.
+
+Concurrency: insertions and searches only share the graph lock and synchronize on
+the adjacency list of each node they touch and on the entry point, so they proceed
+in parallel. Deletions and relinking updates rewrite the links of many nodes and
+take the graph lock exclusively.
+
+Quantization: once a codec is configured and trained, only the nodes' compact codes
+stay in memory and the graph is traversed with approximate distances computed on
+them. The full-precision vectors move to an on-disk store, from which the candidates
+of a search are reranked before they are returned, unless the configuration opts
+out of rescoring k-nearest searches.
 */
 type HNSWGraph struct {
 	// Maximum number of connections per layer
@@ -32,20 +55,139 @@ type HNSWGraph struct {
 	EfConstruction int
 	// Size of the dynamic candidate list during search
 	EfSearch int
-	// Maximum layer
+	// Maximum layer, guarded by entryMu
 	MaxLayer int
-	// Entry point
+	// Entry point, guarded by entryMu
 	EntryPoint string
-	// Layers of the graph
-	Layers []map[string][]string
-	// Vector data
-	Vectors map[string]Vector
 	// Distance function
 	DistanceType config.DistanceType
-	// Mutex for thread safety
+	// Distance between stored vectors, resolved from DistanceType
+	distance func(a, b []float32) float32
+	// Held shared by insertions and searches, exclusively by deletions and relinks
 	mu sync.RWMutex
+	// Guards EntryPoint, entryID and MaxLayer
+	entryMu sync.RWMutex
+	// Internal ID of the entry point, valid while EntryPoint is set
+	entryID uint32
+	// Guards ids, free, dimensions and the allocation of internal IDs
+	idMu sync.RWMutex
+	// Internal IDs keyed by vector ID
+	ids map[string]uint32
+	// Internal IDs released by deletions, handed out again before new ones
+	free []uint32
+	// Nodes and vector components indexed by internal ID
+	store atomic.Pointer[nodeStore]
+	// Number of internal IDs allocated, released ones included
+	allocated atomic.Uint32
+	// Dimensions of the stored vectors, fixed by the first insertion
+	dimensions int
+	// Number of nodes
+	count atomic.Int64
 	// Normalization factor for level generation
 	mL float64
+	// Visited sets reused across searches
+	visitedPool sync.Pool
+	// Codec settings, set before the first insertion
+	quantization config.QuantizationConfig
+	// Trained codec, nil until enough vectors are stored to train it; only set while
+	// the graph lock is held exclusively
+	quantizer quantizer
+	// Whether the codec has been trained, readable without the graph lock
+	quantized atomic.Bool
+	// Full-precision vectors of a quantized graph, nil while they are kept in the
+	// arena; only set while the graph lock is held exclusively
+	disk *diskVectorStore
+	// Set by the one caller that trains the codec
+	training atomic.Bool
+	// Tracks the goroutine training the codec
+	trainer sync.WaitGroup
+}
+
+/*
+hnswNode is a vector together with its links in every layer it belongs to
+*/
+type hnswNode struct {
+	// Stored vector, whose components live in the arena, or on disk once the graph
+	// is quantized, leaving Data nil; only replaced while the graph lock is held
+	// exclusively
+	vector Vector
+	// Top layer assigned to the node
+	level int
+	// Factor scaling the stored components to unit length in cosine graphs, 1 otherwise
+	scale float32
+	// Vector encoded by the graph's codec, nil while the graph is not quantized
+	code []byte
+	// Whether the vector was deleted, leaving its internal ID free to be reused
+	deleted bool
+	// Guards links
+	mu sync.RWMutex
+	// links[l] holds the internal IDs of the neighbors in layer l, nil if the node
+	// has no list there. Lists are allocated with room for M neighbors and updated
+	// in place, so readers copy them while holding the lock.
+	links [][]uint32
+	// Guards inbound; taken after the lock of the node whose links change and never
+	// held while taking another lock
+	inMu sync.Mutex
+	// inbound[l] holds the internal IDs of the nodes linking to this one in layer l,
+	// so deleting a node repairs the nodes pointing at it without scanning the graph
+	inbound [][]uint32
+}
+
+/*
+appendNeighbors appends the adjacency list of the node in a layer to dst
+*/
+func (n *hnswNode) appendNeighbors(dst []uint32, layer int) []uint32 {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if layer < len(n.links) {
+		return append(dst, n.links[layer]...)
+	}
+	return dst
+}
+
+/*
+addInbound records that a node links to this one in a layer
+*/
+func (n *hnswNode) addInbound(layer int, id uint32) {
+	n.inMu.Lock()
+	defer n.inMu.Unlock()
+
+	for len(n.inbound) <= layer {
+		n.inbound = append(n.inbound, nil)
+	}
+	n.inbound[layer] = append(n.inbound[layer], id)
+}
+
+/*
+removeInbound records that a node no longer links to this one in a layer
+*/
+func (n *hnswNode) removeInbound(layer int, id uint32) {
+	n.inMu.Lock()
+	defer n.inMu.Unlock()
+
+	if layer >= len(n.inbound) {
+		return
+	}
+	inbound := n.inbound[layer]
+	for i, neighbor := range inbound {
+		if neighbor == id {
+			inbound[i] = inbound[len(inbound)-1]
+			n.inbound[layer] = inbound[:len(inbound)-1]
+			return
+		}
+	}
+}
+
+/*
+inDegree returns the number of nodes linking to this one in a layer. The caller must
+hold the graph lock exclusively.
+*/
+func (n *hnswNode) inDegree(layer int) int {
+	if layer >= len(n.inbound) {
+		return 0
+	}
+	return len(n.inbound[layer])
 }
 
 // Errors
@@ -83,16 +225,317 @@ func NewHNSWGraph(m, efConstruction int, distanceType config.DistanceType) *HNSW
 		ml = 1.0
 	}
 
-	return &HNSWGraph{
+	g := &HNSWGraph{
 		M:              m,
 		EfConstruction: efConstruction,
 		EfSearch:       efConstruction, // Default to same as construction
 		MaxLayer:       0,
-		Layers:         []map[string][]string{make(map[string][]string)},
-		Vectors:        make(map[string]Vector),
 		DistanceType:   distanceType,
+		distance:       distanceFunc(distanceType),
+		ids:            make(map[string]uint32),
 		mL:             ml,
 	}
+	g.store.Store(&nodeStore{})
+	return g
+}
+
+/*
+SetQuantization configures the codec the graph is traversed with. It must be called
+before any vector is inserted; the codec is trained once the graph holds the
+configured number of training vectors.
+*/
+func (g *HNSWGraph) SetQuantization(cfg config.QuantizationConfig, dimensions int) error {
+	if err := validateQuantization(cfg, dimensions); err != nil {
+		return err
+	}
+	if cfg.Type == config.QuantizationProduct && cfg.Subspaces == 0 {
+		cfg.Subspaces = defaultSubspaces(dimensions)
+	}
+	g.quantization = cfg
+	return nil
+}
+
+/*
+trainQuantizer starts training the configured codec once the graph holds enough
+vectors. The caller must not hold the graph lock.
+
+Training runs in the background on a random sample of at most the training size
+vectors, so the insertion that crosses the threshold does not wait for it; k-means
+can take a while for product codecs. Until the codec is in use the graph is
+traversed with exact distances, and insertions made meanwhile are encoded with the
+rest once it is.
+*/
+func (g *HNSWGraph) trainQuantizer() {
+	if g.quantization.Type == config.QuantizationNone || g.quantized.Load() {
+		return
+	}
+	trainingSize := g.quantization.TrainingSize
+	if trainingSize == 0 {
+		trainingSize = defaultQuantizationTrainingSize
+	}
+	if g.quantization.Type == config.QuantizationBinary {
+		// Binary codes have nothing to learn and only need the dimensions
+		trainingSize = 1
+	}
+	if g.Len() < trainingSize {
+		return
+	}
+
+	if !g.training.CompareAndSwap(false, true) {
+		return
+	}
+
+	g.trainer.Add(1)
+	go func() {
+		defer g.trainer.Done()
+
+		g.mu.RLock()
+		samples := g.sampleVectors(trainingSize)
+		g.mu.RUnlock()
+		if len(samples) < trainingSize {
+			// Vectors were deleted meanwhile, wait for more
+			g.training.Store(false)
+			return
+		}
+
+		q := newQuantizer(g.quantization, g.DistanceType, samples)
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.quantizer != nil {
+			// A saved codec was restored meanwhile
+			return
+		}
+		if err := g.setQuantizer(q); err != nil {
+			// Stay unquantized and let a later insertion try again
+			log.WithError(err).Error("Failed to move vectors to disk for quantization")
+			g.training.Store(false)
+		}
+	}()
+}
+
+/*
+waitForTraining blocks until a codec being trained in the background is in use
+*/
+func (g *HNSWGraph) waitForTraining() {
+	g.trainer.Wait()
+}
+
+/*
+sampleVectors copies up to n stored vectors chosen uniformly at random, by
+reservoir sampling over the nodes. The caller must hold the graph lock.
+*/
+func (g *HNSWGraph) sampleVectors(n int) [][]float32 {
+	samples := make([][]float32, 0, n)
+	seen := 0
+	g.forEachNode(func(_ uint32, node *hnswNode) {
+		seen++
+		if len(samples) < n {
+			samples = append(samples, g.unitVector(node.vector.Data, node.scale))
+		} else if i := rand.Intn(seen); i < n {
+			samples[i] = g.unitVector(node.vector.Data, node.scale)
+		}
+	})
+	return samples
+}
+
+/*
+setQuantizer encodes every node with a trained codec, moves the full-precision
+vectors from the arena to an on-disk store and starts traversing the graph with the
+codes. The caller must hold the graph lock exclusively.
+*/
+func (g *HNSWGraph) setQuantizer(q quantizer) error {
+	disk, err := newDiskVectorStore(g.dimensions)
+	if err != nil {
+		return err
+	}
+	g.forEachNode(func(id uint32, node *hnswNode) {
+		if err == nil {
+			err = disk.write(id, node.vector.Data)
+		}
+	})
+	if err != nil {
+		disk.close()
+		return err
+	}
+
+	g.forEachNode(func(_ uint32, node *hnswNode) {
+		node.code = q.encode(g.unitVector(node.vector.Data, node.scale))
+		node.vector.Data = nil
+	})
+	// Release the arena; chunks allocated from now on only hold nodes
+	for _, chunk := range g.store.Load().chunks {
+		chunk.data = nil
+	}
+	g.disk = disk
+	g.quantizer = q
+	g.quantized.Store(true)
+	return nil
+}
+
+/*
+Close waits for the codec to finish training and releases the on-disk store of a
+quantized graph. The graph must not be used afterwards.
+*/
+func (g *HNSWGraph) Close() error {
+	g.waitForTraining()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.disk == nil {
+		return nil
+	}
+	return g.disk.close()
+}
+
+/*
+entry returns the internal ID of the current entry point and the top layer, and
+whether the graph has an entry point at all
+*/
+func (g *HNSWGraph) entry() (uint32, int, bool) {
+	g.entryMu.RLock()
+	defer g.entryMu.RUnlock()
+
+	return g.entryID, g.MaxLayer, g.EntryPoint != ""
+}
+
+/*
+Len returns the number of vectors in the graph
+*/
+func (g *HNSWGraph) Len() int {
+	return int(g.count.Load())
+}
+
+/*
+GetVector returns the vector stored under an ID with a copy of its data. In
+quantized graphs the data is read from disk, and a vector that cannot be read is
+reported as missing; use loadVector to tell the two apart.
+*/
+func (g *HNSWGraph) GetVector(id string) (Vector, bool) {
+	vector, exists, err := g.loadVector(id)
+	return vector, exists && err == nil
+}
+
+/*
+loadVector returns the vector stored under an ID, failing if its data cannot be
+read from disk
+*/
+func (g *HNSWGraph) loadVector(id string) (Vector, bool, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	internal, exists := g.lookup(id)
+	if !exists {
+		return Vector{}, false, nil
+	}
+	data, err := g.vectorCopy(internal)
+	if err != nil {
+		return Vector{}, false, err
+	}
+	vector := g.node(internal).vector
+	vector.Data = data
+	return vector, true, nil
+}
+
+/*
+vectorData returns the full-precision components of a node as they were inserted,
+reading them from disk in quantized graphs. The caller must hold the graph lock.
+*/
+func (g *HNSWGraph) vectorData(id uint32) ([]float32, error) {
+	if g.disk != nil {
+		return g.disk.read(id)
+	}
+	return g.node(id).vector.Data, nil
+}
+
+/*
+vectorCopy returns the full-precision components of a node like vectorData, in a
+slice of their own that stays valid once the node's internal ID is reused
+*/
+func (g *HNSWGraph) vectorCopy(id uint32) ([]float32, error) {
+	if g.disk != nil {
+		return g.disk.read(id)
+	}
+	data := g.node(id).vector.Data
+	return append(make([]float32, 0, len(data)), data...), nil
+}
+
+/*
+nodeData returns the components a node is compared by while linking, together with
+the factor normalizing them: its stored vector, or in quantized graphs the vector
+decoded from its code, so building the graph never reads from disk. The caller must
+hold the graph lock.
+*/
+func (g *HNSWGraph) nodeData(id uint32) ([]float32, float32) {
+	node := g.node(id)
+	if g.quantizer == nil {
+		return node.vector.Data, node.scale
+	}
+	data := g.quantizer.decode(node.code)
+	return data, g.unitScale(data)
+}
+
+/*
+unitScale returns the factor normalizing a vector in cosine graphs, and 1 otherwise
+*/
+func (g *HNSWGraph) unitScale(data []float32) float32 {
+	if g.DistanceType != config.DistanceTypeCosine {
+		return 1
+	}
+	return unitScale(data)
+}
+
+/*
+unitVector returns a copy of stored components multiplied by their normalizing
+factor, which is what codecs are trained on and encode, since queries are
+normalized in cosine graphs
+*/
+func (g *HNSWGraph) unitVector(data []float32, scale float32) []float32 {
+	unit := make([]float32, len(data))
+	for i, value := range data {
+		unit[i] = value * scale
+	}
+	return unit
+}
+
+/*
+Level returns the top layer of the node stored under an ID
+*/
+func (g *HNSWGraph) Level(id string) (int, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	node := g.nodeByID(id)
+	if node == nil {
+		return 0, false
+	}
+	return node.level, true
+}
+
+/*
+Neighbors returns the IDs of the neighbors of a node in a layer
+*/
+func (g *HNSWGraph) Neighbors(id string, layer int) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	node := g.nodeByID(id)
+	if node == nil {
+		return nil
+	}
+
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+
+	if layer >= len(node.links) || node.links[layer] == nil {
+		return nil
+	}
+	neighbors := make([]string, len(node.links[layer]))
+	for i, neighbor := range node.links[layer] {
+		neighbors[i] = g.node(neighbor).vector.ID
+	}
+	return neighbors
 }
 
 /*
@@ -107,6 +550,11 @@ The process works as follows:
 
 The random layer assignment is a key feature of HNSW, creating a probabilistic
 skip-list-like structure where ~1/e nodes of layer l appear in layer l+1.
+
+The graph keeps its own copy of the vector's data, on disk once the graph is
+quantized; GetVector returns a copy of it. Cosine graphs keep the components as
+they are together with the factor normalizing them, so cosine distances between
+stored vectors reduce to a scaled dot product.
 */
 func (g *HNSWGraph) Insert(vector Vector) error {
 	// Validate vector
@@ -117,81 +565,552 @@ func (g *HNSWGraph) Insert(vector Vector) error {
 		return ErrInvalidParameter
 	}
 
-	g.mu.Lock()
-	defer g.mu.Unlock()
+	g.mu.RLock()
+	err := g.insert(vector)
+	g.mu.RUnlock()
 
-	// Check if vector with this ID already exists
-	if _, exists := g.Vectors[vector.ID]; exists {
-		return fmt.Errorf("vector with ID %s already exists", vector.ID)
+	if err == nil {
+		g.trainQuantizer()
 	}
+	return err
+}
 
-	// Calculate layer using mL
-	layer := 0
-	if g.mL > 0 {
-		levelRand := rand.Float64()
-		if levelRand == 0 {
-			levelRand = math.SmallestNonzeroFloat64
-		}
-		layer = int(math.Floor(-math.Log(levelRand) * g.mL))
+/*
+insert links a new vector into the graph, failing if the ID is already present.
+The caller must hold the graph lock, shared or exclusive.
+
+The node is allocated before it is linked, which reserves its ID against
+concurrent insertions; it only becomes reachable once the first link to it is
+added. Each adjacency list is locked on its own while it is extended, so
+insertions linking different parts of the graph do not wait for each other.
+*/
+func (g *HNSWGraph) insert(vector Vector) error {
+	query := g.prepare(vector.Data)
+	id, node, err := g.allocate(vector, g.randomLevel())
+	if err != nil {
+		return err
 	}
+	g.count.Add(1)
 
-	if layer > g.MaxLayer {
-		g.MaxLayer = layer
-		for i := len(g.Layers); i <= layer; i++ {
-			g.Layers = append(g.Layers, make(map[string][]string))
+	g.attach(id, node, query)
+	return nil
+}
+
+/*
+attach links an allocated node into the graph, making it the entry point if the
+graph has none yet. The caller must hold the graph lock, shared or exclusive.
+*/
+func (g *HNSWGraph) attach(id uint32, node *hnswNode, query []float32) {
+	entryPoint, maxLayer, exists := g.entry()
+	if !exists {
+		g.entryMu.Lock()
+		if g.EntryPoint == "" {
+			// If this is the first vector, set it as entry point
+			g.EntryPoint = node.vector.ID
+			g.entryID = id
+			g.MaxLayer = node.level
+			g.entryMu.Unlock()
+			return
 		}
+		entryPoint, maxLayer = g.entryID, g.MaxLayer
+		g.entryMu.Unlock()
 	}
 
-	// Store vector
-	g.Vectors[vector.ID] = vector
-
-	// If this is the first vector, set it as entry point
-	if g.EntryPoint == "" {
-		g.EntryPoint = vector.ID
-		return nil
+	g.connect(id, node, query, entryPoint, maxLayer, false)
+	if node.level > maxLayer {
+		g.entryMu.Lock()
+		if node.level > g.MaxLayer {
+			g.MaxLayer = node.level
+		}
+		g.entryMu.Unlock()
 	}
+}
 
+/*
+connect links a node into every layer from its level down to 0, searching for its
+neighbors from an entry point. A new node gets its links added to the ones
+concurrent insertions may already have given it; with relink, an existing node
+replaces its links, and the neighbors it drops are linked again from elsewhere if
+nothing else reaches them. Relinking requires the graph lock held exclusively.
+*/
+func (g *HNSWGraph) connect(id uint32, node *hnswNode, query []float32, entryPoint uint32, maxLayer int, relink bool) {
 	// First phase: Find the best entry point for the target layer
-	entryPointForLayer := g.EntryPoint
-	for l := g.MaxLayer; l > layer; l-- {
-		pathCandidates := g.searchLayer(vector.Data, entryPointForLayer, 1, l)
+	entryPointForLayer := entryPoint
+	for l := maxLayer; l > node.level; l-- {
+		pathCandidates := g.searchLayer(query, entryPointForLayer, 1, g.EfConstruction, l, SearchOptions{})
 		if len(pathCandidates) > 0 {
-			entryPointForLayer = pathCandidates[0]
+			entryPointForLayer = pathCandidates[0].id
 		}
 	}
 
-	// Second phase: Insert the vector in each layer from layer down to 0
-	for l := min(layer, g.MaxLayer); l >= 0; l-- {
-		// Find potential neighbors in layer l
-		nearestCandidates := g.searchLayer(vector.Data, entryPointForLayer, g.EfConstruction, l)
+	// Layer 0 is explored at least as widely as a search would, so graphs tuned for
+	// high-recall searches are also built with the wider candidate list
+	efLayer0 := g.EfConstruction
+	if g.EfSearch > efLayer0 {
+		efLayer0 = g.EfSearch
+	}
 
-		// Select M best neighbors from the candidates
-		neighbors := g.selectNeighbors(vector.Data, nearestCandidates, g.M)
+	// Second phase: Connect the node in each layer from its level down to 0
+	for l := node.level; l >= 0; l-- {
+		var nearestCandidates []nodeDistance
+		if l > maxLayer {
+			// Layers above the current top only hold the entry point
+			data, scale := g.nodeData(entryPointForLayer)
+			nearestCandidates = []nodeDistance{{id: entryPointForLayer, distance: g.storedDistance(query, data, scale)}}
+		} else {
+			// Find potential neighbors in layer l
+			ef := g.EfConstruction
+			if l == 0 {
+				ef = efLayer0
+			}
+			nearestCandidates = g.searchLayer(query, entryPointForLayer, g.EfConstruction, ef, l, SearchOptions{})
+		}
 
-		// Add bidirectional connections
-		g.Layers[l][vector.ID] = neighbors
-		for _, neighbor := range neighbors {
-			if _, ok := g.Layers[l][neighbor]; !ok {
-				g.Layers[l][neighbor] = []string{}
+		// A concurrent insertion may already have linked to the new node, so the
+		// search can come back to it
+		candidateIDs := make([]uint32, 0, len(nearestCandidates))
+		for _, candidate := range nearestCandidates {
+			if candidate.id != id {
+				candidateIDs = append(candidateIDs, candidate.id)
 			}
-			g.Layers[l][neighbor] = append(g.Layers[l][neighbor], vector.ID)
+		}
 
-			// Trim neighbor's connections if they exceed M
-			if len(g.Layers[l][neighbor]) > g.M {
-				neighborVectorData := g.Vectors[neighbor].Data
-				g.Layers[l][neighbor] = g.selectNeighbors(neighborVectorData, g.Layers[l][neighbor], g.M)
+		// Select M best neighbors from the candidates and add bidirectional connections
+		neighbors := g.selectNeighbors(query, 1, candidateIDs, g.M)
+		if relink {
+			var previous []uint32
+			if l < len(node.links) {
+				previous = append(previous, node.links[l]...)
+			}
+			g.setLinks(id, node, l, append([]uint32(nil), neighbors...))
+			for _, neighbor := range neighbors {
+				g.link(neighbor, l, id)
+			}
+			for _, neighbor := range previous {
+				if !containsNode(neighbors, neighbor) {
+					g.adopt(neighbor, l, append(previous, neighbors...))
+				}
+			}
+		} else {
+			g.link(id, l, neighbors...)
+			for _, neighbor := range neighbors {
+				g.link(neighbor, l, id)
 			}
 		}
 
 		// Update entry point for next layer
-		if len(nearestCandidates) > 0 {
-			entryPointForLayer = nearestCandidates[0]
+		if len(candidateIDs) > 0 {
+			entryPointForLayer = candidateIDs[0]
+		}
+	}
+}
+
+/*
+link adds internal IDs to the adjacency list of a node in a layer, trimming the
+list back to M connections if it grows beyond. Only the lock of that node is held,
+so linking never waits on a lock while holding another.
+*/
+func (g *HNSWGraph) link(id uint32, layer int, ids ...uint32) {
+	node := g.node(id)
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	var current []uint32
+	if layer < len(node.links) {
+		current = node.links[layer]
+	}
+	neighbors := make([]uint32, len(current), len(current)+len(ids))
+	copy(neighbors, current)
+	for _, neighbor := range ids {
+		if neighbor != id && !containsNode(neighbors, neighbor) {
+			neighbors = append(neighbors, neighbor)
+		}
+	}
+
+	// Trim the connections if they exceed M
+	if len(neighbors) > g.M {
+		data, scale := g.nodeData(id)
+		neighbors = g.selectNeighbors(data, scale, neighbors, g.M)
+	}
+	g.setLinks(id, node, layer, neighbors)
+}
+
+/*
+setLinks replaces the adjacency list of a node in a layer and updates the inbound
+lists of the neighbors it gains and loses. The caller must hold the node's lock or
+the graph lock exclusively, and neighbors must not share memory with the current list.
+*/
+func (g *HNSWGraph) setLinks(id uint32, node *hnswNode, layer int, neighbors []uint32) {
+	for len(node.links) <= layer {
+		node.links = append(node.links, nil)
+	}
+	current := node.links[layer]
+	for _, neighbor := range current {
+		if !containsNode(neighbors, neighbor) {
+			g.node(neighbor).removeInbound(layer, id)
+		}
+	}
+	for _, neighbor := range neighbors {
+		if !containsNode(current, neighbor) {
+			g.node(neighbor).addInbound(layer, id)
+		}
+	}
+
+	if current == nil {
+		if len(neighbors) == 0 {
+			return
+		}
+		current = make([]uint32, 0, max(g.M, len(neighbors)))
+	}
+	node.links[layer] = append(current[:0], neighbors...)
+}
+
+/*
+randomLevel draws the top layer of a new node using the mL normalization factor
+*/
+func (g *HNSWGraph) randomLevel() int {
+	if g.mL <= 0 {
+		return 0
+	}
+	levelRand := rand.Float64()
+	if levelRand == 0 {
+		levelRand = math.SmallestNonzeroFloat64
+	}
+	return int(math.Floor(-math.Log(levelRand) * g.mL))
+}
+
+/*
+InsertBatch adds many new vectors to the graph using up to workers goroutines.
+
+Insertions only share the graph lock, so the workers link their vectors into the
+graph in parallel. The returned slice holds one error per vector, nil for the
+ones that were inserted.
+*/
+func (g *HNSWGraph) InsertBatch(vectors []Vector, workers int) []error {
+	errs := make([]error, len(vectors))
+
+	// Reject duplicates within the batch up front, so the first occurrence wins
+	// regardless of the order in which the workers get to them
+	seen := make(map[string]struct{}, len(vectors))
+	for i, vector := range vectors {
+		switch {
+		case len(vector.Data) == 0:
+			errs[i] = ErrEmptyVector
+		case vector.ID == "":
+			errs[i] = ErrInvalidParameter
+		default:
+			if _, duplicate := seen[vector.ID]; duplicate {
+				errs[i] = fmt.Errorf("vector with ID %s: %w", vector.ID, ErrVectorExists)
+			}
+			seen[vector.ID] = struct{}{}
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	g.mu.RLock()
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = g.insert(vectors[i])
+			}
+		}()
+	}
+
+	for i := range vectors {
+		if errs[i] == nil {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	g.mu.RUnlock()
+
+	g.trainQuantizer()
+	return errs
+}
+
+/*
+Update replaces the vector stored under an existing ID, inserting it if the ID is new.
+
+When only the metadata changes the stored vector is swapped in place and the graph
+is left untouched. When the embedding itself changes the node keeps its place in
+the graph and picks new neighbors around its new position, and the nodes linking
+to it keep their links, so moving a vector does not cut paths through the graph.
+Inserting a new ID runs concurrently with searches like Insert; replacing an
+existing vector takes the graph lock exclusively.
+*/
+func (g *HNSWGraph) Update(vector Vector) error {
+	// Validate vector
+	if len(vector.Data) == 0 {
+		return ErrEmptyVector
+	}
+	if vector.ID == "" {
+		return ErrInvalidParameter
+	}
+
+	err := g.update(vector)
+	if err == nil {
+		g.trainQuantizer()
+	}
+	return err
+}
+
+/*
+update inserts or replaces a vector, taking the graph lock as needed
+*/
+func (g *HNSWGraph) update(vector Vector) error {
+	g.mu.RLock()
+	if _, exists := g.lookup(vector.ID); !exists {
+		err := g.insert(vector)
+		g.mu.RUnlock()
+		if !errors.Is(err, ErrVectorExists) {
+			return err
 		}
+		// Inserted concurrently, replace it below
+	} else {
+		g.mu.RUnlock()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	id, exists := g.lookup(vector.ID)
+	if !exists {
+		return g.insert(vector)
+	}
+
+	existing := g.node(id)
+	data, err := g.vectorData(id)
+	if err != nil {
+		return err
+	}
+	if vectorDataEqual(data, vector.Data) {
+		vector.Data = existing.vector.Data
+		existing.vector = vector
+		return nil
+	}
+	if len(vector.Data) != g.dimensions {
+		return fmt.Errorf("%w: %d, graph has %d", ErrDifferentDims, len(vector.Data), g.dimensions)
+	}
+
+	// The node keeps its ID and level and is linked again from its new position;
+	// nodes linking to its old position keep those links as long-range shortcuts
+	if err := g.setData(id, existing, vector); err != nil {
+		return err
+	}
+	g.connect(id, existing, g.prepare(vector.Data), g.entryID, g.MaxLayer, true)
+	return nil
+}
+
+/*
+Delete removes a vector from the graph.
+
+The process works as follows:
+1. Remove the node's adjacency list from every layer it belongs to
+2. Repair each node linking to it by re-selecting from its remaining neighbors plus the deleted node's neighbors
+3. Link every node left without inbound links from the nearest node of the repaired neighborhood
+4. If the deleted node was the entry point, promote a node from the highest remaining layer
+
+Steps 2 and 3 keep the region around the removed node navigable instead of leaving
+nodes that search can no longer reach. The nodes linking to the deleted one are
+tracked as links are added, so only its neighborhood is visited.
+*/
+func (g *HNSWGraph) Delete(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	internal, exists := g.lookup(id)
+	if !exists {
+		return ErrVectorNotFound
 	}
 
+	g.delete(internal)
 	return nil
 }
 
+/*
+delete unlinks an existing vector from the graph. The caller must hold the graph
+lock exclusively, so links are rewritten without taking the node locks.
+*/
+func (g *HNSWGraph) delete(id uint32) {
+	deleted := g.node(id)
+	for l := 0; l < max(len(deleted.links), len(deleted.inbound)); l++ {
+		g.unlinkLayer(id, deleted, l)
+	}
+
+	// Nothing links to the node any more, so its ID can be handed out again
+	g.idMu.Lock()
+	delete(g.ids, deleted.vector.ID)
+	g.free = append(g.free, id)
+	g.idMu.Unlock()
+
+	deleted.deleted = true
+	deleted.vector = Vector{}
+	deleted.code = nil
+	deleted.links = nil
+	deleted.inbound = nil
+	g.count.Add(-1)
+
+	if g.EntryPoint != "" && g.entryID == id {
+		g.resetEntryPoint()
+	}
+}
+
+/*
+unlinkLayer removes a node from a layer, reconnecting the nodes that linked to it
+with the nodes it linked to. The caller must hold the graph lock exclusively.
+*/
+func (g *HNSWGraph) unlinkLayer(id uint32, node *hnswNode, layer int) {
+	var outbound, inbound []uint32
+	if layer < len(node.links) {
+		outbound = append(outbound, node.links[layer]...)
+	}
+	if layer < len(node.inbound) {
+		inbound = append(inbound, node.inbound[layer]...)
+	}
+	g.setLinks(id, node, layer, nil)
+
+	neighborhood := make([]uint32, 0, len(inbound)+len(outbound))
+	for _, neighbor := range append(inbound, outbound...) {
+		if !containsNode(neighborhood, neighbor) {
+			neighborhood = append(neighborhood, neighbor)
+		}
+	}
+
+	// Each node linking to the removed one chooses among its remaining neighbors and
+	// the removed node's, so paths through the removed node are kept
+	orphans := append([]uint32(nil), outbound...)
+	for _, nodeID := range inbound {
+		neighbor := g.node(nodeID)
+		current := neighbor.links[layer]
+		candidates := make([]uint32, 0, len(current)-1+len(outbound))
+		for _, candidate := range current {
+			if candidate != id {
+				candidates = append(candidates, candidate)
+			}
+		}
+		for _, candidate := range outbound {
+			if candidate != nodeID && !containsNode(candidates, candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+
+		data, scale := g.nodeData(nodeID)
+		selected := g.selectNeighbors(data, scale, candidates, g.M)
+		for _, candidate := range current {
+			if candidate != id && !containsNode(selected, candidate) && !containsNode(orphans, candidate) {
+				orphans = append(orphans, candidate)
+			}
+		}
+		g.setLinks(nodeID, neighbor, layer, selected)
+	}
+
+	for _, orphan := range orphans {
+		g.adopt(orphan, layer, neighborhood)
+	}
+}
+
+/*
+adopt links a node left without inbound links in a layer from the nearest of the
+candidates or of its own neighbors, so searches can still reach it. A node whose
+adjacency list is full gives up its farthest neighbor among those other nodes still
+link to, so adopting never leaves another node unreachable. The caller must hold
+the graph lock exclusively.
+*/
+func (g *HNSWGraph) adopt(id uint32, layer int, candidates []uint32) {
+	node := g.node(id)
+	if node.inDegree(layer) > 0 {
+		return
+	}
+	if layer < len(node.links) {
+		candidates = append(append([]uint32(nil), candidates...), node.links[layer]...)
+	}
+
+	data, scale := g.nodeData(id)
+	parents := make([]nodeDistance, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate == id || containsParent(parents, candidate) {
+			continue
+		}
+		candidateData, candidateScale := g.nodeData(candidate)
+		parents = append(parents, nodeDistance{id: candidate, distance: g.storedDistance(data, candidateData, scale*candidateScale)})
+	}
+	sort.Slice(parents, func(i, j int) bool {
+		return nearer(parents[i], parents[j])
+	})
+
+	for _, parent := range parents {
+		parentNode := g.node(parent.id)
+		var current []uint32
+		if layer < len(parentNode.links) {
+			current = parentNode.links[layer]
+		}
+		neighbors := make([]uint32, len(current), len(current)+1)
+		copy(neighbors, current)
+		if len(neighbors) < g.M {
+			g.setLinks(parent.id, parentNode, layer, append(neighbors, id))
+			return
+		}
+
+		parentData, parentScale := g.nodeData(parent.id)
+		replaced := -1
+		var farthest float32
+		for i, neighbor := range neighbors {
+			if g.node(neighbor).inDegree(layer) < 2 {
+				continue
+			}
+			neighborData, neighborScale := g.nodeData(neighbor)
+			distance := g.storedDistance(parentData, neighborData, parentScale*neighborScale)
+			if replaced < 0 || distance > farthest {
+				replaced, farthest = i, distance
+			}
+		}
+		if replaced >= 0 {
+			neighbors[replaced] = id
+			g.setLinks(parent.id, parentNode, layer, neighbors)
+			return
+		}
+	}
+}
+
+/*
+resetEntryPoint picks a node from the highest remaining layer as the new entry point
+and drops the links above it. The caller must hold the graph lock exclusively.
+*/
+func (g *HNSWGraph) resetEntryPoint() {
+	g.EntryPoint = ""
+	g.MaxLayer = 0
+
+	bestLevel := -1
+	g.forEachNode(func(id uint32, node *hnswNode) {
+		// Prefer the lexicographically smallest ID on ties so the choice is deterministic
+		if node.level > bestLevel || (node.level == bestLevel && node.vector.ID < g.EntryPoint) {
+			bestLevel = node.level
+			g.EntryPoint = node.vector.ID
+			g.entryID = id
+		}
+	})
+
+	if bestLevel > 0 {
+		g.MaxLayer = bestLevel
+	}
+	g.forEachNode(func(id uint32, node *hnswNode) {
+		for l := g.MaxLayer + 1; l < len(node.links); l++ {
+			g.setLinks(id, node, l, nil)
+		}
+		if len(node.links) > g.MaxLayer+1 {
+			node.links = node.links[:g.MaxLayer+1]
+		}
+	})
+}
+
 /*
 Search finds the k nearest neighbors to a query vector.
 
@@ -203,6 +1122,30 @@ This approach allows the algorithm to quickly navigate to the approximate region
 of interest in higher layers, then perform a more detailed search in the lowest layer.
 */
 func (g *HNSWGraph) Search(query []float32, k int) ([]Vector, error) {
+	results, err := g.SearchWithOptions(query, k, SearchOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to vectors
+	vectors := make([]Vector, 0, len(results))
+	for _, result := range results {
+		vectors = append(vectors, Vector{ID: result.ID, Data: result.Data, Metadata: result.Metadata})
+	}
+
+	return vectors, nil
+}
+
+/*
+SearchWithOptions finds the k nearest neighbors to a query vector and returns them
+ranked by distance, together with a similarity score derived from the distance metric.
+
+The options control which parts of the stored vectors are copied into the results,
+so callers that only need IDs and distances can keep responses small. When a filter
+is set, only matching vectors are returned, but non-matching ones are still used to
+navigate the graph so sparse matches are reached instead of cutting the search short.
+*/
+func (g *HNSWGraph) SearchWithOptions(query []float32, k int, opts SearchOptions) ([]SearchResult, error) {
 	// Validate parameters
 	if len(query) == 0 {
 		return nil, ErrEmptyVector
@@ -210,42 +1153,248 @@ func (g *HNSWGraph) Search(query []float32, k int) ([]Vector, error) {
 	if k <= 0 {
 		return nil, ErrInvalidParameter
 	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	query = g.prepare(query)
 
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 
-	if g.EntryPoint == "" {
-		return []Vector{}, nil
+	entryPoint, maxLayer, exists := g.entry()
+	if !exists {
+		return []SearchResult{}, nil
 	}
 
 	// Phase 1: Descend from top layer to layer 1 (only finding path)
-	currentEntryPoint := g.EntryPoint
-	for l := g.MaxLayer; l > 0; l-- {
+	currentEntryPoint := g.descend(query, entryPoint, maxLayer)
+
+	// Phase 2: Detailed search in layer 0, applying the metadata filter and the
+	// per-query tuning options while traversing. The candidate list is never
+	// smaller than k, or fewer than k results could be found.
+	ef := g.EfSearch
+	if opts.Ef > 0 {
+		ef = opts.Ef
+	}
+	if ef < k {
+		ef = k
+	}
+	finalCandidates := g.searchLayer(query, currentEntryPoint, ef, ef, 0, opts)
+	score := g.Score
+	if g.quantization.NoRescore {
+		score = g.codeScore()
+	} else if err := g.rerank(query, finalCandidates); err != nil {
+		return nil, err
+	}
+
+	// Trim to k results
+	if len(finalCandidates) > k {
+		finalCandidates = finalCandidates[:k]
+	}
+
+	return g.searchResults(finalCandidates, score, opts)
+}
+
+/*
+descend walks from the entry point down to layer 1, moving to the node closest
+to the query in each layer, and returns the node to start the layer 0 search from
+*/
+func (g *HNSWGraph) descend(query []float32, entryPoint uint32, maxLayer int) uint32 {
+	currentEntryPoint := entryPoint
+	for l := maxLayer; l > 0; l-- {
 		// Use small number of candidates (1) to find best entry point for next layer
-		pathCandidates := g.searchLayer(query, currentEntryPoint, 1, l)
+		pathCandidates := g.searchLayer(query, currentEntryPoint, 1, g.EfConstruction, l, SearchOptions{})
 		if len(pathCandidates) > 0 {
-			currentEntryPoint = pathCandidates[0]
+			currentEntryPoint = pathCandidates[0].id
 		} else {
 			// If no candidates found, break the descent
 			break
 		}
 	}
+	return currentEntryPoint
+}
 
-	// Phase 2: Detailed search in layer 0
-	finalCandidates := g.searchLayer(query, currentEntryPoint, g.EfSearch, 0)
+/*
+RangeSearch finds all vectors within radius of a query vector, nearest first.
+A positive limit caps the number of results to the nearest limit vectors.
+*/
+func (g *HNSWGraph) RangeSearch(query []float32, radius float32, limit int) ([]SearchResult, error) {
+	return g.RangeSearchWithOptions(query, radius, limit, SearchOptions{})
+}
 
-	// Trim to k results
-	if len(finalCandidates) > k {
-		finalCandidates = finalCandidates[:k]
+/*
+RangeSearchWithOptions finds the vectors within radius of a query vector using the
+given options, returning them ranked by distance.
+
+The search descends to layer 0 like a k-nearest search and searches it with the
+options' ef. The search is then repeated with twice the candidate list for as long
+as that keeps finding more vectors within the radius, so the frontier of the search
+moves beyond the radius, until the limit is met or the whole graph has been
+explored. Like any HNSW search the result is approximate.
+*/
+func (g *HNSWGraph) RangeSearchWithOptions(query []float32, radius float32, limit int, opts SearchOptions) ([]SearchResult, error) {
+	// Validate parameters
+	if len(query) == 0 {
+		return nil, ErrEmptyVector
 	}
+	if !(radius >= 0) || limit < 0 {
+		return nil, ErrInvalidParameter
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	query = g.prepare(query)
 
-	// Convert to vectors
-	vectors := make([]Vector, 0, len(finalCandidates))
-	for _, id := range finalCandidates {
-		vectors = append(vectors, g.Vectors[id])
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	entryPoint, maxLayer, exists := g.entry()
+	if !exists {
+		return []SearchResult{}, nil
 	}
 
-	return vectors, nil
+	currentEntryPoint := g.descend(query, entryPoint, maxLayer)
+
+	ef := g.EfSearch
+	if opts.Ef > 0 {
+		ef = opts.Ef
+	}
+	if ef < limit {
+		ef = limit
+	}
+
+	found := -1
+	for {
+		candidates := g.searchLayer(query, currentEntryPoint, ef, ef, 0, opts)
+		if err := g.rerank(query, candidates); err != nil {
+			return nil, err
+		}
+
+		// Keep the candidates within the radius
+		inRange := sort.Search(len(candidates), func(i int) bool { return candidates[i].distance > radius })
+		if limit > 0 && inRange > limit {
+			inRange = limit
+		}
+
+		// A short list means the search ran out of nodes to visit
+		if inRange == found || inRange == limit || len(candidates) < ef || ef >= g.Len() {
+			return g.searchResults(candidates[:inRange], g.Score, opts)
+		}
+		found = inRange
+		ef *= 2
+	}
+}
+
+/*
+SearchCandidates performs an exact search restricted to the given IDs.
+
+Every candidate is compared with the query and the options' filter is re-applied,
+so the ID set may be a superset of the matches. This is used instead of a graph
+traversal when a metadata filter narrows the search to a small set of vectors.
+*/
+func (g *HNSWGraph) SearchCandidates(query []float32, k int, ids map[string]struct{}, opts SearchOptions) ([]SearchResult, error) {
+	// Validate parameters
+	if len(query) == 0 {
+		return nil, ErrEmptyVector
+	}
+	if k <= 0 {
+		return nil, ErrInvalidParameter
+	}
+	query = g.prepare(query)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	resultSet := &nodeQueue{farthestFirst: true}
+	for id := range ids {
+		internal, exists := g.lookup(id)
+		if !exists {
+			continue
+		}
+		node := g.node(internal)
+		if !opts.Filter.Match(node.vector.Metadata) {
+			continue
+		}
+
+		data, err := g.vectorData(internal)
+		if err != nil {
+			return nil, err
+		}
+		distance := g.storedDistance(query, data, node.scale)
+		if resultSet.len() < k {
+			resultSet.push(nodeDistance{id: internal, distance: distance})
+		} else if distance < resultSet.top().distance {
+			resultSet.pop()
+			resultSet.push(nodeDistance{id: internal, distance: distance})
+		}
+	}
+
+	return g.searchResults(resultSet.drain(), g.Score, opts)
+}
+
+/*
+rerank replaces the approximate distances of candidates found on a quantized graph
+with exact ones read from disk and sorts the candidates again, nearest first
+*/
+func (g *HNSWGraph) rerank(query []float32, items []nodeDistance) error {
+	if g.quantizer == nil {
+		return nil
+	}
+
+	for i := range items {
+		data, err := g.vectorData(items[i].id)
+		if err != nil {
+			return err
+		}
+		items[i].distance = g.storedDistance(query, data, g.node(items[i].id).scale)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return nearer(items[i], items[j])
+	})
+	return nil
+}
+
+/*
+codeScore returns the function turning distances measured on the nodes' codes into
+scores. Binary codes are compared by Hamming distance, which counts differing signs
+rather than measuring the metric, so it is mapped onto the range of a cosine
+similarity instead; the other codecs approximate the metric itself.
+*/
+func (g *HNSWGraph) codeScore() func(distance float32) float32 {
+	if q, ok := g.quantizer.(*binaryQuantizer); ok {
+		return q.score
+	}
+	return g.Score
+}
+
+/*
+searchResults converts ranked nodes into search results honoring the include
+options, scoring their distances with score
+*/
+func (g *HNSWGraph) searchResults(items []nodeDistance, score func(float32) float32, opts SearchOptions) ([]SearchResult, error) {
+	results := make([]SearchResult, 0, len(items))
+	for _, item := range items {
+		vector := g.node(item.id).vector
+		result := SearchResult{
+			ID:       vector.ID,
+			Distance: item.distance,
+			Score:    score(item.distance),
+		}
+
+		if !opts.OmitVectors {
+			data, err := g.vectorCopy(item.id)
+			if err != nil {
+				return nil, err
+			}
+			result.Data = data
+		}
+		if !opts.OmitMetadata {
+			result.Metadata = vector.Metadata
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
 }
 
 // DistanceItem represents an item with its distance to the query vector
@@ -286,214 +1435,252 @@ func (h *MaxHeap) Pop() interface{} {
 
 /*
 searchLayer searches for the nearest neighbors in a specific layer using heap data structures
-for better performance with large k or EfConstruction values
+for better performance with large k or EfConstruction values.
+
+The search keeps a dynamic list of ef candidates (efConstruction while inserting,
+efSearch while querying) and returns the k best of them.
+
+If opts has a filter, every reachable node is still traversed, but only nodes whose
+metadata matches are admitted to the result set. Because the early-stop check only
+fires once the result set is full, a selective filter widens the exploration until
+enough matches are found. The options' quality threshold loosens or tightens that
+check, and their visit budget stops the search early with the best results so far.
+
+The returned items are sorted by ascending distance to the query.
 */
-func (g *HNSWGraph) searchLayer(query []float32, entryPoint string, k int, layer int) []string {
+func (g *HNSWGraph) searchLayer(query []float32, entryPoint uint32, k, ef int, layer int, opts SearchOptions) []nodeDistance {
 	// Early return for invalid k
 	if k <= 0 {
-		return []string{}
+		return []nodeDistance{}
+	}
+
+	// The dynamic candidate list is never smaller than the number of results
+	if ef < k {
+		ef = k
 	}
 
 	// Initialize visited set and result/candidate heaps
-	visited := make(map[string]bool)
-	resultSet := &MaxHeap{} // Max heap for results (worst at top for easy removal)
-	heap.Init(resultSet)
+	distance := g.distanceTo(query)
+	visited := g.acquireVisited()
+	defer g.releaseVisited(visited)
+	resultSet := &nodeQueue{items: make([]nodeDistance, 0, ef+1), farthestFirst: true} // Worst at top for easy removal
 
 	// Initialize with entry point
-	entryPointDist := g.Distance(query, g.Vectors[entryPoint].Data)
-	heap.Push(resultSet, DistanceItem{ID: entryPoint, Distance: entryPointDist})
-	visited[entryPoint] = true
+	entryNode := g.node(entryPoint)
+	entryPointDist := distance(entryNode)
+	if opts.Filter.Match(entryNode.vector.Metadata) {
+		resultSet.push(nodeDistance{id: entryPoint, distance: entryPointDist})
+	}
+	visited.visit(entryPoint)
 
 	// Min heap for candidates to visit next (best at top)
-	candidateSet := &MinHeap{DistanceItem{ID: entryPoint, Distance: entryPointDist}}
-	heap.Init(candidateSet)
-
-	// We'll use a dynamic list size based on ef parameter:
-	// For construction (efConstruction) or search (efSearch)
-	// This improves exploration during search
-	ef := k
-	if layer == 0 && g.EfSearch > k {
-		// Use larger ef for bottom layer search
-		ef = g.EfSearch
-	} else if layer > 0 && g.EfConstruction > k {
-		// Use efConstruction for upper layers during insertion
-		ef = g.EfConstruction
-	}
+	candidateSet := &nodeQueue{items: make([]nodeDistance, 0, ef+1)}
+	candidateSet.push(nodeDistance{id: entryPoint, distance: entryPointDist})
 
 	// Use a higher quality threshold for early stopping to ensure better exploration
-	qualityThreshold := float32(1.1) // Allow 10% worse candidates to be explored before stopping
+	qualityThreshold := opts.QualityThreshold
+	if qualityThreshold == 0 {
+		qualityThreshold = defaultQualityThreshold
+	}
 
 	// Continue until we've explored all viable candidates
-	for candidateSet.Len() > 0 {
+	neighbors := make([]uint32, 0, g.M)
+search:
+	for candidateSet.len() > 0 {
 		// Get closest candidate
-		current := heap.Pop(candidateSet).(DistanceItem)
+		current := candidateSet.pop()
 
 		// If the results heap is full and the current candidate is significantly worse than the worst result,
 		// we can stop (apply quality threshold to avoid early stopping)
-		if resultSet.Len() >= ef && current.Distance > (*resultSet)[0].Distance*qualityThreshold {
+		if resultSet.len() >= ef && current.distance > resultSet.top().distance*qualityThreshold {
 			break
 		}
 
 		// Explore neighbors of the current candidate
-		for _, neighborID := range g.Layers[layer][current.ID] {
-			if !visited[neighborID] {
-				visited[neighborID] = true
+		neighbors = g.node(current.id).appendNeighbors(neighbors[:0], layer)
+		for _, neighborID := range neighbors {
+			if visited.contains(neighborID) {
+				continue
+			}
+			if opts.MaxVisited > 0 && visited.count >= opts.MaxVisited {
+				break search
+			}
+			visited.visit(neighborID)
 
-				neighborDist := g.Distance(query, g.Vectors[neighborID].Data)
+			neighbor := g.node(neighborID)
+			neighborDist := distance(neighbor)
 
-				// If the results heap is not full or the neighbor is better than the worst result,
-				// add it to the result set
-				if resultSet.Len() < ef || neighborDist < (*resultSet)[0].Distance {
-					// Add to result set
-					heap.Push(resultSet, DistanceItem{ID: neighborID, Distance: neighborDist})
+			// If the results heap is not full or the neighbor is better than the worst result,
+			// add it to the result set
+			if resultSet.len() < ef || neighborDist < resultSet.top().distance {
+				// Add to result set, unless the filter excludes it
+				if opts.Filter.Match(neighbor.vector.Metadata) {
+					resultSet.push(nodeDistance{id: neighborID, distance: neighborDist})
 
 					// If result set is too large, remove the worst element
-					if resultSet.Len() > ef {
-						heap.Pop(resultSet)
+					if resultSet.len() > ef {
+						resultSet.pop()
 					}
-
-					// Always add to candidate set for further exploration, regardless of distance
-					// This improves the chance of finding better paths through the graph
-					heap.Push(candidateSet, DistanceItem{ID: neighborID, Distance: neighborDist})
 				}
+
+				// Always add to candidate set for further exploration, regardless of distance
+				// This improves the chance of finding better paths through the graph
+				candidateSet.push(nodeDistance{id: neighborID, distance: neighborDist})
 			}
 		}
 	}
 
-	// Convert heap to sorted list of IDs (limit to k results)
-	resultIDs := make([]string, 0, k)
-	resultItems := make([]DistanceItem, 0, resultSet.Len())
-
-	// Extract all items from the heap
-	for resultSet.Len() > 0 {
-		resultItems = append(resultItems, heap.Pop(resultSet).(DistanceItem))
+	// Take top k
+	resultItems := resultSet.drain()
+	if len(resultItems) > k {
+		resultItems = resultItems[:k]
 	}
 
-	// Sort by distance (ascending)
-	sort.Slice(resultItems, func(i, j int) bool {
-		return resultItems[i].Distance < resultItems[j].Distance
-	})
+	return resultItems
+}
 
-	// Take top k
-	for i := 0; i < k && i < len(resultItems); i++ {
-		resultIDs = append(resultIDs, resultItems[i].ID)
+/*
+distanceTo returns a function measuring the distance from the query to a node, on
+the nodes' codes when the graph is quantized. The caller must hold the graph lock.
+*/
+func (g *HNSWGraph) distanceTo(query []float32) func(node *hnswNode) float32 {
+	if g.quantizer != nil {
+		distance := g.quantizer.queryDistance(query)
+		return func(node *hnswNode) float32 {
+			return distance(node.code)
+		}
+	}
+	return func(node *hnswNode) float32 {
+		return g.storedDistance(query, node.vector.Data, node.scale)
 	}
-
-	return resultIDs
 }
 
 /*
 selectNeighbors selects the M nearest neighbors from a set of candidates
-using the heuristic selection algorithm from the original HNSW paper
+using the heuristic selection algorithm from the original HNSW paper. The query is
+compared as stored components with the factor normalizing them.
 */
-func (g *HNSWGraph) selectNeighbors(query []float32, candidates []string, m int) []string {
+func (g *HNSWGraph) selectNeighbors(query []float32, scale float32, candidates []uint32, m int) []uint32 {
 	if len(candidates) <= m {
 		return candidates
 	}
 
-	// First, sort candidates by distance
-	type candidateItem struct {
-		ID       string
-		Distance float32
+	// First, sort candidates by distance, fetching the components of each once
+	type candidate struct {
+		nodeDistance
+		data  []float32
+		scale float32
 	}
-
-	items := make([]candidateItem, 0, len(candidates))
+	items := make([]candidate, 0, len(candidates))
 	for _, id := range candidates {
-		items = append(items, candidateItem{
-			ID:       id,
-			Distance: g.Distance(query, g.Vectors[id].Data),
+		data, candidateScale := g.nodeData(id)
+		items = append(items, candidate{
+			nodeDistance: nodeDistance{id: id, distance: g.storedDistance(query, data, scale*candidateScale)},
+			data:         data,
+			scale:        candidateScale,
 		})
 	}
 
 	// Sort by distance
 	sort.Slice(items, func(i, j int) bool {
-		return items[i].Distance < items[j].Distance
+		return items[i].distance < items[j].distance
 	})
 
-	// Select neighbors using heuristic selection
-	// This improves the diversity of connections and prevents "dead ends"
-	result := make([]string, 0, m)
-
-	// Always include the closest neighbor
-	if len(items) > 0 {
-		result = append(result, items[0].ID)
-		items = items[1:] // Remove the closest neighbor from candidates
-	}
-
-	// For the remaining slots, use a heuristic selection
-	for len(result) < m && len(items) > 0 {
-		// Find the candidate with the maximum distance to any point in result
-		maxDist := float32(-1.0)
-		maxIdx := 0
-
-		for i, item := range items {
-			// Find minimum distance to any point in result
-			minDist := float32(math.MaxFloat32)
-			for _, resultID := range result {
-				dist := g.Distance(g.Vectors[item.ID].Data, g.Vectors[resultID].Data)
-				if dist < minDist {
-					minDist = dist
-				}
-			}
-
-			// Update max if this is better
-			if minDist > maxDist {
-				maxDist = minDist
-				maxIdx = i
+	// Select neighbors using heuristic selection: a candidate is kept only if it is
+	// closer to the query than to every neighbor kept so far, which spreads the links
+	// over different directions and prevents "dead ends"
+	result := make([]uint32, 0, m)
+	selected := make([]candidate, 0, m)
+	var pruned []uint32
+	for _, item := range items {
+		if len(result) == m {
+			break
+		}
+		diverse := true
+		for _, neighbor := range selected {
+			if g.storedDistance(item.data, neighbor.data, item.scale*neighbor.scale) < item.distance {
+				diverse = false
+				break
 			}
 		}
+		if diverse {
+			result = append(result, item.id)
+			selected = append(selected, item)
+		} else {
+			pruned = append(pruned, item.id)
+		}
+	}
 
-		// Add the selected candidate to result
-		result = append(result, items[maxIdx].ID)
-
-		// Remove the selected candidate from items
-		items = append(items[:maxIdx], items[maxIdx+1:]...)
+	// Fill the remaining slots with the nearest pruned candidates, so nodes keep M
+	// connections as long as there are enough candidates
+	for _, id := range pruned {
+		if len(result) == m {
+			break
+		}
+		result = append(result, id)
 	}
 
 	return result
 }
 
 /*
-Distance calculates the distance between two vectors based on the configured distance type
+Distance calculates the distance between two vectors based on the configured distance type.
+The vectors do not need to be normalized, whatever the distance type.
 */
 func (g *HNSWGraph) Distance(a, b []float32) float32 {
-	switch g.DistanceType {
-	case config.DistanceTypeEuclidean:
-		return g.euclideanDistance(a, b)
-	case config.DistanceTypeCosine:
-		return g.cosineDistance(a, b)
-	case config.DistanceTypeManhattan:
-		return g.manhattanDistance(a, b)
-	case config.DistanceTypeHamming:
-		return g.hammingDistance(a, b)
-	default:
-		return g.euclideanDistance(a, b)
+	if g.DistanceType == config.DistanceTypeCosine {
+		return cosineDistance(a, b)
 	}
+	return g.distance(a, b)
 }
 
 /*
-Helper functions for different distance metrics
+storedDistance measures the distance from a query, or from stored components, to
+stored components, where scale is the product of the factors normalizing both.
+Cosine graphs keep the components as they were inserted and scale their dot product
+instead of normalizing them; the other metrics ignore scale.
 */
-func (g *HNSWGraph) euclideanDistance(a, b []float32) float32 {
-	var sum float32
-	for i := range a {
-		diff := a[i] - b[i]
-		sum += diff * diff
+func (g *HNSWGraph) storedDistance(a, b []float32, scale float32) float32 {
+	if g.DistanceType == config.DistanceTypeCosine {
+		return similarityDistance(innerProduct(a, b) * scale)
 	}
-	return float32(math.Sqrt(float64(sum)))
+	return g.distance(a, b)
 }
 
 /*
-cosineDistance calculates the cosine distance between two vectors
+prepare returns a query in the form distances are measured on: normalized to unit
+length in cosine graphs, which leaves the caller's slice untouched, and as it is
+otherwise
 */
-func (g *HNSWGraph) cosineDistance(a, b []float32) float32 {
-	var dot, normA, normB float32
-	for i := range a {
-		dot += a[i] * b[i]
-		normA += a[i] * a[i]
-		normB += b[i] * b[i]
+func (g *HNSWGraph) prepare(query []float32) []float32 {
+	if g.DistanceType != config.DistanceTypeCosine {
+		return query
 	}
+	prepared := append([]float32(nil), query...)
+	normalize(prepared)
+	return prepared
+}
+
+/*
+Score converts a distance into a similarity score where higher means more similar.
 
+For cosine the score is the cosine similarity itself (range [-1, 1]); for the other
+metrics it is 1/(1+distance), which maps identical vectors to 1 and decays towards 0.
+*/
+func (g *HNSWGraph) Score(distance float32) float32 {
+	switch g.DistanceType {
+	case config.DistanceTypeCosine:
+		return 1.0 - distance
+	default:
+		return 1.0 / (1.0 + distance)
+	}
+}
+
+/*
+cosineDistanceFromParts turns the dot product and squared norms of two vectors into
+their cosine distance
+*/
+func cosineDistanceFromParts(dot, normA, normB float32) float32 {
 	// Check for zero vectors
 	if normA == 0 || normB == 0 {
 		return 1.0 // Maximum distance for zero vectors
@@ -520,27 +1707,42 @@ func (g *HNSWGraph) cosineDistance(a, b []float32) float32 {
 }
 
 /*
-manhattanDistance calculates the Manhattan distance between two vectors
+vectorDataEqual reports whether two vectors hold exactly the same components
 */
-func (g *HNSWGraph) manhattanDistance(a, b []float32) float32 {
-	var sum float32
+func vectorDataEqual(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
 	for i := range a {
-		sum += float32(math.Abs(float64(a[i] - b[i])))
+		if a[i] != b[i] {
+			return false
+		}
 	}
-	return sum
+	return true
 }
 
 /*
-hammingDistance calculates the Hamming distance between two vectors
+containsParent reports whether a node is among the candidate parents
 */
-func (g *HNSWGraph) hammingDistance(a, b []float32) float32 {
-	var sum float32
-	for i := range a {
-		if a[i] != b[i] {
-			sum++
+func containsParent(parents []nodeDistance, id uint32) bool {
+	for _, parent := range parents {
+		if parent.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+containsNode reports whether ids holds id
+*/
+func containsNode(ids []uint32, id uint32) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
 		}
 	}
-	return sum
+	return false
 }
 
 /*
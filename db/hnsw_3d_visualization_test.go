@@ -105,7 +105,7 @@ func visualize3DSearch(graph *HNSWGraph, vectors []Vector, query []float32, k in
 	// Track search process
 	for z := graph.MaxLayer; z >= 0 && z < depth; z-- {
 		// Get neighbors in current layer
-		neighbors := graph.Layers[z][current]
+		neighbors := graph.Neighbors(current, z)
 		if neighbors == nil {
 			continue
 		}
@@ -115,14 +115,14 @@ func visualize3DSearch(graph *HNSWGraph, vectors []Vector, query []float32, k in
 
 		// Find best neighbor
 		bestNeighbor := current
-		minDist := graph.Distance(query, graph.Vectors[current].Data)
+		minDist := graph.Distance(query, graphVector(graph, current).Data)
 
 		// Add all neighbors to search path
 		for _, neighbor := range neighbors {
 			if !visited[neighbor] {
 				visited[neighbor] = true
 				searchPath[z] = append(searchPath[z], neighbor)
-				dist := graph.Distance(query, graph.Vectors[neighbor].Data)
+				dist := graph.Distance(query, graphVector(graph, neighbor).Data)
 				if dist < minDist {
 					minDist = dist
 					bestNeighbor = neighbor
@@ -140,16 +140,16 @@ func visualize3DSearch(graph *HNSWGraph, vectors []Vector, query []float32, k in
 	for z, path := range searchPath {
 		if len(path) > 0 {
 			// Plot current point (first in path)
-			x := int(graph.Vectors[path[0]].Data[0] * scaleX)
-			y := int(graph.Vectors[path[0]].Data[1] * scaleY)
+			x := int(graphVector(graph, path[0]).Data[0] * scaleX)
+			y := int(graphVector(graph, path[0]).Data[1] * scaleY)
 			if x >= 0 && x < width && y >= 0 && y < height {
 				grid[z][y][x] = 'O'
 			}
 
 			// Plot neighbors (rest of path)
 			for _, point := range path[1:] {
-				x := int(graph.Vectors[point].Data[0] * scaleX)
-				y := int(graph.Vectors[point].Data[1] * scaleY)
+				x := int(graphVector(graph, point).Data[0] * scaleX)
+				y := int(graphVector(graph, point).Data[1] * scaleY)
 				if x >= 0 && x < width && y >= 0 && y < height {
 					grid[z][y][x] = '*'
 				}
@@ -189,13 +189,13 @@ func visualize3DSearch(graph *HNSWGraph, vectors []Vector, query []float32, k in
 			fmt.Printf("Layer %d: Visited %d points\n", z, len(path))
 			fmt.Printf("  Start: Vector %s (%.2f, %.2f)\n",
 				path[0],
-				graph.Vectors[path[0]].Data[0],
-				graph.Vectors[path[0]].Data[1])
+				graphVector(graph, path[0]).Data[0],
+				graphVector(graph, path[0]).Data[1])
 			if len(path) > 1 {
 				fmt.Printf("  End: Vector %s (%.2f, %.2f)\n",
 					path[len(path)-1],
-					graph.Vectors[path[len(path)-1]].Data[0],
-					graph.Vectors[path[len(path)-1]].Data[1])
+					graphVector(graph, path[len(path)-1]).Data[0],
+					graphVector(graph, path[len(path)-1]).Data[1])
 			}
 		}
 	}
@@ -288,3 +288,114 @@ func TestHNSWAccuracy(t *testing.T) {
 		fmt.Printf("Scored accuracy is consistent with Recall@k (>= ~%.4f based on Recall)\n", expectedMinScore)
 	}
 }
+
+// TestQuantizationRecall compares Recall@k of the same dataset indexed without
+// quantization and with every codec, so the cost of compression stays visible.
+func TestQuantizationRecall(t *testing.T) {
+	// --- Test Configuration ---
+	m := 16
+	efConstruction := 100
+	efSearch := 100
+	k := 10
+	dimensions := 32
+	numVectors := 2000
+	numQueries := 20
+
+	codecs := []config.QuantizationConfig{
+		{Type: config.QuantizationNone},
+		{Type: config.QuantizationScalar},
+		{Type: config.QuantizationProduct, Subspaces: 8},
+		{Type: config.QuantizationBinary},
+	}
+
+	// --- Data Generation ---
+	// Values are centered on zero so the signs kept by binary codes carry information
+	rng := rand.New(rand.NewSource(42))
+	randomData := func() []float32 {
+		data := make([]float32, dimensions)
+		for j := range data {
+			data[j] = rng.Float32()*100 - 50
+		}
+		return data
+	}
+	vectors := make([]Vector, numVectors)
+	for i := range vectors {
+		vectors[i] = Vector{ID: fmt.Sprintf("vec_%d", i), Data: randomData()}
+	}
+	queries := make([][]float32, numQueries)
+	for i := range queries {
+		queries[i] = randomData()
+	}
+
+	// --- Ground Truth ---
+	distance := NewHNSWGraph(m, efConstruction, config.DistanceTypeEuclidean).Distance
+	truth := make([]map[string]bool, numQueries)
+	for i, query := range queries {
+		sorted := append([]Vector(nil), vectors...)
+		sort.Slice(sorted, func(a, b int) bool {
+			return distance(query, sorted[a].Data) < distance(query, sorted[b].Data)
+		})
+		truth[i] = make(map[string]bool, k)
+		for _, vector := range sorted[:k] {
+			truth[i][vector.ID] = true
+		}
+	}
+
+	// --- Search With Every Codec ---
+	fmt.Printf("\n=== Quantization Recall@%d (%d vectors, %d dimensions, efSearch=%d) ===\n", k, numVectors, dimensions, efSearch)
+	recalls := make([]float64, len(codecs))
+	for c, codec := range codecs {
+		// Train on the whole dataset so every codec sees the same vectors
+		codec.TrainingSize = numVectors
+		graph := NewHNSWGraph(m, efConstruction, config.DistanceTypeEuclidean)
+		graph.EfSearch = efSearch
+		if err := graph.SetQuantization(codec, dimensions); err != nil {
+			t.Fatalf("Failed to configure %q quantization: %v", codec.Type, err)
+		}
+
+		startTime := time.Now()
+		for _, err := range graph.InsertBatch(vectors, 4) {
+			if err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+		}
+		insertDuration := time.Since(startTime)
+
+		found := 0
+		startTime = time.Now()
+		for i, query := range queries {
+			results, err := graph.Search(query, k)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			for _, result := range results {
+				if truth[i][result.ID] {
+					found++
+				}
+			}
+		}
+		searchDuration := time.Since(startTime)
+
+		name := string(codec.Type)
+		if name == "" {
+			name = "none"
+		}
+		recalls[c] = float64(found) / float64(k*numQueries)
+		fmt.Printf("%-8s Recall@%d: %.4f  insert %s, %d searches %s\n", name, k, recalls[c], insertDuration, numQueries, searchDuration)
+	}
+
+	// Reranking with the full-precision vectors keeps compressed recall near the
+	// unquantized graph; product codes lose more than scalar ones, and sign bits
+	// are only a coarse approximation of Euclidean distances
+	minimumRatio := map[config.QuantizationType]float64{
+		config.QuantizationScalar:  0.8,
+		config.QuantizationProduct: 0.6,
+		config.QuantizationBinary:  0.5,
+	}
+	for c, codec := range codecs[1:] {
+		if recalls[c+1] < minimumRatio[codec.Type]*recalls[0] {
+			t.Errorf("%s recall (%.4f) is below %.0f%% of the unquantized recall (%.4f)",
+				codec.Type, recalls[c+1], minimumRatio[codec.Type]*100, recalls[0])
+		}
+	}
+}
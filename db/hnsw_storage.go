@@ -0,0 +1,349 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// firstChunkBits is log2 of the number of nodes in the first storage chunk; every
+// further chunk holds twice as many nodes as the previous one
+const firstChunkBits = 6
+
+/*
+nodeChunk holds the nodes of a run of consecutive internal IDs together with their
+vector components, stored back to back in one arena. Chunks are never moved or
+resized once allocated, so slices into the arena stay valid for as long as anyone
+holds them. Quantized graphs keep the components on disk and leave data nil.
+*/
+type nodeChunk struct {
+	nodes []hnswNode
+	data  []float32
+}
+
+/*
+nodeStore is the directory of chunks. It is replaced rather than modified when a
+chunk is added, so searches can read it without taking a lock.
+*/
+type nodeStore struct {
+	chunks []*nodeChunk
+}
+
+/*
+chunkPosition returns the chunk holding an internal ID and the ID's offset within it
+*/
+func chunkPosition(id uint32) (chunk, offset int) {
+	position := uint64(id) + 1<<firstChunkBits
+	chunk = bits.Len64(position) - firstChunkBits - 1
+	return chunk, int(position - 1<<(chunk+firstChunkBits))
+}
+
+/*
+node returns the node stored under an internal ID. The ID must have been handed out
+by allocate.
+*/
+func (g *HNSWGraph) node(id uint32) *hnswNode {
+	chunk, offset := chunkPosition(id)
+	return &g.store.Load().chunks[chunk].nodes[offset]
+}
+
+/*
+lookup returns the internal ID of the vector stored under an ID
+*/
+func (g *HNSWGraph) lookup(id string) (uint32, bool) {
+	g.idMu.RLock()
+	defer g.idMu.RUnlock()
+
+	internal, exists := g.ids[id]
+	return internal, exists
+}
+
+/*
+nodeByID returns the node of the vector stored under an ID, or nil if there is none
+*/
+func (g *HNSWGraph) nodeByID(id string) *hnswNode {
+	internal, exists := g.lookup(id)
+	if !exists {
+		return nil
+	}
+	return g.node(internal)
+}
+
+/*
+allocate reserves an internal ID for a new vector and stores its components as
+they are, failing if the ID is already present or the dimensions differ from the
+vectors stored before. The components are copied into the arena, or in quantized
+graphs written to the on-disk store and encoded. The returned node is not linked
+yet.
+
+IDs released by deletions are handed out again before the arena grows. Deletions
+take the graph lock exclusively, so no search that could still reach a released
+node is in flight, and results only hold copies of the data.
+*/
+func (g *HNSWGraph) allocate(vector Vector, level int) (uint32, *hnswNode, error) {
+	g.idMu.Lock()
+	defer g.idMu.Unlock()
+
+	if _, exists := g.ids[vector.ID]; exists {
+		return 0, nil, fmt.Errorf("vector with ID %s: %w", vector.ID, ErrVectorExists)
+	}
+	data := vector.Data
+	if g.dimensions == 0 {
+		g.dimensions = len(data)
+	} else if len(data) != g.dimensions {
+		return 0, nil, fmt.Errorf("%w: %d, graph has %d", ErrDifferentDims, len(data), g.dimensions)
+	}
+
+	id := g.allocated.Load()
+	reused := len(g.free) > 0
+	if reused {
+		id = g.free[len(g.free)-1]
+	} else if id == math.MaxUint32 {
+		return 0, nil, fmt.Errorf("%w: graph is full", ErrInvalidParameter)
+	}
+
+	chunk, offset := chunkPosition(id)
+	store := g.store.Load()
+	if chunk == len(store.chunks) {
+		size := 1 << (chunk + firstChunkBits)
+		added := &nodeChunk{nodes: make([]hnswNode, size)}
+		if g.disk == nil {
+			added.data = make([]float32, size*g.dimensions)
+		}
+		chunks := make([]*nodeChunk, len(store.chunks), len(store.chunks)+1)
+		copy(chunks, store.chunks)
+		store = &nodeStore{chunks: append(chunks, added)}
+		g.store.Store(store)
+	}
+
+	node := &store.chunks[chunk].nodes[offset]
+	if err := g.setData(id, node, vector); err != nil {
+		return 0, nil, err
+	}
+	node.level = level
+	node.deleted = false
+
+	g.ids[vector.ID] = id
+	if reused {
+		g.free = g.free[:len(g.free)-1]
+	} else {
+		g.allocated.Store(id + 1)
+	}
+	return id, node, nil
+}
+
+/*
+setData stores a vector in a node: its components are copied into the arena, or in
+quantized graphs written to the on-disk store and encoded, and the factor
+normalizing them is derived. The caller must hold the ID lock or the graph lock
+exclusively, and the components must have the graph's dimensions.
+*/
+func (g *HNSWGraph) setData(id uint32, node *hnswNode, vector Vector) error {
+	data := vector.Data
+	if g.disk != nil {
+		if err := g.disk.write(id, data); err != nil {
+			return err
+		}
+		vector.Data = nil
+	} else {
+		// Cap the slice so appending to it cannot overwrite the next vector
+		chunk, offset := chunkPosition(id)
+		start := offset * g.dimensions
+		vector.Data = g.store.Load().chunks[chunk].data[start : start+g.dimensions : start+g.dimensions]
+		copy(vector.Data, data)
+	}
+
+	node.vector = vector
+	node.scale = g.unitScale(data)
+	if g.quantizer != nil {
+		node.code = g.quantizer.encode(g.unitVector(data, node.scale))
+	}
+	return nil
+}
+
+/*
+forEachNode calls fn with every stored node in internal ID order. The caller must
+hold the graph lock; nodes inserted concurrently may or may not be included. The ID
+lock is held throughout, so released IDs are not handed out again meanwhile and fn
+must not look up IDs.
+*/
+func (g *HNSWGraph) forEachNode(fn func(id uint32, node *hnswNode)) {
+	g.idMu.RLock()
+	defer g.idMu.RUnlock()
+
+	allocated := g.allocated.Load()
+	for id := uint32(0); id < allocated; id++ {
+		if node := g.node(id); !node.deleted {
+			fn(id, node)
+		}
+	}
+}
+
+/*
+resetStorage drops every node and internal ID. The caller must hold the graph lock
+exclusively.
+*/
+func (g *HNSWGraph) resetStorage(dimensions int) {
+	g.idMu.Lock()
+	defer g.idMu.Unlock()
+
+	if g.disk != nil {
+		g.disk.close()
+		g.disk = nil
+	}
+	g.ids = make(map[string]uint32)
+	g.free = nil
+	g.store.Store(&nodeStore{})
+	g.allocated.Store(0)
+	g.dimensions = dimensions
+	g.count.Store(0)
+}
+
+/*
+visitedSet is a bitset of internal IDs seen by a search. It remembers which words
+it set bits in, so it can be cleared for reuse without sweeping the whole set.
+*/
+type visitedSet struct {
+	words   []uint64
+	touched []int
+	// number of IDs visited
+	count int
+}
+
+/*
+visit marks an ID as visited, reporting whether it was not visited before
+*/
+func (v *visitedSet) visit(id uint32) bool {
+	word, bit := int(id/64), uint64(1)<<(id%64)
+	if word >= len(v.words) {
+		v.words = append(v.words, make([]uint64, word+1-len(v.words))...)
+	}
+	if v.words[word]&bit != 0 {
+		return false
+	}
+	if v.words[word] == 0 {
+		v.touched = append(v.touched, word)
+	}
+	v.words[word] |= bit
+	v.count++
+	return true
+}
+
+/*
+contains reports whether an ID was visited
+*/
+func (v *visitedSet) contains(id uint32) bool {
+	word := int(id / 64)
+	return word < len(v.words) && v.words[word]&(uint64(1)<<(id%64)) != 0
+}
+
+/*
+acquireVisited takes an empty visited set from the pool, sized for the current graph
+*/
+func (g *HNSWGraph) acquireVisited() *visitedSet {
+	if v, ok := g.visitedPool.Get().(*visitedSet); ok {
+		return v
+	}
+	return &visitedSet{words: make([]uint64, (g.allocated.Load()+63)/64)}
+}
+
+/*
+releaseVisited clears a visited set and returns it to the pool
+*/
+func (g *HNSWGraph) releaseVisited(v *visitedSet) {
+	for _, word := range v.touched {
+		v.words[word] = 0
+	}
+	v.touched = v.touched[:0]
+	v.count = 0
+	g.visitedPool.Put(v)
+}
+
+/*
+nodeDistance is a node identified by its internal ID together with its distance to a query
+*/
+type nodeDistance struct {
+	id       uint32
+	distance float32
+}
+
+/*
+nodeQueue is a binary heap of nodes, nearest first or, with farthestFirst, farthest
+first. Nodes at equal distances are ordered by internal ID, so ties come out in
+insertion order. It works on values rather than through container/heap, so pushing
+a node does not allocate.
+*/
+type nodeQueue struct {
+	items         []nodeDistance
+	farthestFirst bool
+}
+
+func (q *nodeQueue) len() int { return len(q.items) }
+
+func (q *nodeQueue) top() nodeDistance { return q.items[0] }
+
+func (q *nodeQueue) before(i, j int) bool {
+	if q.farthestFirst {
+		return nearer(q.items[j], q.items[i])
+	}
+	return nearer(q.items[i], q.items[j])
+}
+
+/*
+nearer reports whether a ranks before b, nearest first and by internal ID on ties
+*/
+func nearer(a, b nodeDistance) bool {
+	if a.distance != b.distance {
+		return a.distance < b.distance
+	}
+	return a.id < b.id
+}
+
+func (q *nodeQueue) push(item nodeDistance) {
+	q.items = append(q.items, item)
+	for i := len(q.items) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !q.before(i, parent) {
+			break
+		}
+		q.items[i], q.items[parent] = q.items[parent], q.items[i]
+		i = parent
+	}
+}
+
+func (q *nodeQueue) pop() nodeDistance {
+	item := q.items[0]
+	last := len(q.items) - 1
+	q.items[0] = q.items[last]
+	q.items = q.items[:last]
+
+	for i := 0; ; {
+		first, left, right := i, 2*i+1, 2*i+2
+		if left < last && q.before(left, first) {
+			first = left
+		}
+		if right < last && q.before(right, first) {
+			first = right
+		}
+		if first == i {
+			break
+		}
+		q.items[i], q.items[first] = q.items[first], q.items[i]
+		i = first
+	}
+	return item
+}
+
+/*
+drain empties the queue, returning its items in the reverse of the order they would
+be popped in, so a farthest-first queue yields its nodes nearest first. The items
+are sorted in place without allocating.
+*/
+func (q *nodeQueue) drain() []nodeDistance {
+	items := q.items
+	for n := len(items); n > 0; n-- {
+		items[n-1] = q.pop()
+	}
+	q.items = nil
+	return items
+}
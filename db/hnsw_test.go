@@ -1,8 +1,12 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"sort"
+	"sync"
 	"testing"
 
 	"vector-db/config"
@@ -125,6 +129,144 @@ func TestHNSWConcurrentOperations(t *testing.T) {
 	for i := 0; i < 2; i++ {
 		<-done
 	}
+
+	// Mixed load: writers insert, update and delete while readers keep searching.
+	// Run with -race to check the graph, node and entry point locking.
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				results, err := graph.SearchWithOptions(query, 5, SearchOptions{Ef: 20})
+				if err != nil || len(results) == 0 {
+					t.Errorf("Search during writes returned %d results (err %v)", len(results), err)
+					return
+				}
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for j := 0; j < 100; j++ {
+				vector := make([]float32, dimensions)
+				for k := range vector {
+					vector[k] = rand.Float32()
+				}
+				id := fmt.Sprintf("stress-%d-%d", w, j)
+				if err := graph.Insert(Vector{ID: id, Data: vector}); err != nil {
+					t.Errorf("Insert failed: %v", err)
+					return
+				}
+
+				switch j % 10 {
+				case 4:
+					// Metadata-only update, then a move that relinks the node
+					if err := graph.Update(Vector{ID: id, Data: vector, Metadata: map[string]interface{}{"w": w}}); err != nil {
+						t.Errorf("Update failed: %v", err)
+					}
+					moved := make([]float32, dimensions)
+					for k := range moved {
+						moved[k] = rand.Float32()
+					}
+					if err := graph.Update(Vector{ID: id, Data: moved}); err != nil {
+						t.Errorf("Update failed: %v", err)
+					}
+				case 9:
+					if err := graph.Delete(fmt.Sprintf("stress-%d-%d", w, j-5)); err != nil {
+						t.Errorf("Delete failed: %v", err)
+					}
+				}
+			}
+		}(w)
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	// Every write is accounted for and no link points at a deleted node
+	if expected := numVectors + 4*90; graph.Len() != expected {
+		t.Errorf("Expected %d vectors after concurrent writes, got %d", expected, graph.Len())
+	}
+	saved := graph.export()
+	for l, layer := range saved.Layers {
+		for nodeID, neighbors := range layer {
+			for _, neighbor := range neighbors {
+				if _, exists := saved.Levels[neighbor]; !exists {
+					t.Errorf("Node %s links to missing node %s in layer %d", nodeID, neighbor, l)
+				}
+			}
+		}
+	}
+}
+
+func TestHNSWInsertBatch(t *testing.T) {
+	graph := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	dimensions := 16
+	numVectors := 1000
+
+	vectors := make([]Vector, numVectors)
+	for i := range vectors {
+		data := make([]float32, dimensions)
+		for j := range data {
+			data[j] = rand.Float32()
+		}
+		vectors[i] = Vector{ID: fmt.Sprintf("%d", i), Data: data}
+	}
+	// A duplicate within the batch and an invalid vector are rejected individually
+	vectors = append(vectors, Vector{ID: "0", Data: vectors[0].Data}, Vector{ID: "empty"})
+
+	errs := graph.InsertBatch(vectors, 8)
+	for i := 0; i < numVectors; i++ {
+		if errs[i] != nil {
+			t.Fatalf("Vector %d was rejected: %v", i, errs[i])
+		}
+	}
+	if !errors.Is(errs[numVectors], ErrVectorExists) || errs[numVectors+1] != ErrEmptyVector {
+		t.Errorf("Expected ErrVectorExists and ErrEmptyVector, got %v and %v", errs[numVectors], errs[numVectors+1])
+	}
+	if graph.Len() != numVectors {
+		t.Fatalf("Expected %d vectors, got %d", numVectors, graph.Len())
+	}
+
+	// Every node is linked in layer 0, and the graph is about as navigable as one
+	// built sequentially: vectors are found as their own nearest neighbor as often
+	sequential := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	for i := 0; i < numVectors; i++ {
+		if len(graph.Neighbors(vectors[i].ID, 0)) == 0 {
+			t.Errorf("Vector %s has no neighbors in layer 0", vectors[i].ID)
+		}
+		sequential.Insert(vectors[i])
+	}
+	selfRecall := func(g *HNSWGraph) float64 {
+		found := 0
+		for i := 0; i < numVectors; i++ {
+			results, err := g.Search(vectors[i].Data, 1)
+			if err == nil && len(results) == 1 && results[0].ID == vectors[i].ID {
+				found++
+			}
+		}
+		return float64(found) / float64(numVectors)
+	}
+	if parallel, serial := selfRecall(graph), selfRecall(sequential); parallel < serial-0.05 {
+		t.Errorf("Expected self-recall close to the sequential build (%.3f), got %.3f", serial, parallel)
+	}
+
+	// Vectors already in the graph are rejected
+	errs = graph.InsertBatch(vectors[:1], 2)
+	if !errors.Is(errs[0], ErrVectorExists) {
+		t.Errorf("Expected ErrVectorExists for an existing vector, got %v", errs[0])
+	}
 }
 
 func TestHNSWDifferentDistanceMetrics(t *testing.T) {
@@ -173,3 +315,701 @@ func TestHNSWDifferentDistanceMetrics(t *testing.T) {
 		}
 	}
 }
+
+func TestHNSWDelete(t *testing.T) {
+	graph := NewHNSWGraph(8, 100, config.DistanceTypeEuclidean)
+	dimensions := 32
+	numVectors := 200
+
+	for i := 0; i < numVectors; i++ {
+		vector := make([]float32, dimensions)
+		for j := 0; j < dimensions; j++ {
+			vector[j] = rand.Float32()
+		}
+		if err := graph.Insert(Vector{ID: fmt.Sprintf("%d", i), Data: vector}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// Delete the entry point first, then a spread of other nodes
+	deleted := map[string]bool{graph.EntryPoint: true}
+	if err := graph.Delete(graph.EntryPoint); err != nil {
+		t.Fatalf("Delete of entry point failed: %v", err)
+	}
+	for i := 0; i < numVectors; i += 3 {
+		id := fmt.Sprintf("%d", i)
+		if deleted[id] {
+			continue
+		}
+		if err := graph.Delete(id); err != nil {
+			t.Fatalf("Delete failed for %s: %v", id, err)
+		}
+		deleted[id] = true
+	}
+
+	// Deleting a missing vector should fail
+	if err := graph.Delete("missing"); err != ErrVectorNotFound {
+		t.Errorf("Expected ErrVectorNotFound, got %v", err)
+	}
+
+	// The deleted IDs must be gone from every structure
+	if deleted[graph.EntryPoint] {
+		t.Errorf("Entry point %s was deleted but is still set", graph.EntryPoint)
+	}
+	if level, _ := graph.Level(graph.EntryPoint); level != graph.MaxLayer {
+		t.Errorf("Entry point level %d does not match max layer %d", level, graph.MaxLayer)
+	}
+	for l, layer := range graph.export().Layers {
+		for nodeID, neighbors := range layer {
+			if deleted[nodeID] {
+				t.Errorf("Deleted node %s still present in layer %d", nodeID, l)
+			}
+			for _, neighbor := range neighbors {
+				if deleted[neighbor] {
+					t.Errorf("Node %s still links to deleted node %s in layer %d", nodeID, neighbor, l)
+				}
+			}
+		}
+	}
+
+	// Every remaining node is still reachable, and the recorded inbound links match
+	if unreachable := unreachableNodes(graph); len(unreachable) > 0 {
+		t.Errorf("Nodes unreachable from the entry point after deletion: %v", unreachable)
+	}
+	checkInbound(t, graph)
+
+	// Searches must not return deleted vectors and should still find k results
+	remaining := numVectors - len(deleted)
+	if graph.Len() != remaining {
+		t.Fatalf("Expected %d vectors after deletion, got %d", remaining, graph.Len())
+	}
+	for q := 0; q < 10; q++ {
+		query := make([]float32, dimensions)
+		for j := 0; j < dimensions; j++ {
+			query[j] = rand.Float32()
+		}
+
+		results, err := graph.Search(query, 10)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 10 {
+			t.Errorf("Expected 10 results, got %d", len(results))
+		}
+		for _, result := range results {
+			if deleted[result.ID] {
+				t.Errorf("Search returned deleted vector %s", result.ID)
+			}
+		}
+	}
+
+	// Deleting everything leaves an empty, reusable graph
+	for id := range graph.export().Levels {
+		if err := graph.Delete(id); err != nil {
+			t.Fatalf("Delete failed for %s: %v", id, err)
+		}
+	}
+	if graph.EntryPoint != "" || graph.MaxLayer != 0 || graph.Len() != 0 {
+		t.Errorf("Expected empty graph, got entry point %q, max layer %d, %d vectors",
+			graph.EntryPoint, graph.MaxLayer, graph.Len())
+	}
+	if err := graph.Insert(Vector{ID: "fresh", Data: make([]float32, dimensions)}); err != nil {
+		t.Fatalf("Insert into emptied graph failed: %v", err)
+	}
+	results, err := graph.Search(make([]float32, dimensions), 1)
+	if err != nil || len(results) != 1 || results[0].ID != "fresh" {
+		t.Errorf("Expected to find the fresh vector, got %v (err %v)", results, err)
+	}
+}
+
+func TestHNSWHeavyDeletion(t *testing.T) {
+	dimensions := 32
+	numVectors := 2000
+	vectors := randomVectors(numVectors, dimensions)
+
+	// A narrow search makes recall depend on how well the graph is connected
+	graph := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	graph.EfSearch = 10
+	for _, vector := range vectors {
+		if err := graph.Insert(vector); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// Delete three vectors out of four in random order
+	var survivors []Vector
+	for i, j := range rand.Perm(numVectors) {
+		if i < numVectors/4 {
+			survivors = append(survivors, vectors[j])
+			continue
+		}
+		if err := graph.Delete(vectors[j].ID); err != nil {
+			t.Fatalf("Delete failed for %s: %v", vectors[j].ID, err)
+		}
+	}
+	if graph.Len() != len(survivors) {
+		t.Fatalf("Expected %d vectors after deletion, got %d", len(survivors), graph.Len())
+	}
+
+	if unreachable := unreachableNodes(graph); len(unreachable) > 0 {
+		t.Errorf("%d nodes unreachable from the entry point after deletion: %v", len(unreachable), unreachable)
+	}
+	checkInbound(t, graph)
+
+	// Recall stays close to a graph built from the surviving vectors alone
+	fresh := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	fresh.EfSearch = 10
+	for _, vector := range survivors {
+		fresh.Insert(vector)
+	}
+	deletedRecall, freshRecall := recallAtK(t, graph, survivors, 10), recallAtK(t, fresh, survivors, 10)
+	t.Logf("Recall@10: %.3f after deletion, %.3f freshly built", deletedRecall, freshRecall)
+	if deletedRecall < freshRecall-0.1 {
+		t.Errorf("Recall after deletion %.3f is far below the freshly built graph's %.3f", deletedRecall, freshRecall)
+	}
+}
+
+/*
+unreachableNodes returns the IDs of the nodes a breadth-first walk of layer 0 from
+the entry point does not reach
+*/
+func unreachableNodes(graph *HNSWGraph) []string {
+	f := graph.export()
+	if f.EntryPoint == "" {
+		return nil
+	}
+	reached := map[string]bool{f.EntryPoint: true}
+	queue := []string{f.EntryPoint}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, neighbor := range f.Layers[0][id] {
+			if !reached[neighbor] {
+				reached[neighbor] = true
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+
+	var unreachable []string
+	for id := range f.Levels {
+		if !reached[id] {
+			unreachable = append(unreachable, id)
+		}
+	}
+	sort.Strings(unreachable)
+	return unreachable
+}
+
+/*
+checkInbound verifies that the inbound links recorded by every node match the links
+pointing at it
+*/
+func checkInbound(t *testing.T, graph *HNSWGraph) {
+	t.Helper()
+
+	want := make(map[uint32][][]uint32)
+	graph.forEachNode(func(id uint32, node *hnswNode) {
+		for l, neighbors := range node.links {
+			for _, neighbor := range neighbors {
+				for len(want[neighbor]) <= l {
+					want[neighbor] = append(want[neighbor], nil)
+				}
+				want[neighbor][l] = append(want[neighbor][l], id)
+			}
+		}
+	})
+	graph.forEachNode(func(id uint32, node *hnswNode) {
+		for l := 0; l < max(len(node.inbound), len(want[id])); l++ {
+			var got, expected []uint32
+			if l < len(node.inbound) {
+				got = append(got, node.inbound[l]...)
+			}
+			if l < len(want[id]) {
+				expected = want[id][l]
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+			if fmt.Sprint(got) != fmt.Sprint(expected) {
+				t.Errorf("Node %s records inbound links %v in layer %d, linked from %v", node.vector.ID, got, l, expected)
+			}
+		}
+	})
+}
+
+/*
+recallAtK returns the share of the true k nearest vectors a graph finds, averaged
+over random queries
+*/
+func recallAtK(t *testing.T, graph *HNSWGraph, vectors []Vector, k int) float64 {
+	t.Helper()
+
+	found, queries := 0, 50
+	for _, query := range randomVectors(queries, len(vectors[0].Data)) {
+		sorted := append([]Vector(nil), vectors...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return graph.Distance(query.Data, sorted[i].Data) < graph.Distance(query.Data, sorted[j].Data)
+		})
+		truth := make(map[string]bool, k)
+		for _, vector := range sorted[:k] {
+			truth[vector.ID] = true
+		}
+
+		results, err := graph.Search(query.Data, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		for _, result := range results {
+			if truth[result.ID] {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(queries*k)
+}
+
+func TestHNSWUpdate(t *testing.T) {
+	graph := NewHNSWGraph(8, 100, config.DistanceTypeEuclidean)
+	dimensions := 16
+
+	for i := 0; i < 50; i++ {
+		vector := make([]float32, dimensions)
+		for j := 0; j < dimensions; j++ {
+			vector[j] = float32(i)
+		}
+		if err := graph.Insert(Vector{ID: fmt.Sprintf("%d", i), Data: vector}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// Inserting an existing ID is rejected
+	if err := graph.Insert(Vector{ID: "0", Data: make([]float32, dimensions)}); !errors.Is(err, ErrVectorExists) {
+		t.Errorf("Expected ErrVectorExists, got %v", err)
+	}
+
+	// Metadata-only update keeps the adjacency lists untouched
+	before := append([]string(nil), graph.Neighbors("10", 0)...)
+	data := append([]float32(nil), graphVector(graph, "10").Data...)
+	if err := graph.Update(Vector{ID: "10", Data: data, Metadata: map[string]interface{}{"tag": "x"}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if graphVector(graph, "10").Metadata["tag"] != "x" {
+		t.Errorf("Metadata was not updated")
+	}
+	after := graph.Neighbors("10", 0)
+	if len(before) != len(after) {
+		t.Errorf("Metadata-only update changed neighbors: %v -> %v", before, after)
+	}
+
+	// Moving a vector relinks it near its new position
+	moved := make([]float32, dimensions)
+	for j := range moved {
+		moved[j] = 40.2
+	}
+	if err := graph.Update(Vector{ID: "10", Data: moved}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	results, err := graph.Search(moved, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != "10" {
+		t.Errorf("Expected moved vector 10 as nearest neighbor, got %v", results)
+	}
+
+	// A vector of other dimensions is rejected and the stored one is kept
+	if err := graph.Update(Vector{ID: "10", Data: []float32{1, 2}}); !errors.Is(err, ErrDifferentDims) {
+		t.Errorf("Expected ErrDifferentDims, got %v", err)
+	}
+	if _, exists := graph.GetVector("10"); !exists {
+		t.Errorf("Rejected update removed vector 10")
+	}
+
+	// Updating an unknown ID inserts it
+	if err := graph.Update(Vector{ID: "new", Data: make([]float32, dimensions)}); err != nil {
+		t.Fatalf("Update of new ID failed: %v", err)
+	}
+	if graph.Len() != 51 {
+		t.Errorf("Expected 51 vectors, got %d", graph.Len())
+	}
+}
+
+func TestHNSWReembedding(t *testing.T) {
+	dimensions := 32
+	numVectors := 1000
+	vectors := randomVectors(numVectors, dimensions)
+
+	// A narrow search makes recall depend on how well the graph is connected
+	graph := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	graph.EfSearch = 10
+	for _, vector := range vectors {
+		if err := graph.Insert(vector); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// Move most vectors, the entry point included, to new random positions
+	moved := randomVectors(numVectors, dimensions)
+	for i := 0; i < numVectors*3/4; i++ {
+		if err := graph.Update(moved[i]); err != nil {
+			t.Fatalf("Update failed for %s: %v", moved[i].ID, err)
+		}
+		vectors[i] = moved[i]
+	}
+	if graph.Len() != numVectors {
+		t.Fatalf("Expected %d vectors after updating, got %d", numVectors, graph.Len())
+	}
+
+	if unreachable := unreachableNodes(graph); len(unreachable) > 0 {
+		t.Errorf("%d nodes unreachable from the entry point after updating: %v", len(unreachable), unreachable)
+	}
+	checkInbound(t, graph)
+
+	// Recall stays close to a graph built from the current vectors
+	fresh := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	fresh.EfSearch = 10
+	for _, vector := range vectors {
+		fresh.Insert(vector)
+	}
+	updatedRecall, freshRecall := recallAtK(t, graph, vectors, 10), recallAtK(t, fresh, vectors, 10)
+	t.Logf("Recall@10: %.3f after updating, %.3f freshly built", updatedRecall, freshRecall)
+	if updatedRecall < freshRecall-0.1 {
+		t.Errorf("Recall after updating %.3f is far below the freshly built graph's %.3f", updatedRecall, freshRecall)
+	}
+}
+
+func TestHNSWSearchWithOptions(t *testing.T) {
+	dimensions := 16
+	for _, metric := range []config.DistanceType{config.DistanceTypeEuclidean, config.DistanceTypeCosine} {
+		graph := NewHNSWGraph(8, 100, metric)
+		for i := 0; i < 50; i++ {
+			vector := make([]float32, dimensions)
+			for j := 0; j < dimensions; j++ {
+				vector[j] = rand.Float32()
+			}
+			if err := graph.Insert(Vector{ID: fmt.Sprintf("%d", i), Data: vector, Metadata: map[string]interface{}{"i": i}}); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+		}
+
+		query := graphVector(graph, "7").Data
+		results, err := graph.SearchWithOptions(query, 5, SearchOptions{})
+		if err != nil {
+			t.Fatalf("Search failed for metric %v: %v", metric, err)
+		}
+		if len(results) != 5 {
+			t.Fatalf("Expected 5 results for metric %v, got %d", metric, len(results))
+		}
+		if results[0].ID != "7" {
+			t.Errorf("Expected the query vector itself first for metric %v, got %s", metric, results[0].ID)
+		}
+
+		prepared := graph.prepare(query)
+		for i, result := range results {
+			// Distances are reported as computed and in ascending order
+			stored := graphVector(graph, result.ID).Data
+			if want := graph.storedDistance(prepared, stored, graph.nodeByID(result.ID).scale); result.Distance != want {
+				t.Errorf("Result %s distance %f, want %f", result.ID, result.Distance, want)
+			}
+			if i > 0 && results[i-1].Distance > result.Distance {
+				t.Errorf("Distances not in ascending order: %f > %f", results[i-1].Distance, result.Distance)
+			}
+			if i > 0 && results[i-1].Score < result.Score {
+				t.Errorf("Scores not in descending order: %f < %f", results[i-1].Score, result.Score)
+			}
+			if result.Data == nil || result.Metadata == nil {
+				t.Errorf("Expected vector data and metadata in result %s", result.ID)
+			}
+		}
+
+		// The query vector itself scores as a perfect match
+		if math.Abs(float64(results[0].Score-1)) > 1e-5 {
+			t.Errorf("Expected score 1 for an exact match with metric %v, got %f", metric, results[0].Score)
+		}
+
+		// Omitted fields stay empty
+		results, err = graph.SearchWithOptions(query, 5, SearchOptions{OmitVectors: true, OmitMetadata: true})
+		if err != nil {
+			t.Fatalf("Search failed for metric %v: %v", metric, err)
+		}
+		for _, result := range results {
+			if result.Data != nil || result.Metadata != nil {
+				t.Errorf("Expected vector data and metadata to be omitted in result %s", result.ID)
+			}
+		}
+
+		// Tuning options: a looser early stop still ranks the exact match first, and
+		// the visit budget caps how many nodes can end up in the results
+		results, err = graph.SearchWithOptions(query, 5, SearchOptions{QualityThreshold: 2})
+		if err != nil || len(results) != 5 || results[0].ID != "7" {
+			t.Errorf("Expected 5 results led by 7 with a loose threshold, got %v (err %v)", results, err)
+		}
+		results, err = graph.SearchWithOptions(query, 5, SearchOptions{MaxVisited: 3})
+		if err != nil || len(results) == 0 || len(results) > 3 {
+			t.Errorf("Expected between 1 and 3 results with a visit budget of 3, got %d (err %v)", len(results), err)
+		}
+		for _, opts := range []SearchOptions{{Ef: -1}, {MaxVisited: -1}, {QualityThreshold: 0.5}} {
+			if _, err := graph.SearchWithOptions(query, 5, opts); !errors.Is(err, ErrInvalidParameter) {
+				t.Errorf("Expected ErrInvalidParameter for %+v, got %v", opts, err)
+			}
+		}
+	}
+}
+
+func TestHNSWFilteredSearch(t *testing.T) {
+	graph := NewHNSWGraph(8, 100, config.DistanceTypeEuclidean)
+	graph.EfSearch = 20
+	dimensions := 16
+	numVectors := 1000
+
+	// Only every 50th vector belongs to the rare tenant
+	for i := 0; i < numVectors; i++ {
+		vector := make([]float32, dimensions)
+		for j := 0; j < dimensions; j++ {
+			vector[j] = rand.Float32()
+		}
+		tenant := "common"
+		if i%50 == 0 {
+			tenant = "rare"
+		}
+		metadata := map[string]interface{}{"tenant": tenant, "index": i}
+		if err := graph.Insert(Vector{ID: fmt.Sprintf("%d", i), Data: vector, Metadata: metadata}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	query := make([]float32, dimensions)
+	for j := 0; j < dimensions; j++ {
+		query[j] = rand.Float32()
+	}
+
+	filter := &Filter{Op: FilterEq, Key: "tenant", Value: "rare"}
+	results, err := graph.SearchWithOptions(query, 10, SearchOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	// Sparse matches must still fill the requested k
+	if len(results) != 10 {
+		t.Fatalf("Expected 10 filtered results, got %d", len(results))
+	}
+	for i, result := range results {
+		if result.Metadata["tenant"] != "rare" {
+			t.Errorf("Result %s does not match the filter", result.ID)
+		}
+		if i > 0 && results[i-1].Distance > result.Distance {
+			t.Errorf("Distances not in ascending order: %f > %f", results[i-1].Distance, result.Distance)
+		}
+	}
+
+	// A filter matching nothing returns no results
+	results, err = graph.SearchWithOptions(query, 10, SearchOptions{Filter: &Filter{Op: FilterEq, Key: "tenant", Value: "none"}})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected no results, got %d", len(results))
+	}
+}
+
+func TestHNSWRangeSearch(t *testing.T) {
+	graph := NewHNSWGraph(8, 100, config.DistanceTypeEuclidean)
+	graph.EfSearch = 50
+	dimensions := 3
+	numVectors := 1000
+
+	vectors := make([]Vector, numVectors)
+	for i := range vectors {
+		data := make([]float32, dimensions)
+		for j := range data {
+			data[j] = rand.Float32()
+		}
+		vectors[i] = Vector{ID: fmt.Sprintf("%d", i), Data: data, Metadata: map[string]interface{}{"even": i%2 == 0}}
+		if err := graph.Insert(vectors[i]); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	query := make([]float32, dimensions)
+	for j := range query {
+		query[j] = rand.Float32()
+	}
+
+	// Pick the radius holding the 200 nearest vectors, well beyond ef
+	distances := make([]float32, numVectors)
+	for i, vector := range vectors {
+		distances[i] = graph.Distance(query, vector.Data)
+	}
+	sorted := append([]float32(nil), distances...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	radius := sorted[199]
+
+	results, err := graph.RangeSearch(query, radius, 0)
+	if err != nil {
+		t.Fatalf("Range search failed: %v", err)
+	}
+	if len(results) <= graph.EfSearch || len(results) > 200 {
+		t.Errorf("Expected more than ef and at most 200 vectors within the radius, got %d", len(results))
+	}
+	for i, result := range results {
+		if result.Distance > radius {
+			t.Errorf("Result %s at distance %f is outside the radius %f", result.ID, result.Distance, radius)
+		}
+		if i > 0 && results[i-1].Distance > result.Distance {
+			t.Errorf("Distances not in ascending order: %f > %f", results[i-1].Distance, result.Distance)
+		}
+	}
+
+	// A limit caps the number of results
+	limited, err := graph.RangeSearch(query, radius, 5)
+	if err != nil || len(limited) != 5 {
+		t.Fatalf("Expected 5 results with a limit, got %d (err %v)", len(limited), err)
+	}
+	for i, result := range limited {
+		if result.Distance > radius || (i > 0 && limited[i-1].Distance > result.Distance) {
+			t.Errorf("Limited result %s at distance %f is out of range or order", result.ID, result.Distance)
+		}
+	}
+
+	// Filters apply, and a radius of 0 only finds exact matches
+	filtered, err := graph.RangeSearchWithOptions(query, radius, 0, SearchOptions{Filter: &Filter{Op: FilterEq, Key: "even", Value: true}})
+	if err != nil || len(filtered) == 0 || len(filtered) >= len(results) {
+		t.Errorf("Expected a non-empty subset with the filter, got %d of %d (err %v)", len(filtered), len(results), err)
+	}
+	for _, result := range filtered {
+		if result.Metadata["even"] != true {
+			t.Errorf("Result %s does not match the filter", result.ID)
+		}
+	}
+	exact, err := graph.RangeSearch(vectors[7].Data, 0, 0)
+	if err != nil || len(exact) > 1 || (len(exact) == 1 && exact[0].ID != "7") {
+		t.Errorf("Expected at most vector 7 at radius 0, got %v (err %v)", exact, err)
+	}
+
+	if _, err := graph.RangeSearch(query, -1, 0); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for a negative radius, got %v", err)
+	}
+	if _, err := graph.RangeSearch(query, radius, -1); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for a negative limit, got %v", err)
+	}
+}
+
+/*
+graphVector returns the vector stored in the graph under an ID, or an empty one
+*/
+func graphVector(graph *HNSWGraph, id string) Vector {
+	vector, _ := graph.GetVector(id)
+	return vector
+}
+
+func TestHNSWStorage(t *testing.T) {
+	// Chunks double in size, starting with 64 nodes
+	positions := map[uint32][2]int{0: {0, 0}, 63: {0, 63}, 64: {1, 0}, 191: {1, 127}, 192: {2, 0}}
+	for id, want := range positions {
+		if chunk, offset := chunkPosition(id); chunk != want[0] || offset != want[1] {
+			t.Errorf("ID %d: expected chunk %d offset %d, got %d and %d", id, want[0], want[1], chunk, offset)
+		}
+	}
+
+	graph := NewHNSWGraph(8, 100, config.DistanceTypeEuclidean)
+	vectors := randomVectors(200, 4)
+	for _, err := range graph.InsertBatch(vectors, 4) {
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// The graph keeps its own copy of the data, which cannot be appended over
+	original := vectors[5].Data[0]
+	vectors[5].Data[0] = 1000
+	stored, _ := graph.GetVector("5")
+	if stored.Data[0] != original || cap(stored.Data) != 4 {
+		t.Errorf("Expected the graph's own copy with capacity 4, got %v with capacity %d", stored.Data, cap(stored.Data))
+	}
+
+	if err := graph.Insert(Vector{ID: "wide", Data: []float32{1, 2, 3, 4, 5}}); !errors.Is(err, ErrDifferentDims) {
+		t.Errorf("Expected ErrDifferentDims, got %v", err)
+	}
+
+	// Deleted IDs are handed out again before the arena grows, and results returned
+	// earlier keep their data
+	before, _ := graph.SearchWithOptions(vectors[7].Data, 1, SearchOptions{})
+	released, _ := graph.lookup("7")
+	if err := graph.Delete("7"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := graph.Insert(Vector{ID: "replacement", Data: []float32{-5, -5, -5, -5}}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if internal, _ := graph.lookup("replacement"); internal != released || graph.allocated.Load() != 200 {
+		t.Errorf("Expected the replacement at released ID %d of 200, got %d of %d", released, internal, graph.allocated.Load())
+	}
+	if before[0].ID != "7" || !vectorDataEqual(before[0].Data, vectors[7].Data) {
+		t.Errorf("Expected the earlier result to keep the data of vector 7, got %v", before[0])
+	}
+	if results, _ := graph.Search([]float32{-5, -5, -5, -5}, 1); len(results) != 1 || results[0].ID != "replacement" {
+		t.Errorf("Expected the replacement to be found, got %v", results)
+	}
+	if err := graph.Delete("replacement"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := graph.Insert(vectors[7]); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// Searches reuse pooled visited sets, which come back cleared, so repeating a
+	// search finds the same results
+	first, err := graph.SearchWithOptions(vectors[7].Data, 5, SearchOptions{Ef: 50})
+	if err != nil || len(first) != 5 {
+		t.Fatalf("Expected 5 results, got %v (err %v)", first, err)
+	}
+	for i := 0; i < 3; i++ {
+		results, _ := graph.SearchWithOptions(vectors[7].Data, 5, SearchOptions{Ef: 50})
+		for j := range results {
+			if results[j].ID != first[j].ID {
+				t.Fatalf("Repeated search returned %s instead of %s at rank %d", results[j].ID, first[j].ID, j)
+			}
+		}
+	}
+	visited := graph.acquireVisited()
+	if visited.count != 0 || len(visited.touched) != 0 {
+		t.Errorf("Expected a cleared visited set, got %d visits", visited.count)
+	}
+	if !visited.visit(130) || visited.visit(130) || !visited.contains(130) || visited.contains(131) {
+		t.Errorf("Visited set does not track IDs")
+	}
+	graph.releaseVisited(visited)
+}
+
+// BenchmarkHNSWSearch measures k-nearest searches on a prebuilt graph
+func BenchmarkHNSWSearch(b *testing.B) {
+	rng := rand.New(rand.NewSource(42))
+	randomData := func(dimensions int) []float32 {
+		data := make([]float32, dimensions)
+		for j := range data {
+			data[j] = rng.Float32()
+		}
+		return data
+	}
+
+	const dimensions, numVectors = 128, 5000
+	graph := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	vectors := make([]Vector, numVectors)
+	for i := range vectors {
+		vectors[i] = Vector{ID: fmt.Sprintf("%d", i), Data: randomData(dimensions)}
+	}
+	graph.InsertBatch(vectors, 8)
+
+	queries := make([][]float32, 100)
+	for i := range queries {
+		queries[i] = randomData(dimensions)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := graph.SearchWithOptions(queries[i%len(queries)], 10, SearchOptions{Ef: 100, OmitMetadata: true}); err != nil {
+			b.Fatalf("Search failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+
+	"vector-db/config"
+)
+
+// defaultScanThreshold is used when DatabaseConfig.ScanThreshold is not set
+const defaultScanThreshold = 0.05
+
+/*
+metadataIndex is a secondary index over the values stored under one metadata key
+*/
+type metadataIndex interface {
+	// add records that the vector with the given ID holds value
+	add(id string, value interface{})
+	// remove forgets that the vector with the given ID holds value
+	remove(id string, value interface{})
+	// lookup returns the IDs matching a leaf filter on the indexed key,
+	// or false if the index cannot answer that operator
+	lookup(filter *Filter) (map[string]struct{}, bool)
+}
+
+/*
+newMetadataIndex creates an empty index of the configured type
+*/
+func newMetadataIndex(indexConfig config.IndexConfig) (metadataIndex, error) {
+	if indexConfig.Field == "" {
+		return nil, fmt.Errorf("%w: missing field", ErrInvalidIndex)
+	}
+
+	switch indexConfig.Type {
+	case config.IndexTypeHash:
+		return &hashIndex{entries: make(map[interface{}]map[string]struct{})}, nil
+	case config.IndexTypeSorted:
+		return &sortedIndex{}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q for field %s", ErrInvalidIndex, indexConfig.Type, indexConfig.Field)
+	}
+}
+
+/*
+hashIndex maps each distinct value to the set of vector IDs holding it
+*/
+type hashIndex struct {
+	entries map[interface{}]map[string]struct{}
+}
+
+/*
+hashKey normalizes a metadata value into a comparable map key.
+Numbers of any Go type collapse to float64 so they match the filter semantics.
+*/
+func hashKey(value interface{}) (interface{}, bool) {
+	if f, ok := toFloat64(value); ok {
+		return f, true
+	}
+	switch v := value.(type) {
+	case string, bool:
+		return v, true
+	default:
+		// Maps, slices and other composite values are not indexed
+		return nil, false
+	}
+}
+
+func (h *hashIndex) add(id string, value interface{}) {
+	key, ok := hashKey(value)
+	if !ok {
+		return
+	}
+	ids, exists := h.entries[key]
+	if !exists {
+		ids = make(map[string]struct{})
+		h.entries[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func (h *hashIndex) remove(id string, value interface{}) {
+	key, ok := hashKey(value)
+	if !ok {
+		return
+	}
+	if ids, exists := h.entries[key]; exists {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(h.entries, key)
+		}
+	}
+}
+
+func (h *hashIndex) lookup(filter *Filter) (map[string]struct{}, bool) {
+	var values []interface{}
+	switch filter.Op {
+	case FilterEq:
+		values = []interface{}{filter.Value}
+	case FilterIn:
+		values = filter.Values
+	default:
+		return nil, false
+	}
+
+	result := make(map[string]struct{})
+	for _, value := range values {
+		key, ok := hashKey(value)
+		if !ok {
+			return nil, false
+		}
+		for id := range h.entries[key] {
+			result[id] = struct{}{}
+		}
+	}
+	return result, true
+}
+
+/*
+sortedEntry is a single value/ID pair in a sorted index
+*/
+type sortedEntry struct {
+	number float64
+	text   string
+	id     string
+}
+
+/*
+sortedIndex keeps numeric and string values in two ordered slices so equality
+and range filters resolve with binary searches
+*/
+type sortedIndex struct {
+	numbers []sortedEntry
+	strings []sortedEntry
+}
+
+/*
+entries returns the slice holding values of the same kind as value, together with
+an entry carrying the value for binary searches
+*/
+func (s *sortedIndex) entries(value interface{}) (*[]sortedEntry, sortedEntry, bool) {
+	if f, ok := toFloat64(value); ok {
+		return &s.numbers, sortedEntry{number: f}, true
+	}
+	if text, ok := value.(string); ok {
+		return &s.strings, sortedEntry{text: text}, true
+	}
+	return nil, sortedEntry{}, false
+}
+
+/*
+sortedEntryLess orders entries by value, then by ID so removals can find their exact slot
+*/
+func sortedEntryLess(a, b sortedEntry) bool {
+	if a.number != b.number {
+		return a.number < b.number
+	}
+	if a.text != b.text {
+		return a.text < b.text
+	}
+	return a.id < b.id
+}
+
+func (s *sortedIndex) add(id string, value interface{}) {
+	list, entry, ok := s.entries(value)
+	if !ok {
+		return
+	}
+	entry.id = id
+
+	i := sort.Search(len(*list), func(i int) bool { return !sortedEntryLess((*list)[i], entry) })
+	*list = append(*list, sortedEntry{})
+	copy((*list)[i+1:], (*list)[i:])
+	(*list)[i] = entry
+}
+
+func (s *sortedIndex) remove(id string, value interface{}) {
+	list, entry, ok := s.entries(value)
+	if !ok {
+		return
+	}
+	entry.id = id
+
+	i := sort.Search(len(*list), func(i int) bool { return !sortedEntryLess((*list)[i], entry) })
+	if i < len(*list) && (*list)[i] == entry {
+		*list = append((*list)[:i], (*list)[i+1:]...)
+	}
+}
+
+func (s *sortedIndex) lookup(filter *Filter) (map[string]struct{}, bool) {
+	if filter.Op == FilterIn {
+		result := make(map[string]struct{})
+		for _, value := range filter.Values {
+			ids, ok := s.lookup(&Filter{Op: FilterEq, Key: filter.Key, Value: value})
+			if !ok {
+				return nil, false
+			}
+			for id := range ids {
+				result[id] = struct{}{}
+			}
+		}
+		return result, true
+	}
+
+	list, bound, ok := s.entries(filter.Value)
+	if !ok {
+		return nil, false
+	}
+
+	// Position of the first entry whose value is >= bound and the first whose value is > bound
+	lower := sort.Search(len(*list), func(i int) bool {
+		e := (*list)[i]
+		return e.number > bound.number || (e.number == bound.number && e.text >= bound.text)
+	})
+	upper := sort.Search(len(*list), func(i int) bool {
+		e := (*list)[i]
+		return e.number > bound.number || (e.number == bound.number && e.text > bound.text)
+	})
+
+	var from, to int
+	switch filter.Op {
+	case FilterEq:
+		from, to = lower, upper
+	case FilterGt:
+		from, to = upper, len(*list)
+	case FilterGte:
+		from, to = lower, len(*list)
+	case FilterLt:
+		from, to = 0, lower
+	case FilterLte:
+		from, to = 0, upper
+	default:
+		return nil, false
+	}
+
+	result := make(map[string]struct{}, to-from)
+	for _, e := range (*list)[from:to] {
+		result[e.id] = struct{}{}
+	}
+	return result, true
+}
+
+/*
+buildIndexes creates the secondary indexes declared in the database configuration
+*/
+func buildIndexes(dbConfig config.DatabaseConfig) (map[string]metadataIndex, error) {
+	indexes := make(map[string]metadataIndex, len(dbConfig.Indexes))
+	for _, indexConfig := range dbConfig.Indexes {
+		if _, exists := indexes[indexConfig.Field]; exists {
+			return nil, fmt.Errorf("%w: duplicate index on field %s", ErrInvalidIndex, indexConfig.Field)
+		}
+		index, err := newMetadataIndex(indexConfig)
+		if err != nil {
+			return nil, err
+		}
+		indexes[indexConfig.Field] = index
+	}
+	return indexes, nil
+}
+
+/*
+indexVector adds a vector's metadata to the secondary indexes. The caller must hold the write lock.
+*/
+func (db *Database) indexVector(vector Vector) {
+	for field, index := range db.indexes {
+		if value, exists := vector.Metadata[field]; exists && value != nil {
+			index.add(vector.ID, value)
+		}
+	}
+}
+
+/*
+unindexVector removes a vector's metadata from the secondary indexes. The caller must hold the write lock.
+*/
+func (db *Database) unindexVector(vector Vector) {
+	for field, index := range db.indexes {
+		if value, exists := vector.Metadata[field]; exists && value != nil {
+			index.remove(vector.ID, value)
+		}
+	}
+}
+
+/*
+filterCandidates resolves a filter against the secondary indexes.
+
+It returns a superset of the matching IDs, or false if the filter cannot be
+answered from the indexes. For "and" it is enough that one branch is indexed,
+since intersecting narrows the set; "or" needs every branch indexed and "not"
+is never answered from indexes. The caller must hold the read lock.
+*/
+func (db *Database) filterCandidates(filter *Filter) (map[string]struct{}, bool) {
+	switch filter.Op {
+	case FilterAnd:
+		var result map[string]struct{}
+		for _, sub := range filter.Filters {
+			ids, ok := db.filterCandidates(sub)
+			if !ok {
+				continue
+			}
+			if result == nil {
+				result = ids
+				continue
+			}
+			for id := range result {
+				if _, exists := ids[id]; !exists {
+					delete(result, id)
+				}
+			}
+		}
+		return result, result != nil
+	case FilterOr:
+		result := make(map[string]struct{})
+		for _, sub := range filter.Filters {
+			ids, ok := db.filterCandidates(sub)
+			if !ok {
+				return nil, false
+			}
+			for id := range ids {
+				result[id] = struct{}{}
+			}
+		}
+		return result, true
+	case FilterNot:
+		return nil, false
+	}
+
+	index, exists := db.indexes[filter.Key]
+	if !exists {
+		return nil, false
+	}
+	return index.lookup(filter)
+}
+
+/*
+shouldScan reports whether a filtered candidate set is small enough that an exact
+scan over it beats walking the HNSW graph
+*/
+func (db *Database) shouldScan(candidates int) bool {
+	threshold := db.Config.ScanThreshold
+	if threshold <= 0 {
+		threshold = defaultScanThreshold
+	}
+	return float64(candidates) <= threshold*float64(len(db.Vectors))
+}
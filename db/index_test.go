@@ -0,0 +1,156 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"vector-db/config"
+)
+
+func TestMetadataIndexLookup(t *testing.T) {
+	hash, _ := newMetadataIndex(config.IndexConfig{Field: "lang", Type: config.IndexTypeHash})
+	sorted, _ := newMetadataIndex(config.IndexConfig{Field: "year", Type: config.IndexTypeSorted})
+
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("%d", i)
+		hash.add(id, []string{"en", "de"}[i%2])
+		sorted.add(id, 2010+i)
+	}
+	hash.remove("0", "en")
+	sorted.remove("9", 2019)
+
+	tests := []struct {
+		index  metadataIndex
+		filter *Filter
+		expect int
+	}{
+		{hash, &Filter{Op: FilterEq, Key: "lang", Value: "en"}, 4},
+		{hash, &Filter{Op: FilterIn, Key: "lang", Values: []interface{}{"en", "de"}}, 9},
+		{sorted, &Filter{Op: FilterEq, Key: "year", Value: 2015.0}, 1},
+		{sorted, &Filter{Op: FilterGt, Key: "year", Value: 2015}, 3},
+		{sorted, &Filter{Op: FilterGte, Key: "year", Value: 2015}, 4},
+		{sorted, &Filter{Op: FilterLt, Key: "year", Value: 2012}, 2},
+		{sorted, &Filter{Op: FilterLte, Key: "year", Value: 2012}, 3},
+		{sorted, &Filter{Op: FilterIn, Key: "year", Values: []interface{}{2011, 2013, 2019}}, 2},
+	}
+
+	for _, test := range tests {
+		ids, ok := test.index.lookup(test.filter)
+		if !ok {
+			t.Errorf("Lookup of %+v was not answered by the index", test.filter)
+			continue
+		}
+		if len(ids) != test.expect {
+			t.Errorf("Lookup of %+v returned %d IDs, expected %d", test.filter, len(ids), test.expect)
+		}
+	}
+
+	// Operators an index cannot answer are reported as such
+	if _, ok := hash.lookup(&Filter{Op: FilterGt, Key: "lang", Value: "a"}); ok {
+		t.Error("Hash index should not answer range lookups")
+	}
+	if _, ok := sorted.lookup(&Filter{Op: FilterNe, Key: "year", Value: 2010}); ok {
+		t.Error("Sorted index should not answer ne lookups")
+	}
+
+	// Invalid declarations are rejected
+	if _, err := newMetadataIndex(config.IndexConfig{Field: "x", Type: "bitmap"}); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("Expected ErrInvalidIndex, got %v", err)
+	}
+}
+
+func TestIndexedFilteredSearch(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     8,
+			DistanceType:   config.DistanceTypeEuclidean,
+		},
+		Indexes: []config.IndexConfig{
+			{Field: "tenant", Type: config.IndexTypeHash},
+			{Field: "index", Type: config.IndexTypeSorted},
+		},
+	}
+
+	manager := NewManager(&config.Config{})
+	db, err := manager.CreateDatabase("test", dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	for i := 0; i < 500; i++ {
+		vector := make([]float32, 8)
+		for j := range vector {
+			vector[j] = float32(i)
+		}
+		tenant := "common"
+		if i%100 == 0 {
+			tenant = "rare"
+		}
+		metadata := map[string]interface{}{"tenant": tenant, "index": i}
+		if err := manager.AddVector("test", Vector{ID: fmt.Sprintf("%d", i), Data: vector, Metadata: metadata}); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+	}
+
+	// A highly selective indexed filter is planned as a scan
+	filter := &Filter{Op: FilterAnd, Filters: []*Filter{
+		{Op: FilterEq, Key: "tenant", Value: "rare"},
+		{Op: FilterExists, Key: "index"},
+	}}
+	candidates, ok := db.filterCandidates(filter)
+	if !ok || len(candidates) != 5 {
+		t.Fatalf("Expected 5 indexed candidates, got %d (ok=%v)", len(candidates), ok)
+	}
+	if !db.shouldScan(len(candidates)) {
+		t.Error("Expected a selective filter to be planned as a scan")
+	}
+
+	query := make([]float32, 8)
+	for j := range query {
+		query[j] = 240
+	}
+	results, err := manager.SearchWithOptions("test", query, 3, SearchOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	expected := []string{"200", "300", "100"}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if result.ID != expected[i] {
+			t.Errorf("Result %d: expected %s, got %s", i, expected[i], result.ID)
+		}
+	}
+
+	// Broad filters fall back to the graph
+	broad := &Filter{Op: FilterGte, Key: "index", Value: 100}
+	if candidates, ok := db.filterCandidates(broad); !ok || db.shouldScan(len(candidates)) {
+		t.Error("Expected a broad filter to be planned as a graph traversal")
+	}
+	results, err = manager.SearchWithOptions("test", query, 3, SearchOptions{Filter: broad})
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Expected 3 results from the graph, got %d (err %v)", len(results), err)
+	}
+
+	// Indexes follow deletes and upserts
+	if err := manager.DeleteVector("test", "200"); err != nil {
+		t.Fatalf("Failed to delete vector: %v", err)
+	}
+	if err := manager.UpsertVector("test", Vector{ID: "100", Data: make([]float32, 8), Metadata: map[string]interface{}{"tenant": "common"}}); err != nil {
+		t.Fatalf("Failed to upsert vector: %v", err)
+	}
+	candidates, _ = db.filterCandidates(&Filter{Op: FilterEq, Key: "tenant", Value: "rare"})
+	if len(candidates) != 3 {
+		t.Errorf("Expected 3 rare candidates after delete and upsert, got %d", len(candidates))
+	}
+
+	// Invalid index declarations are rejected at creation time
+	dbConfig.Indexes = append(dbConfig.Indexes, config.IndexConfig{Field: "tenant", Type: config.IndexTypeSorted})
+	if _, err := manager.CreateDatabase("invalid", dbConfig); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("Expected ErrInvalidIndex, got %v", err)
+	}
+}
@@ -1,19 +1,247 @@
 package db
 
 import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"vector-db/config"
 )
 
+// idLockStripes is the number of locks that writes to vector IDs are spread over
+const idLockStripes = 64
+
 /*
-Database represents a single vector database
+Database represents a single vector database.
+
+Writes do not hold the database lock while they update the graph, which does its
+own locking, so searches keep running during insertions. They hold writeMu shared
+and the lock stripe of every ID they touch instead, which serializes writes to the
+same ID and lets a snapshot wait for the writes in progress.
 */
 type Database struct {
-	Name    string
-	Config  config.DatabaseConfig
+	Name   string
+	Config config.DatabaseConfig
+	// IDs and metadata of the stored vectors; their data is kept by the graph only
 	Vectors map[string]Vector
 	Graph   *HNSWGraph
-	mu      sync.RWMutex
+	// guards Vectors and indexes
+	mu sync.RWMutex
+	// held shared by writes and exclusively by snapshots and by closing the log
+	writeMu sync.RWMutex
+	// set under writeMu once the database is removed from disk, so no snapshot
+	// brings it back
+	deleted bool
+	// serialize writes to the IDs hashing to the same stripe
+	idLocks [idLockStripes]sync.Mutex
+	// secondary indexes keyed by metadata field
+	indexes map[string]metadataIndex
+	// write-ahead log of changes since the last snapshot, nil when disabled
+	wal *WAL
+	// number of writes applied since the database was created or loaded
+	changes atomic.Uint64
+	// value of changes captured by the last successful snapshot
+	savedChanges atomic.Uint64
+}
+
+/*
+newDatabase creates an empty database with its graph and secondary indexes
+*/
+func newDatabase(name string, dbConfig config.DatabaseConfig) (*Database, error) {
+	switch dbConfig.Durability {
+	case config.DurabilityDefault, config.DurabilityEphemeral, config.DurabilitySnapshot, config.DurabilityWAL:
+	default:
+		return nil, fmt.Errorf("%w: unknown durability %q", ErrInvalidParameter, dbConfig.Durability)
+	}
+
+	indexes, err := buildIndexes(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := NewHNSWGraph(dbConfig.HNSW.M, dbConfig.HNSW.EfConstruction, dbConfig.HNSW.DistanceType)
+	if dbConfig.HNSW.EfSearch > 0 {
+		graph.EfSearch = dbConfig.HNSW.EfSearch
+	}
+	if err := graph.SetQuantization(dbConfig.HNSW.Quantization, dbConfig.HNSW.Dimensions); err != nil {
+		return nil, err
+	}
+
+	return &Database{
+		Name:    name,
+		Config:  dbConfig,
+		Vectors: make(map[string]Vector),
+		Graph:   graph,
+		indexes: indexes,
+	}, nil
+}
+
+/*
+PendingChanges returns the number of writes applied since the last snapshot
+*/
+func (db *Database) PendingChanges() uint64 {
+	return db.changes.Load() - db.savedChanges.Load()
+}
+
+/*
+Dirty reports whether the database has been modified since the last snapshot
+*/
+func (db *Database) Dirty() bool {
+	return db.PendingChanges() > 0
+}
+
+/*
+lockIDs locks the stripes of the given vector IDs in ascending order, so that
+overlapping batches cannot deadlock, and returns the function releasing them
+*/
+func (db *Database) lockIDs(ids ...string) func() {
+	var stripes [idLockStripes]bool
+	for _, id := range ids {
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		stripes[h.Sum32()%idLockStripes] = true
+	}
+
+	for i := range stripes {
+		if stripes[i] {
+			db.idLocks[i].Lock()
+		}
+	}
+	return func() {
+		for i := range stripes {
+			if stripes[i] {
+				db.idLocks[i].Unlock()
+			}
+		}
+	}
+}
+
+/*
+getVector looks up the ID and metadata of a stored vector, leaving its data out
+*/
+func (db *Database) getVector(vectorID string) (Vector, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	vector, exists := db.Vectors[vectorID]
+	return vector, exists
+}
+
+/*
+loadVector looks up a stored vector together with its data, which the graph reads
+from disk once it is quantized
+*/
+func (db *Database) loadVector(vectorID string) (Vector, bool, error) {
+	return db.Graph.loadVector(vectorID)
+}
+
+/*
+MarshalJSON encodes the database with the data of its vectors, which are read from
+the graph
+*/
+func (db *Database) MarshalJSON() ([]byte, error) {
+	db.mu.RLock()
+	vectors := make(map[string]Vector, len(db.Vectors))
+	for id := range db.Vectors {
+		vector, exists, err := db.loadVector(id)
+		if err != nil {
+			db.mu.RUnlock()
+			return nil, err
+		}
+		if exists {
+			vectors[id] = vector
+		}
+	}
+	db.mu.RUnlock()
+
+	return json.Marshal(struct {
+		Name    string
+		Config  config.DatabaseConfig
+		Vectors map[string]Vector
+		Graph   *HNSWGraph
+	}{db.Name, db.Config, vectors, db.Graph})
+}
+
+/*
+logWrite records a write in the write-ahead log, if the database has one.
+The caller must hold writeMu and the ID locks and have validated the write already.
+*/
+func (db *Database) logWrite(entry WALEntry) error {
+	if db.wal == nil {
+		return nil
+	}
+	return db.wal.Append(entry)
+}
+
+/*
+putVector stores a validated vector, inserting or relinking it in the graph
+and keeping the secondary indexes in sync. The caller must hold writeMu and the
+lock of the vector's ID.
+*/
+func (db *Database) putVector(vector Vector) error {
+	if err := db.Graph.Update(vector); err != nil {
+		return err
+	}
+	// Keep the graph's copy of the data rather than a second one
+	vector.Data = nil
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if existing, exists := db.Vectors[vector.ID]; exists {
+		db.unindexVector(existing)
+	}
+	db.Vectors[vector.ID] = vector
+	db.indexVector(vector)
+	db.changes.Add(1)
+	return nil
+}
+
+/*
+removeVector deletes an existing vector from the graph, the vector map and the
+secondary indexes. The caller must hold writeMu and the lock of the vector's ID.
+*/
+func (db *Database) removeVector(vectorID string) error {
+	vector, exists := db.getVector(vectorID)
+	if !exists {
+		return ErrVectorNotFound
+	}
+
+	if err := db.Graph.Delete(vectorID); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.unindexVector(vector)
+	delete(db.Vectors, vectorID)
+	db.changes.Add(1)
+	return nil
+}
+
+/*
+applyWALEntry replays a logged write without logging it again.
+
+Replay is idempotent: adds are applied as upserts and deletes of missing vectors
+are ignored, so entries already contained in the snapshot are harmless.
+*/
+func (db *Database) applyWALEntry(entry WALEntry) error {
+	switch entry.Op {
+	case WALOpAdd, WALOpUpsert:
+		if entry.Vector == nil || len(entry.Vector.Data) != db.Config.HNSW.Dimensions {
+			return nil
+		}
+		return db.putVector(*entry.Vector)
+	case WALOpDelete:
+		if err := db.removeVector(entry.ID); err != nil && err != ErrVectorNotFound {
+			return err
+		}
+	}
+	return nil
 }
 
 /*
@@ -23,6 +251,8 @@ type Manager struct {
 	databases map[string]*Database
 	mu        sync.RWMutex
 	config    *config.Config
+	// persistence layer used to initialize and remove databases on disk, nil for in-memory use
+	persistence *PersistenceManager
 }
 
 /*
@@ -35,6 +265,37 @@ func NewManager(cfg *config.Config) *Manager {
 	}
 }
 
+/*
+SetPersistence makes the manager keep newly created and deleted databases in sync with disk
+*/
+func (m *Manager) SetPersistence(p *PersistenceManager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.persistence = p
+}
+
+/*
+Close closes the write-ahead logs of all databases
+*/
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var firstErr error
+	for _, db := range m.databases {
+		db.writeMu.Lock()
+		if db.wal != nil {
+			if err := db.wal.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			db.wal = nil
+		}
+		db.writeMu.Unlock()
+	}
+	return firstErr
+}
+
 /*
 CreateDatabase creates a new vector database with the given name and configuration
 */
@@ -46,17 +307,41 @@ func (m *Manager) CreateDatabase(name string, dbConfig config.DatabaseConfig) (*
 		return nil, ErrDatabaseExists
 	}
 
-	db := &Database{
-		Name:    name,
-		Config:  dbConfig,
-		Vectors: make(map[string]Vector),
-		Graph:   NewHNSWGraph(dbConfig.HNSW.M, dbConfig.HNSW.EfConstruction, dbConfig.HNSW.DistanceType),
+	db, err := newDatabase(name, dbConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.persistence != nil && dbConfig.Durability != config.DurabilityEphemeral {
+		// Write an initial snapshot so the database survives a crash before the first
+		// periodic save, then start logging writes on top of it
+		if err := m.persistence.SaveDatabase(db); err != nil {
+			return nil, err
+		}
+		if db.wal, err = m.persistence.OpenWAL(name, dbConfig.Durability); err != nil {
+			return nil, err
+		}
 	}
 
 	m.databases[name] = db
 	return db, nil
 }
 
+/*
+AttachDatabase registers an already populated database, such as one loaded from disk
+*/
+func (m *Manager) AttachDatabase(db *Database) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.databases[db.Name]; exists {
+		return ErrDatabaseExists
+	}
+
+	m.databases[db.Name] = db
+	return nil
+}
+
 /*
 GetDatabase returns a database by name
 */
@@ -79,11 +364,37 @@ func (m *Manager) DeleteDatabase(name string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, exists := m.databases[name]; !exists {
+	db, exists := m.databases[name]
+	if !exists {
 		return ErrDatabaseNotFound
 	}
 
+	// Writes wait until the database is gone from disk. The log is closed first, as
+	// an open file cannot be removed everywhere, and reopened if the removal fails,
+	// so the database stays registered with its writes still logged.
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	logged := db.wal != nil
+	if logged {
+		db.wal.Close()
+		db.wal = nil
+	}
+	if m.persistence != nil {
+		if err := m.persistence.DeleteDatabase(name); err != nil {
+			if logged {
+				wal, walErr := m.persistence.OpenWAL(name, db.Config.Durability)
+				if walErr != nil {
+					return fmt.Errorf("%w; reopening the write-ahead log: %v", err, walErr)
+				}
+				db.wal = wal
+			}
+			return err
+		}
+	}
+
+	db.deleted = true
 	delete(m.databases, name)
+	db.Graph.Close()
 	return nil
 }
 
@@ -101,6 +412,20 @@ func (m *Manager) ListDatabases() []string {
 	return names
 }
 
+/*
+PendingChanges returns the number of writes not yet captured by a snapshot, per database
+*/
+func (m *Manager) PendingChanges() map[string]uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pending := make(map[string]uint64, len(m.databases))
+	for name, db := range m.databases {
+		pending[name] = db.PendingChanges()
+	}
+	return pending
+}
+
 /*
 AddVector adds a vector to a specific database
 */
@@ -110,16 +435,130 @@ func (m *Manager) AddVector(dbName string, vector Vector) error {
 		return err
 	}
 
+	if vector.ID == "" {
+		return ErrInvalidParameter
+	}
+	if len(vector.Data) != db.Config.HNSW.Dimensions {
+		return ErrInvalidDimensions
+	}
+
+	db.writeMu.RLock()
+	defer db.writeMu.RUnlock()
+	defer db.lockIDs(vector.ID)()
+
+	if _, exists := db.getVector(vector.ID); exists {
+		return ErrVectorExists
+	}
+
+	if err := db.logWrite(WALEntry{Op: WALOpAdd, Vector: &vector}); err != nil {
+		return err
+	}
+
+	return db.putVector(vector)
+}
+
+/*
+AddVectors adds a batch of new vectors to a specific database.
+
+Each vector is validated like in AddVector; the returned slice holds one error per
+vector, nil for the ones that were added, and the second return value reports
+errors affecting the whole batch. The valid vectors are logged with a single
+write-ahead log write and linked into the graph by parallel workers.
+*/
+func (m *Manager) AddVectors(dbName string, vectors []Vector) ([]error, error) {
+	db, err := m.GetDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(vectors))
+	for i, vector := range vectors {
+		ids[i] = vector.ID
+	}
+
+	db.writeMu.RLock()
+	defer db.writeMu.RUnlock()
+	defer db.lockIDs(ids...)()
+
+	errs := make([]error, len(vectors))
+	valid := make([]Vector, 0, len(vectors))
+	positions := make([]int, 0, len(vectors))
+	seen := make(map[string]struct{}, len(vectors))
+	db.mu.RLock()
+	for i, vector := range vectors {
+		_, exists := db.Vectors[vector.ID]
+		_, duplicate := seen[vector.ID]
+		switch {
+		case vector.ID == "":
+			errs[i] = ErrInvalidParameter
+		case len(vector.Data) != db.Config.HNSW.Dimensions:
+			errs[i] = ErrInvalidDimensions
+		case exists || duplicate:
+			errs[i] = ErrVectorExists
+		default:
+			seen[vector.ID] = struct{}{}
+			valid = append(valid, vector)
+			positions = append(positions, i)
+		}
+	}
+	db.mu.RUnlock()
+
+	if db.wal != nil && len(valid) > 0 {
+		entries := make([]WALEntry, len(valid))
+		for i := range valid {
+			entries[i] = WALEntry{Op: WALOpAdd, Vector: &valid[i]}
+		}
+		if err := db.wal.AppendBatch(entries); err != nil {
+			return nil, err
+		}
+	}
+
+	graphErrs := db.Graph.InsertBatch(valid, runtime.GOMAXPROCS(0))
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	for j, vector := range valid {
+		if graphErrs[j] != nil {
+			errs[positions[j]] = graphErrs[j]
+			continue
+		}
+		vector.Data = nil
+		db.Vectors[vector.ID] = vector
+		db.indexVector(vector)
+		db.changes.Add(1)
+	}
 
+	return errs, nil
+}
+
+/*
+UpsertVector adds a vector to a specific database or replaces the existing one with the same ID.
+
+If the stored embedding is identical only the metadata is replaced and the graph is
+left as is; otherwise the node is relinked in the graph at its new position.
+*/
+func (m *Manager) UpsertVector(dbName string, vector Vector) error {
+	db, err := m.GetDatabase(dbName)
+	if err != nil {
+		return err
+	}
+
+	if vector.ID == "" {
+		return ErrInvalidParameter
+	}
 	if len(vector.Data) != db.Config.HNSW.Dimensions {
 		return ErrInvalidDimensions
 	}
 
-	db.Vectors[vector.ID] = vector
-	db.Graph.Insert(vector)
-	return nil
+	db.writeMu.RLock()
+	defer db.writeMu.RUnlock()
+	defer db.lockIDs(vector.ID)()
+
+	if err := db.logWrite(WALEntry{Op: WALOpUpsert, Vector: &vector}); err != nil {
+		return err
+	}
+
+	return db.putVector(vector)
 }
 
 /*
@@ -131,14 +570,101 @@ func (m *Manager) GetVector(dbName, vectorID string) (Vector, error) {
 		return Vector{}, err
 	}
 
+	vector, exists, err := db.loadVector(vectorID)
+	if err != nil {
+		return Vector{}, err
+	}
+	if !exists {
+		return Vector{}, ErrVectorNotFound
+	}
+
+	return vector, nil
+}
+
+/*
+ListVectors returns up to limit vectors of a specific database in ID order, starting
+at offset, together with the total number of vectors in the database
+*/
+func (m *Manager) ListVectors(dbName string, offset, limit int) ([]Vector, int, error) {
+	db, err := m.GetDatabase(dbName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset < 0 || limit < 0 {
+		return nil, 0, ErrInvalidParameter
+	}
+
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	vector, exists := db.Vectors[vectorID]
+	ids := make([]string, 0, len(db.Vectors))
+	for id := range db.Vectors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	if offset > len(ids) {
+		offset = len(ids)
+	}
+	ids = ids[offset:]
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	vectors := make([]Vector, 0, len(ids))
+	for _, id := range ids {
+		vector, _, err := db.loadVector(id)
+		if err != nil {
+			return nil, 0, err
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, len(db.Vectors), nil
+}
+
+/*
+UpdateMetadata merges a patch into the metadata of an existing vector and returns the
+updated vector. Keys set to nil in the patch are removed; the embedding is unchanged,
+so the graph is left as is.
+*/
+func (m *Manager) UpdateMetadata(dbName, vectorID string, patch map[string]interface{}) (Vector, error) {
+	db, err := m.GetDatabase(dbName)
+	if err != nil {
+		return Vector{}, err
+	}
+
+	db.writeMu.RLock()
+	defer db.writeMu.RUnlock()
+	defer db.lockIDs(vectorID)()
+
+	vector, exists, err := db.loadVector(vectorID)
+	if err != nil {
+		return Vector{}, err
+	}
 	if !exists {
 		return Vector{}, ErrVectorNotFound
 	}
 
+	// Build a new map so results handed out earlier keep the old metadata
+	metadata := make(map[string]interface{}, len(vector.Metadata)+len(patch))
+	for key, value := range vector.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(metadata, key)
+		} else {
+			metadata[key] = value
+		}
+	}
+	vector.Metadata = metadata
+
+	if err := db.logWrite(WALEntry{Op: WALOpUpsert, Vector: &vector}); err != nil {
+		return Vector{}, err
+	}
+	if err := db.putVector(vector); err != nil {
+		return Vector{}, err
+	}
 	return vector, nil
 }
 
@@ -151,22 +677,32 @@ func (m *Manager) DeleteVector(dbName, vectorID string) error {
 		return err
 	}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.writeMu.RLock()
+	defer db.writeMu.RUnlock()
+	defer db.lockIDs(vectorID)()
 
-	if _, exists := db.Vectors[vectorID]; !exists {
+	if _, exists := db.getVector(vectorID); !exists {
 		return ErrVectorNotFound
 	}
 
-	delete(db.Vectors, vectorID)
-	// TODO: Implement vector deletion from HNSW graph
-	return nil
+	if err := db.logWrite(WALEntry{Op: WALOpDelete, ID: vectorID}); err != nil {
+		return err
+	}
+
+	return db.removeVector(vectorID)
 }
 
 /*
 Search performs a similarity search in a specific database
 */
-func (m *Manager) Search(dbName string, query []float32, k int) ([]Vector, error) {
+func (m *Manager) Search(dbName string, query []float32, k int) ([]SearchResult, error) {
+	return m.SearchWithOptions(dbName, query, k, SearchOptions{})
+}
+
+/*
+SearchWithOptions performs a similarity search in a specific database using the given options.
+*/
+func (m *Manager) SearchWithOptions(dbName string, query []float32, k int, opts SearchOptions) ([]SearchResult, error) {
 	db, err := m.GetDatabase(dbName)
 	if err != nil {
 		return nil, err
@@ -176,13 +712,159 @@ func (m *Manager) Search(dbName string, query []float32, k int) ([]Vector, error
 		return nil, ErrInvalidDimensions
 	}
 
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Filter != nil {
+		if err := opts.Filter.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return db.search(query, k, opts)
+}
+
+/*
+SearchByID finds the k nearest neighbors of a vector already stored in a specific
+database, excluding the vector itself
+*/
+func (m *Manager) SearchByID(dbName, vectorID string, k int) ([]SearchResult, error) {
+	return m.SearchByIDWithOptions(dbName, vectorID, k, SearchOptions{})
+}
+
+/*
+SearchByIDWithOptions finds the k nearest neighbors of a stored vector using the given
+options. The vector is looked up by ID and used as the query, and is left out of the results.
+*/
+func (m *Manager) SearchByIDWithOptions(dbName, vectorID string, k int, opts SearchOptions) ([]SearchResult, error) {
+	db, err := m.GetDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	vector, exists, err := db.loadVector(vectorID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrVectorNotFound
+	}
+
+	if k <= 0 {
+		return nil, ErrInvalidParameter
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Filter != nil {
+		if err := opts.Filter.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Ask for one extra result to make up for the vector finding itself
+	results, err := db.search(vector.Data, k+1, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return excludeResults(results, map[string]struct{}{vectorID: {}}, k), nil
+}
+
+/*
+SearchBatch runs many similarity searches against a specific database with the same k
+and options, spread across a pool of workers. Results are returned in query order; a
+query that fails only sets its own entry in the returned error slice. The trailing
+error is reserved for failures that affect the whole batch, such as an unknown
+database or invalid options.
+*/
+func (m *Manager) SearchBatch(dbName string, queries [][]float32, k int, opts SearchOptions) ([][]SearchResult, []error, error) {
+	db, err := m.GetDatabase(dbName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if k <= 0 {
+		return nil, nil, ErrInvalidParameter
+	}
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	if opts.Filter != nil {
+		if err := opts.Filter.Validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	results := make([][]SearchResult, len(queries))
+	errs := make([]error, len(queries))
+
+	workers := min(runtime.GOMAXPROCS(0), len(queries))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if len(queries[i]) != db.Config.HNSW.Dimensions {
+					errs[i] = ErrInvalidDimensions
+					continue
+				}
+				results[i], errs[i] = db.search(queries[i], k, opts)
+			}
+		}()
+	}
+	for i := range queries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results, errs, nil
+}
+
+/*
+search answers a single query whose dimensions and options have already been checked.
+
+When the filter can be resolved from the database's secondary indexes and selects only
+a small fraction of the vectors, the candidates are scanned exactly instead of walking
+the HNSW graph, which is both faster and exact for highly selective filters.
+*/
+func (db *Database) search(query []float32, k int, opts SearchOptions) ([]SearchResult, error) {
+	if opts.Filter != nil && len(db.indexes) > 0 {
+		db.mu.RLock()
+		candidates, ok := db.filterCandidates(opts.Filter)
+		scan := ok && db.shouldScan(len(candidates))
+		db.mu.RUnlock()
+
+		if scan {
+			return db.Graph.SearchCandidates(query, k, candidates, opts)
+		}
+	}
+
+	return db.Graph.SearchWithOptions(query, k, opts)
+}
 
-	results, err := db.Graph.Search(query, k)
+/*
+RangeSearch returns the vectors of a specific database within radius of the query,
+nearest first. A positive limit caps the number of results.
+*/
+func (m *Manager) RangeSearch(dbName string, query []float32, radius float32, limit int, opts SearchOptions) ([]SearchResult, error) {
+	db, err := m.GetDatabase(dbName)
 	if err != nil {
 		return nil, err
 	}
 
-	return results, nil
+	if len(query) != db.Config.HNSW.Dimensions {
+		return nil, ErrInvalidDimensions
+	}
+
+	if opts.Filter != nil {
+		if err := opts.Filter.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return db.Graph.RangeSearchWithOptions(query, radius, limit, opts)
 }
@@ -1,7 +1,9 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"vector-db/config"
@@ -31,6 +33,17 @@ func TestDatabaseManager(t *testing.T) {
 	if db1 == nil {
 		t.Fatal("Created database is nil")
 	}
+	if db1.Graph.EfSearch != 100 {
+		t.Errorf("Expected efSearch to default to efConstruction, got %d", db1.Graph.EfSearch)
+	}
+
+	// The configured efSearch is used by the graph
+	tuned := cfg.DefaultDatabase
+	tuned.HNSW.EfSearch = 40
+	if db, err := manager.CreateDatabase("tuned", tuned); err != nil || db.Graph.EfSearch != 40 {
+		t.Fatalf("Expected efSearch 40 from the configuration, got %v (err %v)", db, err)
+	}
+	manager.DeleteDatabase("tuned")
 
 	// Test duplicate database creation
 	_, err = manager.CreateDatabase("test1", cfg.DefaultDatabase)
@@ -135,6 +148,435 @@ func TestVectorOperations(t *testing.T) {
 			t.Errorf("Result index %d is not close to expected range [20,30]", index)
 		}
 	}
+
+	// Test filtered search
+	filter := &Filter{Op: FilterLt, Key: "index", Value: 10}
+	results, err = manager.SearchWithOptions("test", query, 5, SearchOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("Failed to search vectors with filter: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 filtered results, got %d", len(results))
+	}
+	for _, result := range results {
+		if index := result.Metadata["index"].(int); index >= 10 {
+			t.Errorf("Filtered result index %d does not satisfy index < 10", index)
+		}
+	}
+
+	_, err = manager.SearchWithOptions("test", query, 5, SearchOptions{Filter: &Filter{Op: "like"}})
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("Expected ErrInvalidFilter, got %v", err)
+	}
+
+	// Test vector deletion
+	if err := manager.DeleteVector("test", "25"); err != nil {
+		t.Fatalf("Failed to delete vector: %v", err)
+	}
+	if _, err := manager.GetVector("test", "25"); err != ErrVectorNotFound {
+		t.Errorf("Expected ErrVectorNotFound after deletion, got %v", err)
+	}
+	if err := manager.DeleteVector("test", "25"); err != ErrVectorNotFound {
+		t.Errorf("Expected ErrVectorNotFound when deleting twice, got %v", err)
+	}
+
+	// Deleted vectors must no longer be returned by search
+	results, err = manager.Search("test", query, 5)
+	if err != nil {
+		t.Fatalf("Failed to search vectors: %v", err)
+	}
+	for _, result := range results {
+		if result.ID == "25" {
+			t.Error("Search returned a deleted vector")
+		}
+	}
+}
+
+func TestUpsertVector(t *testing.T) {
+	cfg := &config.Config{
+		DefaultDatabase: config.DatabaseConfig{
+			HNSW: config.HNSWConfig{
+				M:              8,
+				EfConstruction: 100,
+				Dimensions:     4,
+				DistanceType:   config.DistanceTypeEuclidean,
+			},
+		},
+	}
+
+	manager := NewManager(cfg)
+	_, _ = manager.CreateDatabase("test", cfg.DefaultDatabase)
+
+	original := Vector{ID: "a", Data: []float32{1, 1, 1, 1}, Metadata: map[string]interface{}{"v": 1}}
+	if err := manager.AddVector("test", original); err != nil {
+		t.Fatalf("Failed to add vector: %v", err)
+	}
+	if err := manager.AddVector("test", original); err != ErrVectorExists {
+		t.Errorf("Expected ErrVectorExists when adding a duplicate, got %v", err)
+	}
+
+	// Metadata-only update
+	if err := manager.UpsertVector("test", Vector{ID: "a", Data: []float32{1, 1, 1, 1}, Metadata: map[string]interface{}{"v": 2}}); err != nil {
+		t.Fatalf("Failed to upsert vector: %v", err)
+	}
+	vector, _ := manager.GetVector("test", "a")
+	if vector.Metadata["v"] != 2 {
+		t.Errorf("Expected metadata v=2, got %v", vector.Metadata["v"])
+	}
+
+	// Embedding change is visible to search
+	if err := manager.UpsertVector("test", Vector{ID: "a", Data: []float32{9, 9, 9, 9}}); err != nil {
+		t.Fatalf("Failed to upsert vector: %v", err)
+	}
+	results, err := manager.Search("test", []float32{9, 9, 9, 9}, 1)
+	if err != nil {
+		t.Fatalf("Failed to search vectors: %v", err)
+	}
+	if len(results) != 1 || results[0].Data[0] != 9 {
+		t.Errorf("Expected updated vector, got %v", results)
+	}
+
+	// Upsert of a new ID inserts it and rejects wrong dimensions
+	if err := manager.UpsertVector("test", Vector{ID: "b", Data: []float32{0, 0, 0, 0}}); err != nil {
+		t.Fatalf("Failed to upsert new vector: %v", err)
+	}
+	if err := manager.UpsertVector("test", Vector{ID: "c", Data: []float32{0}}); err != ErrInvalidDimensions {
+		t.Errorf("Expected ErrInvalidDimensions, got %v", err)
+	}
+
+	db, _ := manager.GetDatabase("test")
+	if len(db.Vectors) != 2 || db.Graph.Len() != 2 {
+		t.Errorf("Expected database and graph to hold 2 vectors, got %d and %d", len(db.Vectors), db.Graph.Len())
+	}
+}
+
+func TestAddVectors(t *testing.T) {
+	cfg := &config.Config{
+		DefaultDatabase: config.DatabaseConfig{
+			HNSW: config.HNSWConfig{
+				M:              8,
+				EfConstruction: 100,
+				Dimensions:     2,
+				DistanceType:   config.DistanceTypeEuclidean,
+			},
+			Indexes: []config.IndexConfig{{Field: "even", Type: config.IndexTypeHash}},
+		},
+	}
+
+	manager := NewManager(cfg)
+	_, _ = manager.CreateDatabase("test", cfg.DefaultDatabase)
+	if err := manager.AddVector("test", Vector{ID: "existing", Data: []float32{-1, -1}}); err != nil {
+		t.Fatalf("Failed to add vector: %v", err)
+	}
+
+	batch := make([]Vector, 0, 104)
+	for i := 0; i < 100; i++ {
+		batch = append(batch, Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0}, Metadata: map[string]interface{}{"even": i%2 == 0}})
+	}
+	batch = append(batch,
+		Vector{ID: "existing", Data: []float32{0, 0}},
+		Vector{ID: "5", Data: []float32{0, 0}},
+		Vector{ID: "", Data: []float32{0, 0}},
+		Vector{ID: "short", Data: []float32{0}},
+	)
+
+	errs, err := manager.AddVectors("test", batch)
+	if err != nil {
+		t.Fatalf("Failed to add batch: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if errs[i] != nil {
+			t.Errorf("Vector %d was rejected: %v", i, errs[i])
+		}
+	}
+	wantErrs := []error{ErrVectorExists, ErrVectorExists, ErrInvalidParameter, ErrInvalidDimensions}
+	for i, want := range wantErrs {
+		if errs[100+i] != want {
+			t.Errorf("Item %d: expected %v, got %v", 100+i, want, errs[100+i])
+		}
+	}
+
+	db, _ := manager.GetDatabase("test")
+	if len(db.Vectors) != 101 || db.Graph.Len() != 101 || db.PendingChanges() != 101 {
+		t.Errorf("Expected 101 vectors and changes, got %d in database, %d in graph and %d changes",
+			len(db.Vectors), db.Graph.Len(), db.PendingChanges())
+	}
+
+	// Batch-inserted vectors are searchable and indexed
+	results, err := manager.SearchWithOptions("test", []float32{41, 0}, 1, SearchOptions{Filter: &Filter{Op: FilterEq, Key: "even", Value: false}})
+	if err != nil || len(results) != 1 || results[0].ID != "41" {
+		t.Errorf("Expected vector 41, got %v (err %v)", results, err)
+	}
+
+	if _, err := manager.AddVectors("missing", batch); err != ErrDatabaseNotFound {
+		t.Errorf("Expected ErrDatabaseNotFound, got %v", err)
+	}
+}
+
+func TestSearchBatch(t *testing.T) {
+	cfg := &config.Config{
+		DefaultDatabase: config.DatabaseConfig{
+			HNSW: config.HNSWConfig{
+				M:              8,
+				EfConstruction: 100,
+				Dimensions:     2,
+				DistanceType:   config.DistanceTypeEuclidean,
+			},
+		},
+	}
+
+	manager := NewManager(cfg)
+	_, _ = manager.CreateDatabase("test", cfg.DefaultDatabase)
+	batch := make([]Vector, 50)
+	for i := range batch {
+		batch[i] = Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0}, Metadata: map[string]interface{}{"even": i%2 == 0}}
+	}
+	if _, err := manager.AddVectors("test", batch); err != nil {
+		t.Fatalf("Failed to add batch: %v", err)
+	}
+
+	queries := make([][]float32, 0, 101)
+	for i := 0; i < 100; i++ {
+		queries = append(queries, []float32{float32(i % 50), 0.1})
+	}
+	queries = append(queries, []float32{1})
+
+	results, errs, err := manager.SearchBatch("test", queries, 3, SearchOptions{Ef: 50})
+	if err != nil {
+		t.Fatalf("Batch search failed: %v", err)
+	}
+	if len(results) != len(queries) || len(errs) != len(queries) {
+		t.Fatalf("Expected %d entries, got %d results and %d errors", len(queries), len(results), len(errs))
+	}
+	for i := 0; i < 100; i++ {
+		if errs[i] != nil {
+			t.Errorf("Query %d failed: %v", i, errs[i])
+			continue
+		}
+		// Results come back in query order and match a single search
+		single, _ := manager.SearchWithOptions("test", queries[i], 3, SearchOptions{Ef: 50})
+		if len(results[i]) != len(single) {
+			t.Errorf("Query %d: expected %d results, got %d", i, len(single), len(results[i]))
+			continue
+		}
+		for j := range single {
+			if results[i][j].ID != single[j].ID {
+				t.Errorf("Query %d: expected %v, got %v", i, single, results[i])
+				break
+			}
+		}
+	}
+	if errs[100] != ErrInvalidDimensions || results[100] != nil {
+		t.Errorf("Expected ErrInvalidDimensions for the short query, got %v", errs[100])
+	}
+
+	// Shared options apply to every query
+	filter := &Filter{Op: FilterEq, Key: "even", Value: true}
+	results, _, err = manager.SearchBatch("test", queries[:10], 5, SearchOptions{Filter: filter, Ef: 50})
+	if err != nil {
+		t.Fatalf("Filtered batch search failed: %v", err)
+	}
+	for i, queryResults := range results {
+		for _, result := range queryResults {
+			if result.Metadata["even"] != true {
+				t.Errorf("Query %d returned %s, which does not match the filter", i, result.ID)
+			}
+		}
+	}
+
+	if results, _, err := manager.SearchBatch("test", nil, 3, SearchOptions{}); err != nil || len(results) != 0 {
+		t.Errorf("Expected an empty batch to succeed, got %v (err %v)", results, err)
+	}
+	if _, _, err := manager.SearchBatch("missing", queries, 3, SearchOptions{}); err != ErrDatabaseNotFound {
+		t.Errorf("Expected ErrDatabaseNotFound, got %v", err)
+	}
+	if _, _, err := manager.SearchBatch("test", queries, 0, SearchOptions{}); err != ErrInvalidParameter {
+		t.Errorf("Expected ErrInvalidParameter for k 0, got %v", err)
+	}
+	if _, _, err := manager.SearchBatch("test", queries, 3, SearchOptions{Ef: -1}); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for negative ef, got %v", err)
+	}
+}
+
+func TestSearchByID(t *testing.T) {
+	cfg := &config.Config{
+		DefaultDatabase: config.DatabaseConfig{
+			HNSW: config.HNSWConfig{
+				M:              8,
+				EfConstruction: 100,
+				Dimensions:     2,
+				DistanceType:   config.DistanceTypeEuclidean,
+			},
+		},
+	}
+
+	manager := NewManager(cfg)
+	_, _ = manager.CreateDatabase("test", cfg.DefaultDatabase)
+	batch := make([]Vector, 20)
+	for i := range batch {
+		batch[i] = Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0}, Metadata: map[string]interface{}{"even": i%2 == 0}}
+	}
+	if _, err := manager.AddVectors("test", batch); err != nil {
+		t.Fatalf("Failed to add batch: %v", err)
+	}
+
+	results, err := manager.SearchByIDWithOptions("test", "10", 2, SearchOptions{Ef: 50})
+	if err != nil {
+		t.Fatalf("Search by ID failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v", results)
+	}
+	for _, result := range results {
+		if result.ID == "10" {
+			t.Errorf("Search by ID returned the vector itself")
+		}
+		if result.ID != "9" && result.ID != "11" {
+			t.Errorf("Expected the neighbors 9 and 11, got %v", results)
+		}
+	}
+
+	// Options apply to the neighbors, including filters the vector itself does not match
+	results, err = manager.SearchByIDWithOptions("test", "10", 3, SearchOptions{Ef: 50, Filter: &Filter{Op: FilterEq, Key: "even", Value: false}})
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Expected 3 filtered results, got %v (err %v)", results, err)
+	}
+	for _, result := range results {
+		if result.Metadata["even"] != false {
+			t.Errorf("Result %s does not match the filter", result.ID)
+		}
+	}
+
+	// A database with a single vector has no neighbors to return
+	_, _ = manager.CreateDatabase("single", cfg.DefaultDatabase)
+	_ = manager.AddVector("single", Vector{ID: "only", Data: []float32{1, 1}})
+	if results, err := manager.SearchByID("single", "only", 5); err != nil || len(results) != 0 {
+		t.Errorf("Expected no neighbors, got %v (err %v)", results, err)
+	}
+
+	if _, err := manager.SearchByID("test", "missing", 5); err != ErrVectorNotFound {
+		t.Errorf("Expected ErrVectorNotFound, got %v", err)
+	}
+	if _, err := manager.SearchByID("missing", "10", 5); err != ErrDatabaseNotFound {
+		t.Errorf("Expected ErrDatabaseNotFound, got %v", err)
+	}
+	if _, err := manager.SearchByID("test", "10", 0); err != ErrInvalidParameter {
+		t.Errorf("Expected ErrInvalidParameter for k 0, got %v", err)
+	}
+}
+
+func TestListVectorsAndUpdateMetadata(t *testing.T) {
+	cfg := &config.Config{
+		DefaultDatabase: config.DatabaseConfig{
+			HNSW: config.HNSWConfig{
+				M:              8,
+				EfConstruction: 100,
+				Dimensions:     2,
+				DistanceType:   config.DistanceTypeEuclidean,
+			},
+			Indexes: []config.IndexConfig{{Field: "color", Type: config.IndexTypeHash}},
+		},
+	}
+
+	manager := NewManager(cfg)
+	_, _ = manager.CreateDatabase("test", cfg.DefaultDatabase)
+	for i := 0; i < 25; i++ {
+		metadata := map[string]interface{}{"color": "red", "size": i}
+		if err := manager.AddVector("test", Vector{ID: fmt.Sprintf("v%02d", i), Data: []float32{float32(i), 0}, Metadata: metadata}); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+	}
+
+	// Pages come back in ID order
+	vectors, total, err := manager.ListVectors("test", 20, 10)
+	if err != nil {
+		t.Fatalf("Failed to list vectors: %v", err)
+	}
+	if total != 25 || len(vectors) != 5 || vectors[0].ID != "v20" || vectors[4].ID != "v24" {
+		t.Errorf("Expected v20..v24 of 25, got %d vectors of %d", len(vectors), total)
+	}
+	if vectors, _, _ := manager.ListVectors("test", 100, 10); len(vectors) != 0 {
+		t.Errorf("Expected an empty page past the end, got %d vectors", len(vectors))
+	}
+	if _, _, err := manager.ListVectors("test", -1, 10); err != ErrInvalidParameter {
+		t.Errorf("Expected ErrInvalidParameter for a negative offset, got %v", err)
+	}
+
+	// Patching merges keys, removes nil ones and keeps the indexes in sync
+	vector, err := manager.UpdateMetadata("test", "v03", map[string]interface{}{"color": "blue", "size": nil, "new": true})
+	if err != nil {
+		t.Fatalf("Failed to update metadata: %v", err)
+	}
+	want := map[string]interface{}{"color": "blue", "new": true}
+	if !reflect.DeepEqual(vector.Metadata, want) {
+		t.Errorf("Expected metadata %v, got %v", want, vector.Metadata)
+	}
+	stored, _ := manager.GetVector("test", "v03")
+	if !reflect.DeepEqual(stored.Metadata, want) || stored.Data[0] != 3 {
+		t.Errorf("Expected stored vector to be patched, got %v", stored)
+	}
+
+	results, err := manager.SearchWithOptions("test", []float32{0, 0}, 5, SearchOptions{Filter: &Filter{Op: FilterEq, Key: "color", Value: "blue"}})
+	if err != nil || len(results) != 1 || results[0].ID != "v03" {
+		t.Errorf("Expected v03 to be the only blue vector, got %v (err %v)", results, err)
+	}
+
+	if _, err := manager.UpdateMetadata("test", "missing", nil); err != ErrVectorNotFound {
+		t.Errorf("Expected ErrVectorNotFound, got %v", err)
+	}
+}
+
+func TestCosineDatabaseKeepsOriginalData(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     3,
+			DistanceType:   config.DistanceTypeCosine,
+		},
+	}
+
+	manager := NewManager(&config.Config{})
+	original, _ := manager.CreateDatabase("test", dbConfig)
+	vectors := map[string][]float32{"a": {3, 4, 0}, "b": {0, 2, 2}, "c": {-1, 0, 5}}
+	for id, data := range vectors {
+		if err := manager.AddVector("test", Vector{ID: id, Data: append([]float32(nil), data...)}); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+	}
+
+	// Reads return the components as they were written, not their normalized form
+	check := func(source string, vector Vector) {
+		if !vectorDataEqual(vector.Data, vectors[vector.ID]) {
+			t.Errorf("%s returned %v for vector %s, expected %v", source, vector.Data, vector.ID, vectors[vector.ID])
+		}
+	}
+	stored, _ := manager.GetVector("test", "a")
+	check("GetVector", stored)
+	listed, _, _ := manager.ListVectors("test", 0, 10)
+	for _, vector := range listed {
+		check("ListVectors", vector)
+	}
+	results, err := manager.Search("test", []float32{6, 8, 0}, 3)
+	if err != nil || len(results) != 3 || results[0].ID != "a" || results[0].Distance > 1e-6 {
+		t.Fatalf("Expected vector a at distance 0 first, got %v (err %v)", results, err)
+	}
+	for _, result := range results {
+		check("Search", Vector{ID: result.ID, Data: result.Data})
+	}
+
+	persistence := NewPersistenceManager(t.TempDir())
+	if err := persistence.SaveDatabase(original); err != nil {
+		t.Fatalf("Failed to save database: %v", err)
+	}
+	loaded, err := persistence.LoadDatabase("test")
+	if err != nil {
+		t.Fatalf("Failed to load database: %v", err)
+	}
+	for id := range vectors {
+		vector, _ := loaded.Graph.GetVector(id)
+		check("Loaded snapshot", vector)
+	}
 }
 
 func TestConcurrentDatabaseOperations(t *testing.T) {
@@ -2,10 +2,17 @@ package db
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"vector-db/config"
+
+	log "github.com/sirupsen/logrus"
 )
 
 /*
@@ -14,6 +21,12 @@ PersistenceManager handles saving and loading databases
 type PersistenceManager struct {
 	basePath string
 	mu       sync.RWMutex
+	// whether databases without a durability override log their writes
+	walEnabled bool
+	// write-ahead log settings for databases that log their writes
+	walOptions WALOptions
+	// number of snapshots kept per database
+	snapshotRetention int
 }
 
 /*
@@ -21,93 +34,633 @@ NewPersistenceManager creates a new persistence manager
 */
 func NewPersistenceManager(basePath string) *PersistenceManager {
 	return &PersistenceManager{
-		basePath: basePath,
+		basePath:          basePath,
+		walOptions:        WALOptions{SyncPolicy: config.WALSyncInterval},
+		snapshotRetention: defaultSnapshotRetention,
+	}
+}
+
+/*
+SetSnapshotRetention sets how many snapshots are kept per database
+*/
+func (p *PersistenceManager) SetSnapshotRetention(keep int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if keep < 1 {
+		keep = 1
+	}
+	p.snapshotRetention = keep
+}
+
+/*
+EnableWAL makes databases created or loaded through this manager log their writes,
+unless their configuration asks for snapshots only
+*/
+func (p *PersistenceManager) EnableWAL(options WALOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.walEnabled = true
+	p.walOptions = options
+}
+
+/*
+SetWALOptions sets the log settings used by databases that ask for a write-ahead log
+while logging is not enabled for all of them
+*/
+func (p *PersistenceManager) SetWALOptions(options WALOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.walOptions = options
+}
+
+/*
+OpenWAL opens the write-ahead log of a database, returning nil if the database
+does not log its writes
+*/
+func (p *PersistenceManager) OpenWAL(name string, durability config.Durability) (*WAL, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.openWAL(name, durability)
+}
+
+func (p *PersistenceManager) openWAL(name string, durability config.Durability) (*WAL, error) {
+	if !p.logsWrites(durability) {
+		return nil, nil
+	}
+	return OpenWAL(p.walPath(name), p.walOptions)
+}
+
+/*
+logsWrites reports whether databases with the given durability keep a write-ahead log
+*/
+func (p *PersistenceManager) logsWrites(durability config.Durability) bool {
+	switch durability {
+	case config.DurabilityWAL:
+		return true
+	case config.DurabilityDefault:
+		return p.walEnabled
+	default:
+		return false
+	}
+}
+
+/*
+walPath returns the location of a database's write-ahead log
+*/
+func (p *PersistenceManager) walPath(name string) string {
+	return filepath.Join(p.basePath, name, "wal.log")
+}
+
+/*
+walSegmentPath returns the location of the log segment holding the writes folded
+into the snapshot with the given sequence number
+*/
+func (p *PersistenceManager) walSegmentPath(name string, sequence uint64) string {
+	return filepath.Join(p.basePath, name, walSegmentPrefix+snapshotName(sequence)+".log")
+}
+
+/*
+listWALSegments returns the sequence numbers of the log segments of a database, oldest first
+*/
+func (p *PersistenceManager) listWALSegments(name string) ([]uint64, error) {
+	entries, err := os.ReadDir(filepath.Join(p.basePath, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sequences []uint64
+	for _, entry := range entries {
+		number, ok := strings.CutPrefix(entry.Name(), walSegmentPrefix)
+		if !ok {
+			continue
+		}
+		number, ok = strings.CutSuffix(number, ".log")
+		if !ok {
+			continue
+		}
+		if sequence, err := strconv.ParseUint(number, 10, 64); err == nil {
+			sequences = append(sequences, sequence)
+		}
+	}
+
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	return sequences, nil
+}
+
+/*
+pruneWALSegments removes the log segments no retained snapshot needs: those folded
+into the oldest snapshot or an earlier one
+*/
+func (p *PersistenceManager) pruneWALSegments(name string) error {
+	snapshots, err := listSnapshots(filepath.Join(p.basePath, name, snapshotsDir))
+	if err != nil || len(snapshots) == 0 {
+		return err
+	}
+	segments, err := p.listWALSegments(name)
+	if err != nil {
+		return err
 	}
+
+	for _, sequence := range segments {
+		if sequence > snapshots[0] {
+			break
+		}
+		if err := os.Remove(p.walSegmentPath(name, sequence)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+graphFile is the on-disk representation of an HNSW graph.
+
+Vector data is not repeated here; it is restored from the snapshot's vector file when the graph is loaded.
+The codes of a quantized graph are not saved either, only the trained codec that re-encodes the vectors.
+*/
+type graphFile struct {
+	MaxLayer   int                   `json:"max_layer"`
+	EntryPoint string                `json:"entry_point"`
+	Layers     []map[string][]string `json:"layers"`
+	Levels     map[string]int        `json:"levels"`
+	Quantizer  *quantizerFile        `json:"quantizer,omitempty"`
 }
 
 /*
-SaveDatabase saves a database to disk
+SaveDatabase writes a new snapshot of a database to disk.
+
+The snapshot is assembled in a temporary directory, every file is synced and
+checksummed, and a manifest listing the checksums is written last. The directory
+is then renamed into place, so a crash at any point leaves either the complete
+new snapshot or none of it. Older snapshots beyond the retention count are pruned.
+
+Once the snapshot is published the write-ahead log is rotated: its entries, which are
+now part of the snapshot, move to a segment numbered after the snapshot, and logging
+starts over in an empty file. Segments are kept as long as an older snapshot is
+retained, so falling back to that snapshot loses no write. Writes are held off
+throughout, so none can slip in between the snapshot and the rotation. Ephemeral
+databases are skipped, and a database deleted in the meantime is not written back.
+
+The database's writeMu is taken before the persistence lock, in the same order as
+Manager.DeleteDatabase takes them.
 */
 func (p *PersistenceManager) SaveDatabase(db *Database) error {
+	if db.Config.Durability == config.DurabilityEphemeral {
+		// Ephemeral databases never touch disk
+		return nil
+	}
+
+	// Writes in progress have finished and new ones wait, so no change can happen
+	// while the snapshot is taken; searches keep running
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if db.deleted {
+		return ErrDatabaseNotFound
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Create database directory if it doesn't exist
 	dbPath := filepath.Join(p.basePath, db.Name)
-	if err := os.MkdirAll(dbPath, 0755); err != nil {
+	root := filepath.Join(dbPath, snapshotsDir)
+
+	writer, err := newSnapshotWriter(root)
+	if err != nil {
 		return err
 	}
 
-	// Save database configuration
-	configPath := filepath.Join(dbPath, "config.json")
-	configFile, err := os.Create(configPath)
-	if err != nil {
+	changes := db.changes.Load()
+
+	if err := writeSnapshot(writer, db); err != nil {
+		writer.abort()
+		return err
+	}
+	if err := writer.commit(); err != nil {
+		writer.abort()
 		return err
 	}
-	defer configFile.Close()
+	db.savedChanges.Store(changes)
 
-	if err := json.NewEncoder(configFile).Encode(db.Config); err != nil {
+	if db.wal != nil {
+		if err := db.wal.Rotate(p.walSegmentPath(db.Name, writer.manifest.Sequence)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(p.walPath(db.Name)); err != nil && !os.IsNotExist(err) {
+		// A log left behind while logging was disabled is superseded by this snapshot
 		return err
 	}
 
-	// Save vectors
-	vectorsPath := filepath.Join(dbPath, "vectors.json")
-	vectorsFile, err := os.Create(vectorsPath)
-	if err != nil {
+	// Files of the flat layout used before snapshots were versioned are superseded too
+	for _, name := range []string{"config.json", "vectors.json", "graph.json"} {
+		if err := os.Remove(filepath.Join(dbPath, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := pruneSnapshots(root, p.snapshotRetention); err != nil {
 		return err
 	}
-	defer vectorsFile.Close()
+	return p.pruneWALSegments(db.Name)
+}
 
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+/*
+writeSnapshot writes the configuration, vectors and graph of a database into a
+snapshot. The caller must hold the database's writeMu.
+*/
+func writeSnapshot(writer *snapshotWriter, db *Database) error {
+	err := writer.writeFile("config.json", func(out io.Writer) error {
+		return json.NewEncoder(out).Encode(db.Config)
+	})
+	if err != nil {
+		return err
+	}
 
-	if err := json.NewEncoder(vectorsFile).Encode(db.Vectors); err != nil {
+	err = writer.writeFile("vectors.bin", func(out io.Writer) error {
+		// The map holds no data, which is read from the graph one vector at a time
+		return writeVectorFile(out, db.Config.HNSW.Dimensions, db.Vectors, func(vector Vector) ([]float32, error) {
+			stored, _, err := db.Graph.loadVector(vector.ID)
+			return stored.Data, err
+		})
+	})
+	if err != nil {
 		return err
 	}
 
-	return nil
+	graph := db.Graph.export()
+	return writer.writeFile("graph.json", func(out io.Writer) error {
+		return json.NewEncoder(out).Encode(graph)
+	})
 }
 
 /*
-LoadDatabase loads a database from disk
+LoadDatabase loads a database from disk.
+
+Snapshots are tried newest first. One whose manifest is missing or whose files fail
+checksum verification is skipped in favour of the previous one; the log segments of
+the skipped snapshots and then the write-ahead log are replayed on top of whichever
+snapshot was loaded. A database that logs its writes fails to load rather than fall
+back to a snapshot whose later writes are no longer all logged. Databases saved
+before snapshots were introduced are read from the flat layout.
 */
 func (p *PersistenceManager) LoadDatabase(name string) (*Database, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
 	dbPath := filepath.Join(p.basePath, name)
+	root := filepath.Join(dbPath, snapshotsDir)
 
-	// Load database configuration
-	configPath := filepath.Join(dbPath, "config.json")
-	configFile, err := os.Open(configPath)
+	sequences, err := listSnapshots(root)
 	if err != nil {
 		return nil, err
 	}
-	defer configFile.Close()
 
-	var dbConfig config.DatabaseConfig
-	if err := json.NewDecoder(configFile).Decode(&dbConfig); err != nil {
+	var db *Database
+	if len(sequences) == 0 {
+		if db, err = loadSnapshot(name, &snapshotReader{dir: dbPath}); err != nil {
+			return nil, err
+		}
+	}
+
+	var firstErr error
+	loaded := len(sequences)
+	for loaded > 0 && db == nil {
+		loaded--
+		dir := filepath.Join(root, snapshotName(sequences[loaded]))
+		reader, err := openSnapshot(dir)
+		if err == nil {
+			db, err = loadSnapshot(name, reader)
+		}
+		if err != nil {
+			log.Warnf("Skipping snapshot %s of database %s: %v", snapshotName(sequences[loaded]), name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if db == nil {
+		return nil, firstErr
+	}
+
+	// Replay the writes folded into the snapshots that were skipped, then those made
+	// after the newest snapshot
+	skipped := sequences[min(loaded+1, len(sequences)):]
+	if err := p.replaySegments(db, skipped); err != nil {
 		return nil, err
 	}
+	if err := ReplayWAL(p.walPath(name), db.applyWALEntry); err != nil {
+		return nil, err
+	}
+
+	if db.wal, err = p.openWAL(name, db.Config.Durability); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+/*
+replaySegments replays the log segments of skipped snapshots onto a database loaded
+from an older one. A missing segment means writes were lost, which is an error for a
+database that logs its writes; one that only keeps snapshots falls back knowingly.
+*/
+func (p *PersistenceManager) replaySegments(db *Database, skipped []uint64) error {
+	logged := p.logsWrites(db.Config.Durability)
+	for _, sequence := range skipped {
+		path := p.walSegmentPath(db.Name, sequence)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if logged {
+				return fmt.Errorf("%w: snapshot %s of database %s is unreadable and the writes it holds are no longer logged",
+					ErrSnapshotCorrupted, snapshotName(sequence), db.Name)
+			}
+			log.Warnf("Database %s falls back to an older snapshot, losing the writes held by snapshot %s",
+				db.Name, snapshotName(sequence))
+			continue
+		}
+		if err := ReplayWAL(path, db.applyWALEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+loadSnapshot builds a database from the files of one snapshot.
 
-	// Load vectors
-	vectorsPath := filepath.Join(dbPath, "vectors.json")
-	vectorsFile, err := os.Open(vectorsPath)
+The graph is restored from graph.json when present and consistent with the stored
+vectors; otherwise it is rebuilt by inserting every vector again.
+*/
+func loadSnapshot(name string, reader *snapshotReader) (*Database, error) {
+	// Load database configuration
+	var dbConfig config.DatabaseConfig
+	err := reader.readFile("config.json", func(in io.Reader) error {
+		return json.NewDecoder(in).Decode(&dbConfig)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer vectorsFile.Close()
 
-	var vectors map[string]Vector
-	if err := json.NewDecoder(vectorsFile).Decode(&vectors); err != nil {
+	db, err := newDatabase(name, dbConfig)
+	if err != nil {
 		return nil, err
 	}
+	if err := loadSnapshotVectors(db, reader); err != nil {
+		db.Graph.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+/*
+loadSnapshotVectors reads the vectors and graph of a snapshot into a new database.
+
+The graph structure is read first, so each vector can be placed in the graph at its
+saved level as soon as it is decoded: the graph keeps its data, the database its ID
+and metadata, and no other copy of the data set is held. The links are restored
+once every vector is in, or rebuilt if the saved graph is missing or stale.
+*/
+func loadSnapshotVectors(db *Database, reader *snapshotReader) error {
+	var graph graphFile
+	hasGraph := reader.has("graph.json")
+	if hasGraph {
+		err := reader.readFile("graph.json", func(in io.Reader) error {
+			return json.NewDecoder(in).Decode(&graph)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	add := func(vector Vector) error {
+		level, exists := graph.Levels[vector.ID]
+		if !exists {
+			level = db.Graph.randomLevel()
+		}
+		if err := db.Graph.place(vector, level); err != nil {
+			return err
+		}
+		vector.Data = nil
+		db.Vectors[vector.ID] = vector
+		db.indexVector(vector)
+		return nil
+	}
+
+	// Snapshots written before the binary format kept the vectors as JSON
+	dimensions := db.Config.HNSW.Dimensions
+	var err error
+	if reader.has("vectors.bin") {
+		err = reader.readFile("vectors.bin", func(in io.Reader) error {
+			vectors, err := newVectorFileReader(in)
+			if err != nil {
+				return err
+			}
+			if vectors.dimensions != dimensions {
+				return fmt.Errorf("%w: %d dimensions, database has %d", ErrInvalidVectorFile, vectors.dimensions, dimensions)
+			}
+			for {
+				vector, err := vectors.next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := add(vector); err != nil {
+					return err
+				}
+			}
+		})
+	} else {
+		var vectors map[string]Vector
+		err = reader.readFile("vectors.json", func(in io.Reader) error {
+			return json.NewDecoder(in).Decode(&vectors)
+		})
+		if err == nil {
+			ids := make([]string, 0, len(vectors))
+			for id := range vectors {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				if err = add(vectors[id]); err != nil {
+					break
+				}
+				delete(vectors, id)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if hasGraph && graph.matches(db.Vectors) {
+		return db.Graph.restore(graph, dimensions)
+	}
+	db.Graph.rebuild()
+	return nil
+}
+
+/*
+matches reports whether the saved graph describes exactly the given set of vectors
+*/
+func (f graphFile) matches(vectors map[string]Vector) bool {
+	if len(f.Levels) != len(vectors) || len(f.Layers) != f.MaxLayer+1 {
+		return false
+	}
+	if len(vectors) > 0 {
+		if _, exists := vectors[f.EntryPoint]; !exists {
+			return false
+		}
+	}
+	for id := range f.Levels {
+		if _, exists := vectors[id]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+export captures the graph structure in its saved form. Insertions running at the
+same time may or may not be included, so the caller must keep writes out to get a
+graph matching a set of vectors.
+*/
+func (g *HNSWGraph) export() graphFile {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	f := graphFile{Levels: make(map[string]int, g.Len())}
+	g.entryMu.RLock()
+	f.EntryPoint, f.MaxLayer = g.EntryPoint, g.MaxLayer
+	g.entryMu.RUnlock()
+	if g.quantizer != nil {
+		f.Quantizer = g.quantizer.file()
+	}
+	f.Layers = make([]map[string][]string, f.MaxLayer+1)
+	for l := range f.Layers {
+		f.Layers[l] = make(map[string][]string)
+	}
+
+	g.forEachNode(func(_ uint32, node *hnswNode) {
+		id := node.vector.ID
+		f.Levels[id] = node.level
+
+		node.mu.RLock()
+		for l, neighbors := range node.links {
+			if neighbors != nil && l <= f.MaxLayer {
+				ids := make([]string, len(neighbors))
+				for i, neighbor := range neighbors {
+					ids[i] = g.node(neighbor).vector.ID
+				}
+				f.Layers[l][id] = ids
+			}
+		}
+		node.mu.RUnlock()
+	})
+	return f
+}
+
+/*
+place stores a vector in the graph at a given level without linking it. Loading a
+database places its vectors as they are read and links them all at once with
+restore or rebuild, so internal IDs follow the order of the vector file, which
+is the ID order, and a loaded graph is laid out deterministically.
+*/
+func (g *HNSWGraph) place(vector Vector, level int) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
 
-	return &Database{
-		Name:    name,
-		Config:  dbConfig,
-		Vectors: vectors,
-	}, nil
+	if _, _, err := g.allocate(vector, level); err != nil {
+		return err
+	}
+	g.count.Add(1)
+	return nil
+}
+
+/*
+restore links the placed vectors as described by a saved graph.
+
+A saved codec matching the graph's quantization settings re-encodes the vectors;
+without one the codec is trained afresh if there are enough vectors.
+*/
+func (g *HNSWGraph) restore(f graphFile, dimensions int) error {
+	if err := g.restoreLinks(f, dimensions); err != nil {
+		return err
+	}
+	g.trainQuantizer()
+	return nil
+}
+
+/*
+restoreLinks sets the links, entry point and codec of the graph from its saved form
+*/
+func (g *HNSWGraph) restoreLinks(f graphFile, dimensions int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for l, layer := range f.Layers {
+		for id, neighbors := range layer {
+			internal, exists := g.lookup(id)
+			if !exists {
+				continue
+			}
+			links := make([]uint32, 0, len(neighbors))
+			for _, neighbor := range neighbors {
+				if neighborID, exists := g.lookup(neighbor); exists && !containsNode(links, neighborID) {
+					links = append(links, neighborID)
+				}
+			}
+			g.setLinks(internal, g.node(internal), l, links)
+		}
+	}
+
+	g.entryMu.Lock()
+	g.MaxLayer = f.MaxLayer
+	g.EntryPoint = f.EntryPoint
+	g.entryID, _ = g.lookup(f.EntryPoint)
+	g.entryMu.Unlock()
+	if f.Quantizer != nil && f.Quantizer.Type == g.quantization.Type {
+		q, err := loadQuantizer(f.Quantizer, g.DistanceType, dimensions)
+		if err != nil {
+			return err
+		}
+		return g.setQuantizer(q)
+	}
+	return nil
+}
+
+/*
+rebuild links the placed vectors as insertions would, in internal ID order
+*/
+func (g *HNSWGraph) rebuild() {
+	g.mu.RLock()
+	allocated := g.allocated.Load()
+	for id := uint32(0); id < allocated; id++ {
+		node := g.node(id)
+		g.attach(id, node, g.prepare(node.vector.Data))
+	}
+	g.mu.RUnlock()
+
+	g.trainQuantizer()
+}
+
+/*
+readJSONFile decodes the JSON file at path into value
+*/
+func readJSONFile(path string, value interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(value)
 }
 
 /*
@@ -129,6 +682,10 @@ func (p *PersistenceManager) ListDatabases() ([]string, error) {
 	defer p.mu.RUnlock()
 
 	entries, err := os.ReadDir(p.basePath)
+	if os.IsNotExist(err) {
+		// Nothing has been saved yet
+		return []string{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,507 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"vector-db/config"
+)
+
+func TestPersistenceRoundTrip(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     16,
+			DistanceType:   config.DistanceTypeEuclidean,
+		},
+		Indexes: []config.IndexConfig{{Field: "group", Type: config.IndexTypeHash}},
+	}
+
+	manager := NewManager(&config.Config{})
+	original, err := manager.CreateDatabase("test", dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		vector := make([]float32, 16)
+		for j := range vector {
+			vector[j] = rand.Float32()
+		}
+		metadata := map[string]interface{}{"group": fmt.Sprintf("g%d", i%4)}
+		if err := manager.AddVector("test", Vector{ID: fmt.Sprintf("%d", i), Data: vector, Metadata: metadata}); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+	}
+
+	persistence := NewPersistenceManager(t.TempDir())
+	if err := persistence.SaveDatabase(original); err != nil {
+		t.Fatalf("Failed to save database: %v", err)
+	}
+
+	names, err := persistence.ListDatabases()
+	if err != nil || len(names) != 1 || names[0] != "test" {
+		t.Fatalf("Expected [test], got %v (err %v)", names, err)
+	}
+
+	loaded, err := persistence.LoadDatabase("test")
+	if err != nil {
+		t.Fatalf("Failed to load database: %v", err)
+	}
+
+	// The graph structure comes back exactly as it was saved
+	if loaded.Graph.EntryPoint != original.Graph.EntryPoint || loaded.Graph.MaxLayer != original.Graph.MaxLayer {
+		t.Errorf("Entry point/max layer mismatch: got %s/%d, want %s/%d",
+			loaded.Graph.EntryPoint, loaded.Graph.MaxLayer, original.Graph.EntryPoint, original.Graph.MaxLayer)
+	}
+	if !reflect.DeepEqual(loaded.Graph.export().Layers, original.Graph.export().Layers) {
+		t.Error("Loaded graph layers differ from the saved ones")
+	}
+	if len(loaded.Vectors) != 200 || loaded.Graph.Len() != 200 {
+		t.Errorf("Expected 200 vectors, got %d in database and %d in graph", len(loaded.Vectors), loaded.Graph.Len())
+	}
+
+	// The loaded database is fully usable once attached to a manager
+	restored := NewManager(&config.Config{})
+	if err := restored.AttachDatabase(loaded); err != nil {
+		t.Fatalf("Failed to attach database: %v", err)
+	}
+	if err := restored.AttachDatabase(loaded); err != ErrDatabaseExists {
+		t.Errorf("Expected ErrDatabaseExists, got %v", err)
+	}
+
+	stored, _ := original.Graph.GetVector("42")
+	query := stored.Data
+	want, _ := manager.Search("test", query, 5)
+	got, err := restored.Search("test", query, 5)
+	if err != nil {
+		t.Fatalf("Failed to search restored database: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d results, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Errorf("Result %d: expected %s, got %s", i, want[i].ID, got[i].ID)
+		}
+	}
+
+	// Secondary indexes are rebuilt from the loaded vectors
+	candidates, ok := loaded.filterCandidates(&Filter{Op: FilterEq, Key: "group", Value: "g1"})
+	if !ok || len(candidates) != 50 {
+		t.Errorf("Expected 50 indexed candidates, got %d (ok=%v)", len(candidates), ok)
+	}
+}
+
+func TestPersistenceLoadsLegacyLayout(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     4,
+			DistanceType:   config.DistanceTypeEuclidean,
+		},
+	}
+
+	manager := NewManager(&config.Config{})
+	original, _ := manager.CreateDatabase("test", dbConfig)
+	for i := 0; i < 20; i++ {
+		vector := []float32{float32(i), float32(i), float32(i), float32(i)}
+		if err := manager.AddVector("test", Vector{ID: fmt.Sprintf("%d", i), Data: vector}); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+	}
+
+	basePath := t.TempDir()
+	persistence := NewPersistenceManager(basePath)
+	if err := persistence.SaveDatabase(original); err != nil {
+		t.Fatalf("Failed to save database: %v", err)
+	}
+
+	// Recreate the flat JSON layout written before snapshots, which had no graph file at first
+	dbPath := filepath.Join(basePath, "test")
+	if err := os.RemoveAll(filepath.Join(dbPath, snapshotsDir)); err != nil {
+		t.Fatalf("Failed to remove snapshots: %v", err)
+	}
+	vectors := make(map[string]Vector)
+	for id := range original.Vectors {
+		vectors[id], _ = original.Graph.GetVector(id)
+	}
+	for name, value := range map[string]interface{}{"config.json": original.Config, "vectors.json": vectors} {
+		data, _ := json.Marshal(value)
+		if err := os.WriteFile(filepath.Join(dbPath, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	loaded, err := persistence.LoadDatabase("test")
+	if err != nil {
+		t.Fatalf("Failed to load legacy database without graph file: %v", err)
+	}
+	if loaded.Graph.Len() != 20 || loaded.Graph.EntryPoint == "" {
+		t.Fatalf("Expected rebuilt graph with 20 vectors, got %d", loaded.Graph.Len())
+	}
+
+	results, err := loaded.Graph.Search([]float32{7, 7, 7, 7}, 1)
+	if err != nil || len(results) != 1 || results[0].ID != "7" {
+		t.Errorf("Expected vector 7 from rebuilt graph, got %v (err %v)", results, err)
+	}
+}
+
+func TestPersistenceSnapshotFallback(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     4,
+			DistanceType:   config.DistanceTypeEuclidean,
+		},
+	}
+
+	manager := NewManager(&config.Config{})
+	original, _ := manager.CreateDatabase("test", dbConfig)
+
+	basePath := t.TempDir()
+	persistence := NewPersistenceManager(basePath)
+	persistence.SetSnapshotRetention(2)
+
+	// Save one snapshot more than the retention count, one vector apart
+	for i := 0; i < 3; i++ {
+		if err := manager.AddVector("test", Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0, 0, 0}}); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+		if err := persistence.SaveDatabase(original); err != nil {
+			t.Fatalf("Failed to save database: %v", err)
+		}
+	}
+
+	root := filepath.Join(basePath, "test", snapshotsDir)
+	sequences, err := listSnapshots(root)
+	if err != nil || len(sequences) != 2 || sequences[0] != 2 || sequences[1] != 3 {
+		t.Fatalf("Expected snapshots [2 3] after pruning, got %v (err %v)", sequences, err)
+	}
+
+	// An interrupted save leaves a temporary directory that must be ignored
+	if err := os.MkdirAll(filepath.Join(root, snapshotTmpPrefix+snapshotName(4)), 0755); err != nil {
+		t.Fatalf("Failed to create temporary snapshot: %v", err)
+	}
+
+	loaded, err := persistence.LoadDatabase("test")
+	if err != nil || len(loaded.Vectors) != 3 {
+		t.Fatalf("Expected newest snapshot with 3 vectors, got %v (err %v)", loaded, err)
+	}
+
+	// Flip a byte in the newest snapshot so its checksum no longer matches
+	vectorsPath := filepath.Join(root, snapshotName(3), "vectors.bin")
+	data, err := os.ReadFile(vectorsPath)
+	if err != nil {
+		t.Fatalf("Failed to read vectors: %v", err)
+	}
+	data[len(data)/2] ^= 0x01
+	if err := os.WriteFile(vectorsPath, data, 0644); err != nil {
+		t.Fatalf("Failed to corrupt vectors: %v", err)
+	}
+
+	loaded, err = persistence.LoadDatabase("test")
+	if err != nil {
+		t.Fatalf("Failed to fall back to previous snapshot: %v", err)
+	}
+	if len(loaded.Vectors) != 2 {
+		t.Errorf("Expected previous snapshot with 2 vectors, got %d", len(loaded.Vectors))
+	}
+
+	// With every snapshot unusable the corruption is reported
+	if err := os.Remove(filepath.Join(root, snapshotName(2), manifestFile)); err != nil {
+		t.Fatalf("Failed to remove manifest: %v", err)
+	}
+	if _, err := persistence.LoadDatabase("test"); !errors.Is(err, ErrSnapshotCorrupted) {
+		t.Errorf("Expected ErrSnapshotCorrupted, got %v", err)
+	}
+
+	// The next save gets a fresh sequence number and clears the temporary directory
+	if err := persistence.SaveDatabase(original); err != nil {
+		t.Fatalf("Failed to save database: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, snapshotTmpPrefix+snapshotName(4))); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary snapshot to be removed, got %v", err)
+	}
+	if loaded, err := persistence.LoadDatabase("test"); err != nil || len(loaded.Vectors) != 3 {
+		t.Errorf("Expected new snapshot with 3 vectors, got %v (err %v)", loaded, err)
+	}
+}
+
+func TestPersistenceSnapshotFallbackReplaysLog(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     4,
+			DistanceType:   config.DistanceTypeEuclidean,
+		},
+	}
+
+	basePath := t.TempDir()
+	persistence := NewPersistenceManager(basePath)
+	persistence.SetSnapshotRetention(2)
+	persistence.EnableWAL(WALOptions{SyncPolicy: config.WALSyncAlways})
+
+	manager := NewManager(&config.Config{})
+	manager.SetPersistence(persistence)
+	original, err := manager.CreateDatabase("test", dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	// Creating the database wrote snapshot 1; snapshots 3 and 4 are kept, each holding
+	// one more vector than the one before
+	for i := 0; i < 3; i++ {
+		if err := manager.AddVector("test", Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0, 0, 0}}); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+		if err := persistence.SaveDatabase(original); err != nil {
+			t.Fatalf("Failed to save database: %v", err)
+		}
+	}
+	if err := manager.AddVector("test", Vector{ID: "3", Data: []float32{3, 0, 0, 0}}); err != nil {
+		t.Fatalf("Failed to add vector: %v", err)
+	}
+	manager.Close()
+
+	segments, err := persistence.listWALSegments("test")
+	if err != nil || !reflect.DeepEqual(segments, []uint64{4}) {
+		t.Fatalf("Expected log segment [4] after pruning, got %v (err %v)", segments, err)
+	}
+
+	// Corrupt the newest snapshot: its writes are replayed from the segment it rotated out
+	root := filepath.Join(basePath, "test", snapshotsDir)
+	if err := os.Remove(filepath.Join(root, snapshotName(4), manifestFile)); err != nil {
+		t.Fatalf("Failed to remove manifest: %v", err)
+	}
+	loaded, err := persistence.LoadDatabase("test")
+	if err != nil {
+		t.Fatalf("Failed to fall back to previous snapshot: %v", err)
+	}
+	loaded.wal.Close()
+	if len(loaded.Vectors) != 4 {
+		t.Errorf("Expected all 4 vectors after falling back, got %d", len(loaded.Vectors))
+	}
+
+	// Without the segment the writes are lost, which is reported instead of ignored
+	if err := os.Remove(persistence.walSegmentPath("test", 4)); err != nil {
+		t.Fatalf("Failed to remove log segment: %v", err)
+	}
+	if _, err := persistence.LoadDatabase("test"); !errors.Is(err, ErrSnapshotCorrupted) {
+		t.Errorf("Expected ErrSnapshotCorrupted, got %v", err)
+	}
+}
+
+func TestPersistenceListMissingDirectory(t *testing.T) {
+	persistence := NewPersistenceManager(filepath.Join(t.TempDir(), "missing"))
+	names, err := persistence.ListDatabases()
+	if err != nil || len(names) != 0 {
+		t.Errorf("Expected no databases and no error, got %v (err %v)", names, err)
+	}
+}
+
+func TestPersistencePendingChanges(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     2,
+			DistanceType:   config.DistanceTypeEuclidean,
+		},
+	}
+
+	basePath := t.TempDir()
+	persistence := NewPersistenceManager(basePath)
+	persistence.EnableWAL(WALOptions{SyncPolicy: config.WALSyncAlways})
+
+	manager := NewManager(&config.Config{})
+	manager.SetPersistence(persistence)
+	database, err := manager.CreateDatabase("test", dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if database.Dirty() {
+		t.Error("Expected a newly created database to be clean")
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := manager.AddVector("test", Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0}}); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+	}
+	manager.DeleteVector("test", "0")
+	// Rejected writes are not changes
+	manager.AddVector("test", Vector{ID: "1", Data: []float32{1, 0}})
+
+	if pending := manager.PendingChanges()["test"]; pending != 4 {
+		t.Errorf("Expected 4 pending changes, got %d", pending)
+	}
+
+	if err := persistence.SaveDatabase(database); err != nil {
+		t.Fatalf("Failed to save database: %v", err)
+	}
+	if database.Dirty() || database.PendingChanges() != 0 {
+		t.Errorf("Expected a clean database after saving, got %d pending changes", database.PendingChanges())
+	}
+
+	// Writes replayed from the log are not in the snapshot yet
+	manager.UpsertVector("test", Vector{ID: "1", Data: []float32{1, 1}})
+	manager.Close()
+
+	loaded, err := persistence.LoadDatabase("test")
+	if err != nil {
+		t.Fatalf("Failed to load database: %v", err)
+	}
+	defer loaded.wal.Close()
+	if loaded.PendingChanges() != 1 {
+		t.Errorf("Expected 1 pending change after replay, got %d", loaded.PendingChanges())
+	}
+}
+
+func TestPersistenceSaveDuringDelete(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     2,
+			DistanceType:   config.DistanceTypeEuclidean,
+		},
+	}
+
+	basePath := t.TempDir()
+	persistence := NewPersistenceManager(basePath)
+	persistence.EnableWAL(WALOptions{SyncPolicy: config.WALSyncAlways})
+
+	manager := NewManager(&config.Config{})
+	manager.SetPersistence(persistence)
+
+	for round := 0; round < 20; round++ {
+		name := fmt.Sprintf("test%d", round)
+		database, err := manager.CreateDatabase(name, dbConfig)
+		if err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		for i := 0; i < 20; i++ {
+			if err := manager.AddVector(name, Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0}}); err != nil {
+				t.Fatalf("Failed to add vector: %v", err)
+			}
+		}
+
+		var wg sync.WaitGroup
+		var saveErr, deleteErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			saveErr = persistence.SaveDatabase(database)
+		}()
+		go func() {
+			defer wg.Done()
+			deleteErr = manager.DeleteDatabase(name)
+		}()
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Saving and deleting a database concurrently deadlocked")
+		}
+
+		if deleteErr != nil {
+			t.Fatalf("Failed to delete database: %v", deleteErr)
+		}
+		// A save that lost the race finds the database gone
+		if saveErr != nil && !errors.Is(saveErr, ErrDatabaseNotFound) {
+			t.Fatalf("Failed to save database: %v", saveErr)
+		}
+		if _, err := os.Stat(filepath.Join(basePath, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected database directory to be removed, got %v", err)
+		}
+	}
+}
+
+func TestPersistenceDurability(t *testing.T) {
+	newConfig := func(durability config.Durability) config.DatabaseConfig {
+		return config.DatabaseConfig{
+			HNSW: config.HNSWConfig{
+				M:              8,
+				EfConstruction: 100,
+				Dimensions:     2,
+				DistanceType:   config.DistanceTypeEuclidean,
+			},
+			Durability: durability,
+		}
+	}
+
+	basePath := t.TempDir()
+	persistence := NewPersistenceManager(basePath)
+	persistence.SetWALOptions(WALOptions{SyncPolicy: config.WALSyncAlways})
+
+	manager := NewManager(&config.Config{})
+	manager.SetPersistence(persistence)
+	defer manager.Close()
+
+	databases := map[string]config.Durability{
+		"default":   config.DurabilityDefault,
+		"ephemeral": config.DurabilityEphemeral,
+		"snapshot":  config.DurabilitySnapshot,
+		"wal":       config.DurabilityWAL,
+	}
+	for name, durability := range databases {
+		database, err := manager.CreateDatabase(name, newConfig(durability))
+		if err != nil {
+			t.Fatalf("Failed to create %s database: %v", name, err)
+		}
+		if err := manager.AddVector(name, Vector{ID: "a", Data: []float32{1, 2}}); err != nil {
+			t.Fatalf("Failed to add vector to %s database: %v", name, err)
+		}
+		if err := persistence.SaveDatabase(database); err != nil {
+			t.Fatalf("Failed to save %s database: %v", name, err)
+		}
+	}
+
+	// Only the database asking for a log has one, since logging is off by default
+	for name, wantWAL := range map[string]bool{"default": false, "snapshot": false, "wal": true} {
+		_, err := os.Stat(filepath.Join(basePath, name, "wal.log"))
+		if hasWAL := err == nil; hasWAL != wantWAL {
+			t.Errorf("Database %s: expected write-ahead log %v, got %v", name, wantWAL, hasWAL)
+		}
+	}
+
+	// The ephemeral database never touches disk
+	if _, err := os.Stat(filepath.Join(basePath, "ephemeral")); !os.IsNotExist(err) {
+		t.Errorf("Expected no files for the ephemeral database, got %v", err)
+	}
+	names, _ := persistence.ListDatabases()
+	if len(names) != 3 {
+		t.Errorf("Expected 3 databases on disk, got %v", names)
+	}
+
+	// Snapshot-only databases do not log even when logging is on for everyone else
+	persistence.EnableWAL(WALOptions{SyncPolicy: config.WALSyncAlways})
+	loaded, err := persistence.LoadDatabase("snapshot")
+	if err != nil {
+		t.Fatalf("Failed to load snapshot database: %v", err)
+	}
+	if loaded.wal != nil {
+		t.Error("Expected no write-ahead log for a snapshot-only database")
+	}
+
+	if _, err := manager.CreateDatabase("invalid", newConfig("sometimes")); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for unknown durability, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"vector-db/config"
+)
+
+const (
+	// productQuantizerCentroids is the size of every subspace codebook, so that the
+	// code of a subspace fits a byte
+	productQuantizerCentroids = 256
+	// productQuantizerIterations bounds the k-means rounds run per subspace
+	productQuantizerIterations = 20
+)
+
+/*
+productQuantizer splits vectors into equally wide subspaces and encodes each subspace
+as the index of its nearest centroid in a codebook learned by k-means.
+
+Distances are asymmetric: the query stays in full precision, and its distance to
+every centroid of every subspace is computed once per query into a table, so the
+distance to a code is a sum of table lookups.
+*/
+type productQuantizer struct {
+	distanceType config.DistanceType
+	// codebooks[j][c] is centroid c of subspace j
+	codebooks [][][]float32
+	// number of dimensions of every subspace
+	width int
+	// squared norm of every centroid, for cosine distances
+	norms [][]float32
+}
+
+/*
+defaultSubspaces picks the most subspaces of at least 4 dimensions that divide the dimensions
+*/
+func defaultSubspaces(dimensions int) int {
+	for subspaces := dimensions / 4; subspaces > 1; subspaces-- {
+		if dimensions%subspaces == 0 {
+			return subspaces
+		}
+	}
+	return 1
+}
+
+/*
+newProductQuantizer trains the codebook of every subspace on the samples. Training is
+seeded, so the same samples always give the same codec.
+*/
+func newProductQuantizer(distanceType config.DistanceType, samples [][]float32, subspaces int) *productQuantizer {
+	dimensions := len(samples[0])
+	if subspaces <= 0 {
+		subspaces = defaultSubspaces(dimensions)
+	}
+	width := dimensions / subspaces
+	centroids := min(productQuantizerCentroids, len(samples))
+
+	rng := rand.New(rand.NewSource(1))
+	codebooks := make([][][]float32, subspaces)
+	points := make([][]float32, len(samples))
+	for j := range codebooks {
+		for i, sample := range samples {
+			points[i] = sample[j*width : (j+1)*width]
+		}
+		codebooks[j] = kMeans(points, centroids, productQuantizerIterations, rng)
+	}
+
+	return newProductQuantizerFromCodebooks(distanceType, codebooks)
+}
+
+/*
+loadProductQuantizer rebuilds a product codec for vectors of the given dimensions from
+its saved codebooks
+*/
+func loadProductQuantizer(codebooks [][][]float32, distanceType config.DistanceType, dimensions int) (*productQuantizer, error) {
+	if len(codebooks) == 0 || dimensions%len(codebooks) != 0 {
+		return nil, fmt.Errorf("%w: %d codebooks for %d dimensions", ErrInvalidParameter, len(codebooks), dimensions)
+	}
+	width := dimensions / len(codebooks)
+	for _, codebook := range codebooks {
+		if len(codebook) == 0 || len(codebook) > productQuantizerCentroids {
+			return nil, fmt.Errorf("%w: codebook with %d centroids", ErrInvalidParameter, len(codebook))
+		}
+		for _, centroid := range codebook {
+			if len(centroid) != width {
+				return nil, fmt.Errorf("%w: centroid of %d dimensions in subspaces of %d", ErrInvalidParameter, len(centroid), width)
+			}
+		}
+	}
+	return newProductQuantizerFromCodebooks(distanceType, codebooks), nil
+}
+
+/*
+newProductQuantizerFromCodebooks builds a product codec around trained codebooks
+*/
+func newProductQuantizerFromCodebooks(distanceType config.DistanceType, codebooks [][][]float32) *productQuantizer {
+	q := &productQuantizer{
+		distanceType: distanceType,
+		codebooks:    codebooks,
+		width:        len(codebooks[0][0]),
+		norms:        make([][]float32, len(codebooks)),
+	}
+	for j, codebook := range codebooks {
+		q.norms[j] = make([]float32, len(codebook))
+		for c, centroid := range codebook {
+			for _, value := range centroid {
+				q.norms[j][c] += value * value
+			}
+		}
+	}
+	return q
+}
+
+/*
+kMeans clusters points into k groups with Lloyd's algorithm, starting from k distinct
+random points, and returns the centroids. A centroid left without points keeps its
+previous position.
+*/
+func kMeans(points [][]float32, k, iterations int, rng *rand.Rand) [][]float32 {
+	width := len(points[0])
+	centroids := make([][]float32, k)
+	for c, i := range rng.Perm(len(points))[:k] {
+		centroids[c] = append([]float32(nil), points[i]...)
+	}
+
+	assignments := make([]int, len(points))
+	sums := make([][]float32, k)
+	for c := range sums {
+		sums[c] = make([]float32, width)
+	}
+	counts := make([]int, k)
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		changed := false
+		for i, point := range points {
+			nearest := nearestCentroid(centroids, point)
+			if iteration == 0 || nearest != assignments[i] {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		for c := range sums {
+			clear(sums[c])
+			counts[c] = 0
+		}
+		for i, point := range points {
+			c := assignments[i]
+			counts[c]++
+			for d, value := range point {
+				sums[c][d] += value
+			}
+		}
+		for c, centroid := range centroids {
+			if counts[c] == 0 {
+				continue
+			}
+			for d := range centroid {
+				centroid[d] = sums[c][d] / float32(counts[c])
+			}
+		}
+	}
+	return centroids
+}
+
+/*
+nearestCentroid returns the index of the centroid closest to a point in squared
+Euclidean distance
+*/
+func nearestCentroid(centroids [][]float32, point []float32) int {
+	nearest, nearestDistance := 0, float32(math.MaxFloat32)
+	for c, centroid := range centroids {
+		var distance float32
+		for d, value := range point {
+			diff := value - centroid[d]
+			distance += diff * diff
+		}
+		if distance < nearestDistance {
+			nearest, nearestDistance = c, distance
+		}
+	}
+	return nearest
+}
+
+func (q *productQuantizer) encode(vector []float32) []byte {
+	code := make([]byte, len(q.codebooks))
+	for j, codebook := range q.codebooks {
+		code[j] = byte(nearestCentroid(codebook, vector[j*q.width:(j+1)*q.width]))
+	}
+	return code
+}
+
+func (q *productQuantizer) decode(code []byte) []float32 {
+	vector := make([]float32, 0, len(code)*q.width)
+	for j, c := range code {
+		vector = append(vector, q.codebooks[j][c]...)
+	}
+	return vector
+}
+
+func (q *productQuantizer) queryDistance(query []float32) func(code []byte) float32 {
+	// table[j][c] holds the contribution of centroid c of subspace j to the distance
+	table := make([][]float32, len(q.codebooks))
+	var queryNorm float32
+	for j, codebook := range q.codebooks {
+		sub := query[j*q.width : (j+1)*q.width]
+		table[j] = make([]float32, len(codebook))
+		for c, centroid := range codebook {
+			var part float32
+			for d, value := range sub {
+				switch q.distanceType {
+				case config.DistanceTypeCosine:
+					part += value * centroid[d]
+				case config.DistanceTypeManhattan:
+					part += float32(math.Abs(float64(value - centroid[d])))
+				case config.DistanceTypeHamming:
+					if value != centroid[d] {
+						part++
+					}
+				default:
+					diff := value - centroid[d]
+					part += diff * diff
+				}
+			}
+			table[j][c] = part
+		}
+		for _, value := range sub {
+			queryNorm += value * value
+		}
+	}
+
+	switch q.distanceType {
+	case config.DistanceTypeCosine:
+		return func(code []byte) float32 {
+			var dot, norm float32
+			for j, c := range code {
+				dot += table[j][c]
+				norm += q.norms[j][c]
+			}
+			return cosineDistanceFromParts(dot, queryNorm, norm)
+		}
+	case config.DistanceTypeManhattan, config.DistanceTypeHamming:
+		return func(code []byte) float32 {
+			var sum float32
+			for j, c := range code {
+				sum += table[j][c]
+			}
+			return sum
+		}
+	default:
+		return func(code []byte) float32 {
+			var sum float32
+			for j, c := range code {
+				sum += table[j][c]
+			}
+			return float32(math.Sqrt(float64(sum)))
+		}
+	}
+}
+
+func (q *productQuantizer) file() *quantizerFile {
+	return &quantizerFile{Type: config.QuantizationProduct, Codebooks: q.codebooks}
+}
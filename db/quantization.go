@@ -0,0 +1,208 @@
+package db
+
+import (
+	"fmt"
+	"math"
+
+	"vector-db/config"
+)
+
+// defaultQuantizationTrainingSize is the number of vectors a codec is trained on when
+// the configuration does not say otherwise
+const defaultQuantizationTrainingSize = 1000
+
+/*
+quantizer compresses vectors into compact codes and computes approximate distances
+between full-precision queries and codes
+*/
+type quantizer interface {
+	// encode compresses a vector into its code
+	encode(vector []float32) []byte
+	// decode reconstructs the vector a code stands for
+	decode(code []byte) []float32
+	// queryDistance returns a function measuring the approximate distance from the
+	// query to a code. The function is only used by the goroutine that requested it.
+	queryDistance(query []float32) func(code []byte) float32
+	// file returns the trained parameters in their saved form
+	file() *quantizerFile
+}
+
+/*
+quantizerFile is the saved form of a trained codec
+*/
+type quantizerFile struct {
+	Type config.QuantizationType `json:"type"`
+	// per-dimension minimum of the scalar codec
+	Min []float32 `json:"min,omitempty"`
+	// per-dimension step between two codes of the scalar codec
+	Scale []float32 `json:"scale,omitempty"`
+	// centroids of every subspace of the product codec
+	Codebooks [][][]float32 `json:"codebooks,omitempty"`
+}
+
+/*
+validateQuantization checks a quantization configuration for vectors of the given dimensions
+*/
+func validateQuantization(cfg config.QuantizationConfig, dimensions int) error {
+	switch cfg.Type {
+	case config.QuantizationNone, config.QuantizationScalar, config.QuantizationBinary:
+	case config.QuantizationProduct:
+		if cfg.Subspaces < 0 || cfg.Subspaces > dimensions || (cfg.Subspaces > 0 && dimensions%cfg.Subspaces != 0) {
+			return fmt.Errorf("%w: %d subspaces do not divide %d dimensions", ErrInvalidParameter, cfg.Subspaces, dimensions)
+		}
+	default:
+		return fmt.Errorf("%w: unknown quantization %q", ErrInvalidParameter, cfg.Type)
+	}
+	if cfg.TrainingSize < 0 {
+		return fmt.Errorf("%w: negative quantization training size", ErrInvalidParameter)
+	}
+	return nil
+}
+
+/*
+newQuantizer trains the configured codec on sample vectors
+*/
+func newQuantizer(cfg config.QuantizationConfig, distanceType config.DistanceType, samples [][]float32) quantizer {
+	switch cfg.Type {
+	case config.QuantizationScalar:
+		return newScalarQuantizer(distanceType, samples)
+	case config.QuantizationProduct:
+		return newProductQuantizer(distanceType, samples, cfg.Subspaces)
+	case config.QuantizationBinary:
+		return newBinaryQuantizer(len(samples[0]))
+	default:
+		return nil
+	}
+}
+
+/*
+loadQuantizer rebuilds a trained codec for vectors of the given dimensions from its saved form
+*/
+func loadQuantizer(f *quantizerFile, distanceType config.DistanceType, dimensions int) (quantizer, error) {
+	switch f.Type {
+	case config.QuantizationScalar:
+		if len(f.Min) != dimensions || len(f.Scale) != dimensions {
+			return nil, fmt.Errorf("%w: scalar codec has %d minimums and %d scales for %d dimensions",
+				ErrInvalidParameter, len(f.Min), len(f.Scale), dimensions)
+		}
+		return &scalarQuantizer{distanceType: distanceType, min: f.Min, scale: f.Scale}, nil
+	case config.QuantizationProduct:
+		return loadProductQuantizer(f.Codebooks, distanceType, dimensions)
+	case config.QuantizationBinary:
+		return newBinaryQuantizer(dimensions), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown quantization %q", ErrInvalidParameter, f.Type)
+	}
+}
+
+/*
+scalarQuantizer maps every dimension linearly onto 256 levels between the minimum and
+maximum the dimension takes in the training vectors, storing one byte per dimension.
+Values outside the trained range are clamped.
+*/
+type scalarQuantizer struct {
+	distanceType config.DistanceType
+	// per-dimension value of code 0
+	min []float32
+	// per-dimension step between two codes, 0 for constant dimensions
+	scale []float32
+}
+
+/*
+newScalarQuantizer trains a scalar codec on the range of every dimension of the samples
+*/
+func newScalarQuantizer(distanceType config.DistanceType, samples [][]float32) *scalarQuantizer {
+	dimensions := len(samples[0])
+	q := &scalarQuantizer{
+		distanceType: distanceType,
+		min:          make([]float32, dimensions),
+		scale:        make([]float32, dimensions),
+	}
+
+	maximum := make([]float32, dimensions)
+	copy(q.min, samples[0])
+	copy(maximum, samples[0])
+	for _, sample := range samples[1:] {
+		for i, value := range sample {
+			if value < q.min[i] {
+				q.min[i] = value
+			}
+			if value > maximum[i] {
+				maximum[i] = value
+			}
+		}
+	}
+	for i := range q.scale {
+		q.scale[i] = (maximum[i] - q.min[i]) / math.MaxUint8
+	}
+	return q
+}
+
+func (q *scalarQuantizer) encode(vector []float32) []byte {
+	code := make([]byte, len(vector))
+	for i, value := range vector {
+		if q.scale[i] == 0 {
+			continue
+		}
+		level := math.Round(float64((value - q.min[i]) / q.scale[i]))
+		code[i] = byte(math.Max(0, math.Min(math.MaxUint8, level)))
+	}
+	return code
+}
+
+func (q *scalarQuantizer) decode(code []byte) []float32 {
+	vector := make([]float32, len(code))
+	for i, c := range code {
+		vector[i] = q.min[i] + float32(c)*q.scale[i]
+	}
+	return vector
+}
+
+func (q *scalarQuantizer) queryDistance(query []float32) func(code []byte) float32 {
+	return func(code []byte) float32 {
+		return q.distance(query, code)
+	}
+}
+
+/*
+distance measures the distance between a full-precision vector and the vector
+reconstructed from a code, without materializing the reconstruction
+*/
+func (q *scalarQuantizer) distance(query []float32, code []byte) float32 {
+	switch q.distanceType {
+	case config.DistanceTypeCosine:
+		var dot, normA, normB float32
+		for i, c := range code {
+			value := q.min[i] + float32(c)*q.scale[i]
+			dot += query[i] * value
+			normA += query[i] * query[i]
+			normB += value * value
+		}
+		return cosineDistanceFromParts(dot, normA, normB)
+	case config.DistanceTypeManhattan:
+		var sum float32
+		for i, c := range code {
+			sum += float32(math.Abs(float64(query[i] - q.min[i] - float32(c)*q.scale[i])))
+		}
+		return sum
+	case config.DistanceTypeHamming:
+		var sum float32
+		for i, c := range code {
+			if query[i] != q.min[i]+float32(c)*q.scale[i] {
+				sum++
+			}
+		}
+		return sum
+	default:
+		var sum float32
+		for i, c := range code {
+			diff := query[i] - q.min[i] - float32(c)*q.scale[i]
+			sum += diff * diff
+		}
+		return float32(math.Sqrt(float64(sum)))
+	}
+}
+
+func (q *scalarQuantizer) file() *quantizerFile {
+	return &quantizerFile{Type: config.QuantizationScalar, Min: q.min, Scale: q.scale}
+}
@@ -0,0 +1,383 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"vector-db/config"
+)
+
+func randomVectors(n, dimensions int) []Vector {
+	vectors := make([]Vector, n)
+	for i := range vectors {
+		data := make([]float32, dimensions)
+		for j := range data {
+			data[j] = rand.Float32()*2 - 1
+		}
+		vectors[i] = Vector{ID: fmt.Sprintf("%d", i), Data: data}
+	}
+	return vectors
+}
+
+func TestScalarQuantizer(t *testing.T) {
+	samples := [][]float32{{0, -1, 5}, {1, 1, 5}, {0.5, 0, 5}}
+	distanceTypes := []config.DistanceType{
+		config.DistanceTypeEuclidean,
+		config.DistanceTypeCosine,
+		config.DistanceTypeManhattan,
+	}
+	for _, distanceType := range distanceTypes {
+		q := newScalarQuantizer(distanceType, samples)
+		graph := NewHNSWGraph(8, 100, distanceType)
+
+		// The extremes map to the ends of the code range, constant dimensions to 0,
+		// and values outside the trained range are clamped
+		if code := q.encode([]float32{1, -1, 5}); !reflect.DeepEqual(code, []byte{255, 0, 0}) {
+			t.Errorf("%s: expected code [255 0 0], got %v", distanceType, code)
+		}
+		if code := q.encode([]float32{2, -3, 7}); !reflect.DeepEqual(code, []byte{255, 0, 0}) {
+			t.Errorf("%s: expected clamped code [255 0 0], got %v", distanceType, code)
+		}
+
+		// Distances on codes are within the quantization error of the exact ones
+		query := []float32{0.3, 0.2, 4}
+		distance := q.queryDistance(query)
+		for _, vector := range [][]float32{{0.25, 0.7, 5}, {0.9, -0.4, 5}} {
+			exact := graph.Distance(query, vector)
+			approximate := distance(q.encode(vector))
+			if math.Abs(float64(exact-approximate)) > 0.01 {
+				t.Errorf("%s: distance to %v is %f on codes, %f exactly", distanceType, vector, approximate, exact)
+			}
+		}
+	}
+}
+
+func TestProductQuantizer(t *testing.T) {
+	// Two clusters per subspace, so k-means recovers their centers exactly
+	var samples [][]float32
+	for i := 0; i < 20; i++ {
+		samples = append(samples, []float32{0, 0, 10, 10}, []float32{4, 4, -10, -10})
+	}
+	q := newProductQuantizer(config.DistanceTypeEuclidean, samples, 2)
+	if len(q.codebooks) != 2 || len(q.codebooks[0]) != 40 || q.width != 2 {
+		t.Fatalf("Expected 2 codebooks of 40 centroids over 2 dimensions, got %d of %d over %d",
+			len(q.codebooks), len(q.codebooks[0]), q.width)
+	}
+
+	// Codes pick the nearest centroid of each subspace independently
+	code := q.encode([]float32{3.5, 4, 9, 11})
+	if got := q.codebooks[0][code[0]]; !reflect.DeepEqual(got, []float32{4, 4}) {
+		t.Errorf("Expected centroid [4 4] for the first subspace, got %v", got)
+	}
+	if got := q.codebooks[1][code[1]]; !reflect.DeepEqual(got, []float32{10, 10}) {
+		t.Errorf("Expected centroid [10 10] for the second subspace, got %v", got)
+	}
+
+	// Distances from the tables match the distances to the reconstructed vector
+	distanceTypes := []config.DistanceType{
+		config.DistanceTypeEuclidean,
+		config.DistanceTypeCosine,
+		config.DistanceTypeManhattan,
+	}
+	query := []float32{1, 2, 3, 4}
+	for _, distanceType := range distanceTypes {
+		q := newProductQuantizer(distanceType, samples, 2)
+		graph := NewHNSWGraph(8, 100, distanceType)
+		for _, vector := range [][]float32{{0, 0, -10, -10}, {4, 4, 10, 10}} {
+			exact := graph.Distance(query, vector)
+			approximate := q.queryDistance(query)(q.encode(vector))
+			if math.Abs(float64(exact-approximate)) > 1e-4 {
+				t.Errorf("%s: distance to %v is %f on codes, %f exactly", distanceType, vector, approximate, exact)
+			}
+		}
+	}
+
+	// Codecs are rebuilt from their codebooks, which must fit the dimensions
+	loaded, err := loadQuantizer(q.file(), config.DistanceTypeEuclidean, 4)
+	if err != nil || !reflect.DeepEqual(loaded.encode([]float32{3.5, 4, 9, 11}), code) {
+		t.Errorf("Codec not rebuilt from its codebooks (err %v)", err)
+	}
+	if _, err := loadQuantizer(q.file(), config.DistanceTypeEuclidean, 6); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for mismatched dimensions, got %v", err)
+	}
+
+	for dimensions, want := range map[int]int{3: 1, 8: 2, 12: 3, 100: 25, 1536: 384} {
+		if got := defaultSubspaces(dimensions); got != want {
+			t.Errorf("Expected %d default subspaces for %d dimensions, got %d", want, dimensions, got)
+		}
+	}
+}
+
+func TestBinaryQuantizer(t *testing.T) {
+	// 70 dimensions span two words; positive values set their bit
+	q := newBinaryQuantizer(70)
+	vector := make([]float32, 70)
+	vector[0], vector[3], vector[64], vector[69] = 1, 0.5, 2, 3
+	vector[1], vector[65] = -1, 0
+	want := make([]byte, 16)
+	want[0], want[8] = 0b1001, 0b100001
+	if code := q.encode(vector); !reflect.DeepEqual(code, want) {
+		t.Errorf("Expected code %v, got %v", want, code)
+	}
+
+	// The distance counts the dimensions whose signs differ
+	query := make([]float32, 70)
+	query[0], query[1], query[64], query[66] = 1, 1, 1, 1
+	if distance := q.queryDistance(query)(q.encode(vector)); distance != 4 {
+		t.Errorf("Expected Hamming distance 4, got %f", distance)
+	}
+}
+
+func TestHNSWBinaryQuantization(t *testing.T) {
+	const dimensions = 16
+	vectors := randomVectors(200, dimensions)
+
+	graph := NewHNSWGraph(16, 100, config.DistanceTypeCosine)
+	if err := graph.SetQuantization(config.QuantizationConfig{Type: config.QuantizationBinary}, dimensions); err != nil {
+		t.Fatalf("Failed to configure quantization: %v", err)
+	}
+
+	// Binary codes need no training, so the codec is in use from the first vector on
+	if err := graph.Insert(vectors[0]); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	graph.waitForTraining()
+	if code := graph.nodeByID(vectors[0].ID).code; len(code) != 8 {
+		t.Fatalf("Expected an 8-byte code, got %v", code)
+	}
+	graph.InsertBatch(vectors[1:], 4)
+
+	// Results are rescored with exact distances by default
+	query := vectors[0].Data
+	results, err := graph.SearchWithOptions(query, 5, SearchOptions{})
+	if err != nil || len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %v (err %v)", results, err)
+	}
+	if results[0].ID != vectors[0].ID {
+		t.Errorf("Expected the query vector first, got %s", results[0].ID)
+	}
+	// Cosine graphs rescore with the dot product scaled by the normalizing factors
+	prepared := graph.prepare(query)
+	for _, result := range results {
+		if exact := graph.storedDistance(prepared, result.Data, graph.nodeByID(result.ID).scale); result.Distance != exact {
+			t.Errorf("Result %s has distance %f, exact distance is %f", result.ID, result.Distance, exact)
+		}
+	}
+
+	// Without rescoring, results carry the Hamming distances of their codes
+	graph.quantization.NoRescore = true
+	results, err = graph.SearchWithOptions(query, 5, SearchOptions{})
+	if err != nil || len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %v (err %v)", results, err)
+	}
+	distance := graph.quantizer.queryDistance(query)
+	for i, result := range results {
+		if want := distance(graph.nodeByID(result.ID).code); result.Distance != want {
+			t.Errorf("Result %s has distance %f, Hamming distance is %f", result.ID, result.Distance, want)
+		}
+		// Scores stay in the range of a cosine similarity
+		if want := 1 - 2*result.Distance/dimensions; result.Score != want {
+			t.Errorf("Result %s has score %f for Hamming distance %f, expected %f", result.ID, result.Score, result.Distance, want)
+		}
+		if i > 0 && results[i-1].Distance > result.Distance {
+			t.Errorf("Results are not sorted by distance")
+		}
+	}
+}
+
+func TestHNSWScalarQuantization(t *testing.T) {
+	const dimensions = 16
+	vectors := randomVectors(600, dimensions)
+
+	graph := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	if err := graph.SetQuantization(config.QuantizationConfig{Type: config.QuantizationScalar, TrainingSize: 300}, dimensions); err != nil {
+		t.Fatalf("Failed to configure quantization: %v", err)
+	}
+
+	// The codec is trained once enough vectors are stored
+	for _, vector := range vectors[:299] {
+		if err := graph.Insert(vector); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if graph.quantizer != nil {
+		t.Fatalf("Codec trained before reaching the training size")
+	}
+	if errs := graph.InsertBatch(vectors[299:], 4); errs[0] != nil {
+		t.Fatalf("Insert failed: %v", errs[0])
+	}
+	graph.waitForTraining()
+	if graph.quantizer == nil {
+		t.Fatalf("Codec not trained after reaching the training size")
+	}
+	for _, vector := range vectors {
+		if code := graph.nodeByID(vector.ID).code; len(code) != dimensions {
+			t.Fatalf("Vector %s has a code of %d bytes", vector.ID, len(code))
+		}
+	}
+
+	// Only the codes stay in memory, the vectors are read back from disk
+	for _, chunk := range graph.store.Load().chunks {
+		if chunk.data != nil {
+			t.Fatalf("Arena still holds vector data after quantization")
+		}
+	}
+	for _, vector := range vectors {
+		if node := graph.nodeByID(vector.ID); node.vector.Data != nil {
+			t.Fatalf("Vector %s keeps its data in memory", vector.ID)
+		}
+		if stored, _ := graph.GetVector(vector.ID); !reflect.DeepEqual(stored.Data, vector.Data) {
+			t.Fatalf("Vector %s reads back as %v, expected %v", vector.ID, stored.Data, vector.Data)
+		}
+	}
+
+	exact := NewHNSWGraph(16, 100, config.DistanceTypeEuclidean)
+	exact.InsertBatch(vectors, 4)
+
+	// Results carry exact distances, and recall stays close to the unquantized graph
+	const k = 10
+	recall := func(g *HNSWGraph, query []float32, truth map[string]bool) int {
+		results, err := g.SearchWithOptions(query, k, SearchOptions{})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		found := 0
+		for i, result := range results {
+			if want := g.Distance(query, result.Data); result.Distance != want {
+				t.Errorf("Result %s has distance %f, exact distance is %f", result.ID, result.Distance, want)
+			}
+			if i > 0 && results[i-1].Distance > result.Distance {
+				t.Errorf("Results are not sorted by distance")
+			}
+			if truth[result.ID] {
+				found++
+			}
+		}
+		return found
+	}
+
+	quantizedFound, exactFound := 0, 0
+	for _, query := range randomVectors(20, dimensions) {
+		sorted := append([]Vector(nil), vectors...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return graph.Distance(query.Data, sorted[i].Data) < graph.Distance(query.Data, sorted[j].Data)
+		})
+		truth := make(map[string]bool, k)
+		for _, vector := range sorted[:k] {
+			truth[vector.ID] = true
+		}
+
+		quantizedFound += recall(graph, query.Data, truth)
+		exactFound += recall(exact, query.Data, truth)
+	}
+	t.Logf("Recall@%d: %.3f quantized, %.3f unquantized", k, float64(quantizedFound)/(20*k), float64(exactFound)/(20*k))
+	if float64(quantizedFound) < 0.8*float64(exactFound) {
+		t.Errorf("Quantized recall %d/%d is far below the unquantized %d/%d", quantizedFound, 20*k, exactFound, 20*k)
+	}
+
+	// Range searches compare the radius with exact distances
+	query := vectors[0].Data
+	results, err := graph.RangeSearch(query, 0.5, 0)
+	if err != nil {
+		t.Fatalf("Range search failed: %v", err)
+	}
+	for _, result := range results {
+		if result.Distance > 0.5 || result.Distance != graph.Distance(query, result.Data) {
+			t.Errorf("Range search returned %s at distance %f", result.ID, result.Distance)
+		}
+	}
+}
+
+func TestQuantizationPersistence(t *testing.T) {
+	codecs := []config.QuantizationConfig{
+		{Type: config.QuantizationScalar, TrainingSize: 50},
+		{Type: config.QuantizationProduct, TrainingSize: 50},
+		{Type: config.QuantizationBinary},
+	}
+	for _, codec := range codecs {
+		t.Run(string(codec.Type), func(t *testing.T) {
+			testQuantizationPersistence(t, codec)
+		})
+	}
+}
+
+func testQuantizationPersistence(t *testing.T, codec config.QuantizationConfig) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     8,
+			DistanceType:   config.DistanceTypeCosine,
+			Quantization:   codec,
+		},
+	}
+
+	manager := NewManager(&config.Config{})
+	original, err := manager.CreateDatabase("test", dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if _, err := manager.AddVectors("test", randomVectors(100, 8)); err != nil {
+		t.Fatalf("Failed to add vectors: %v", err)
+	}
+	original.Graph.waitForTraining()
+	if original.Graph.quantizer == nil {
+		t.Fatalf("Codec not trained")
+	}
+
+	persistence := NewPersistenceManager(t.TempDir())
+	if err := persistence.SaveDatabase(original); err != nil {
+		t.Fatalf("Failed to save database: %v", err)
+	}
+	loaded, err := persistence.LoadDatabase("test")
+	if err != nil {
+		t.Fatalf("Failed to load database: %v", err)
+	}
+
+	// The saved codec is used as is rather than trained again
+	if loaded.Graph.quantizer == nil || !reflect.DeepEqual(loaded.Graph.quantizer.file(), original.Graph.quantizer.file()) {
+		t.Fatalf("Codec not restored")
+	}
+	for id := range loaded.Vectors {
+		vector, _ := loaded.Graph.GetVector(id)
+		node := loaded.Graph.nodeByID(id)
+		if !reflect.DeepEqual(node.code, original.Graph.quantizer.encode(loaded.Graph.unitVector(vector.Data, node.scale))) {
+			t.Fatalf("Vector %s was not re-encoded", id)
+		}
+	}
+
+	stored, _ := original.Graph.GetVector("7")
+	query := stored.Data
+	before, _ := original.Graph.SearchWithOptions(query, 5, SearchOptions{})
+	after, _ := loaded.Graph.SearchWithOptions(query, 5, SearchOptions{})
+	if len(before) != len(after) {
+		t.Fatalf("Expected %d results after loading, got %d", len(before), len(after))
+	}
+	for i := range before {
+		if before[i].ID != after[i].ID {
+			t.Errorf("Result %d changed from %s to %s after loading", i, before[i].ID, after[i].ID)
+		}
+	}
+}
+
+func TestQuantizationConfig(t *testing.T) {
+	manager := NewManager(&config.Config{})
+	invalid := []config.QuantizationConfig{
+		{Type: "float16"},
+		{Type: config.QuantizationScalar, TrainingSize: -1},
+		{Type: config.QuantizationProduct, Subspaces: 3},
+		{Type: config.QuantizationProduct, Subspaces: 8},
+		{Type: config.QuantizationProduct, Subspaces: -2},
+	}
+	for _, quantization := range invalid {
+		dbConfig := config.DatabaseConfig{HNSW: config.HNSWConfig{M: 8, Dimensions: 4, Quantization: quantization}}
+		if _, err := manager.CreateDatabase("test", dbConfig); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("%+v: expected ErrInvalidParameter, got %v", quantization, err)
+		}
+	}
+}
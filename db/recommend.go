@@ -0,0 +1,235 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+RecommendStrategy selects how the examples of a recommendation query are combined
+*/
+type RecommendStrategy string
+
+const (
+	// RecommendAverageVector searches once around the average of the positive examples,
+	// moved away from the average of the negative examples
+	RecommendAverageVector RecommendStrategy = "average_vector"
+	// RecommendBestScore searches around every positive example and ranks each candidate
+	// by its nearest positive example, demoting candidates nearer to a negative example
+	RecommendBestScore RecommendStrategy = "best_score"
+)
+
+/*
+RecommendQuery describes a recommendation by example.
+
+Examples are given as IDs of stored vectors, as raw vectors, or both. At least one
+positive example is required; negative examples are optional. Stored examples are
+left out of the results.
+*/
+type RecommendQuery struct {
+	// IDs of stored vectors the results should resemble
+	Positive []string `json:"positive,omitempty"`
+	// raw vectors the results should resemble
+	PositiveVectors [][]float32 `json:"positive_vectors,omitempty"`
+	// IDs of stored vectors the results should not resemble
+	Negative []string `json:"negative,omitempty"`
+	// raw vectors the results should not resemble
+	NegativeVectors [][]float32 `json:"negative_vectors,omitempty"`
+	// how the examples are combined (empty uses average_vector)
+	Strategy RecommendStrategy `json:"strategy,omitempty"`
+}
+
+/*
+Recommend returns the k vectors of a specific database that best match the positive
+examples of the query and not its negative ones, excluding the stored examples
+*/
+func (m *Manager) Recommend(dbName string, query RecommendQuery, k int, opts SearchOptions) ([]SearchResult, error) {
+	db, err := m.GetDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	if k <= 0 {
+		return nil, ErrInvalidParameter
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Filter != nil {
+		if err := opts.Filter.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	positives, err := db.resolveExamples(query.Positive, query.PositiveVectors)
+	if err != nil {
+		return nil, err
+	}
+	if len(positives) == 0 {
+		return nil, fmt.Errorf("%w: at least one positive example is required", ErrInvalidParameter)
+	}
+	negatives, err := db.resolveExamples(query.Negative, query.NegativeVectors)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := make(map[string]struct{}, len(query.Positive)+len(query.Negative))
+	for _, id := range query.Positive {
+		exclude[id] = struct{}{}
+	}
+	for _, id := range query.Negative {
+		exclude[id] = struct{}{}
+	}
+
+	switch query.Strategy {
+	case "", RecommendAverageVector:
+		return db.recommendAverage(positives, negatives, exclude, k, opts)
+	case RecommendBestScore:
+		return db.recommendBestScore(positives, negatives, exclude, k, opts)
+	default:
+		return nil, fmt.Errorf("%w: unknown recommendation strategy %q", ErrInvalidParameter, query.Strategy)
+	}
+}
+
+/*
+resolveExamples collects the data of the stored vectors with the given IDs followed by
+the raw vectors, checking that all of them match the dimensions of the database
+*/
+func (db *Database) resolveExamples(ids []string, vectors [][]float32) ([][]float32, error) {
+	examples := make([][]float32, 0, len(ids)+len(vectors))
+	for _, id := range ids {
+		vector, exists, err := db.loadVector(id)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", ErrVectorNotFound, id)
+		}
+		examples = append(examples, vector.Data)
+	}
+	for _, vector := range vectors {
+		if len(vector) != db.Config.HNSW.Dimensions {
+			return nil, ErrInvalidDimensions
+		}
+		examples = append(examples, vector)
+	}
+	return examples, nil
+}
+
+/*
+recommendAverage searches around avg(positives) + (avg(positives) - avg(negatives)),
+or avg(positives) when there are no negatives. With one negative and two positives
+this is the analogy query b - a + c.
+*/
+func (db *Database) recommendAverage(positives, negatives [][]float32, exclude map[string]struct{}, k int, opts SearchOptions) ([]SearchResult, error) {
+	target := averageVector(positives)
+	if len(negatives) > 0 {
+		direction, _ := VectorSubtract(target, averageVector(negatives))
+		target, _ = VectorAdd(target, direction)
+	}
+
+	// Ask for extra results to make up for the stored examples finding themselves
+	results, err := db.search(target, k+len(exclude), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return excludeResults(results, exclude, k), nil
+}
+
+/*
+recommendBestScore merges the neighbors of every positive example. Each candidate is
+ranked by the distance to its nearest positive example; candidates nearer to some
+negative example than to every positive one are ranked after all the others.
+*/
+func (db *Database) recommendBestScore(positives, negatives [][]float32, exclude map[string]struct{}, k int, opts SearchOptions) ([]SearchResult, error) {
+	// The candidates' data is needed to measure them against the other examples
+	searchOpts := opts
+	searchOpts.OmitVectors = false
+
+	candidates := make(map[string]SearchResult)
+	for _, positive := range positives {
+		results, err := db.search(positive, k+len(exclude), searchOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if _, excluded := exclude[result.ID]; !excluded {
+				candidates[result.ID] = result
+			}
+		}
+	}
+
+	type rankedResult struct {
+		result  SearchResult
+		demoted bool
+	}
+	ranked := make([]rankedResult, 0, len(candidates))
+	for _, candidate := range candidates {
+		positiveDistance := db.Graph.nearestDistance(candidate.Data, positives)
+		demoted := len(negatives) > 0 && db.Graph.nearestDistance(candidate.Data, negatives) < positiveDistance
+
+		candidate.Distance = positiveDistance
+		candidate.Score = db.Graph.Score(positiveDistance)
+		if opts.OmitVectors {
+			candidate.Data = nil
+		}
+		ranked = append(ranked, rankedResult{result: candidate, demoted: demoted})
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].demoted != ranked[j].demoted {
+			return !ranked[i].demoted
+		}
+		if ranked[i].result.Distance != ranked[j].result.Distance {
+			return ranked[i].result.Distance < ranked[j].result.Distance
+		}
+		return ranked[i].result.ID < ranked[j].result.ID
+	})
+
+	results := make([]SearchResult, 0, min(k, len(ranked)))
+	for _, item := range ranked[:min(k, len(ranked))] {
+		results = append(results, item.result)
+	}
+	return results, nil
+}
+
+/*
+nearestDistance returns the distance from vector to the nearest of the examples
+*/
+func (g *HNSWGraph) nearestDistance(vector []float32, examples [][]float32) float32 {
+	nearest := g.Distance(vector, examples[0])
+	for _, example := range examples[1:] {
+		if distance := g.Distance(vector, example); distance < nearest {
+			nearest = distance
+		}
+	}
+	return nearest
+}
+
+/*
+averageVector returns the element-wise mean of vectors of equal dimensions
+*/
+func averageVector(vectors [][]float32) []float32 {
+	sum := vectors[0]
+	for _, vector := range vectors[1:] {
+		sum, _ = VectorAdd(sum, vector)
+	}
+	return ScalarMultiply(sum, 1/float32(len(vectors)))
+}
+
+/*
+excludeResults drops the excluded IDs from results, keeping at most k
+*/
+func excludeResults(results []SearchResult, exclude map[string]struct{}, k int) []SearchResult {
+	kept := results[:0]
+	for _, result := range results {
+		if _, excluded := exclude[result.ID]; !excluded {
+			kept = append(kept, result)
+		}
+	}
+	if len(kept) > k {
+		kept = kept[:k]
+	}
+	return kept
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"vector-db/config"
+)
+
+func newRecommendManager(t *testing.T) *Manager {
+	cfg := &config.Config{
+		DefaultDatabase: config.DatabaseConfig{
+			HNSW: config.HNSWConfig{
+				M:              8,
+				EfConstruction: 100,
+				Dimensions:     2,
+				DistanceType:   config.DistanceTypeEuclidean,
+			},
+		},
+	}
+
+	manager := NewManager(cfg)
+	_, _ = manager.CreateDatabase("test", cfg.DefaultDatabase)
+	batch := make([]Vector, 20)
+	for i := range batch {
+		batch[i] = Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0}, Metadata: map[string]interface{}{"even": i%2 == 0}}
+	}
+	if _, err := manager.AddVectors("test", batch); err != nil {
+		t.Fatalf("Failed to add batch: %v", err)
+	}
+	return manager
+}
+
+func TestRecommendAverageVector(t *testing.T) {
+	manager := newRecommendManager(t)
+	opts := SearchOptions{Ef: 50}
+
+	// The average of the positive examples, which are themselves excluded
+	results, err := manager.Recommend("test", RecommendQuery{Positive: []string{"3", "5"}}, 1, opts)
+	if err != nil || len(results) != 1 || results[0].ID != "4" {
+		t.Errorf("Expected vector 4, got %v (err %v)", results, err)
+	}
+
+	results, err = manager.Recommend("test", RecommendQuery{Positive: []string{"3", "5"}}, 5, opts)
+	if err != nil || len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %v (err %v)", results, err)
+	}
+	for _, result := range results {
+		if result.ID == "3" || result.ID == "5" {
+			t.Errorf("Recommendation returned the example %s", result.ID)
+		}
+	}
+
+	// Negative examples push the target away: 10 + (10 - 5) = 15
+	results, err = manager.Recommend("test", RecommendQuery{Positive: []string{"10"}, Negative: []string{"5"}}, 1, opts)
+	if err != nil || len(results) != 1 || results[0].ID != "15" {
+		t.Errorf("Expected vector 15, got %v (err %v)", results, err)
+	}
+
+	// Raw vectors are used as examples but not excluded
+	query := RecommendQuery{PositiveVectors: [][]float32{{7.2, 0}}, Strategy: RecommendAverageVector}
+	results, err = manager.Recommend("test", query, 1, opts)
+	if err != nil || len(results) != 1 || results[0].ID != "7" {
+		t.Errorf("Expected vector 7, got %v (err %v)", results, err)
+	}
+
+	// Search options apply to the results
+	opts.Filter = &Filter{Op: FilterEq, Key: "even", Value: false}
+	results, err = manager.Recommend("test", RecommendQuery{Positive: []string{"4"}}, 2, opts)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 filtered results, got %v (err %v)", results, err)
+	}
+	for _, result := range results {
+		if result.ID != "3" && result.ID != "5" {
+			t.Errorf("Expected the odd neighbors 3 and 5, got %v", results)
+		}
+	}
+}
+
+func TestRecommendBestScore(t *testing.T) {
+	manager := newRecommendManager(t)
+	opts := SearchOptions{Ef: 50, OmitVectors: true}
+
+	query := RecommendQuery{
+		Positive:        []string{"2", "17"},
+		NegativeVectors: [][]float32{{18, 0}},
+		Strategy:        RecommendBestScore,
+	}
+	results, err := manager.Recommend("test", query, 3, opts)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %v (err %v)", results, err)
+	}
+	// Both positive examples contribute neighbors, while 18 sits on the negative example
+	for _, result := range results {
+		if result.ID != "1" && result.ID != "3" && result.ID != "16" {
+			t.Errorf("Expected the neighbors 1, 3 and 16, got %v", results)
+		}
+		if result.Distance != 1 || result.Data != nil {
+			t.Errorf("Expected distance 1 to the nearest positive and no data, got %+v", result)
+		}
+	}
+
+	// Candidates nearer to a negative example are ranked after all the others
+	results, err = manager.Recommend("test", query, 10, opts)
+	if err != nil || len(results) != 10 {
+		t.Fatalf("Expected 10 results, got %v (err %v)", results, err)
+	}
+	demoted := false
+	for _, result := range results {
+		switch {
+		case result.ID == "2" || result.ID == "17":
+			t.Errorf("Recommendation returned the example %s", result.ID)
+		case result.ID == "18" || result.ID == "19":
+			demoted = true
+		case demoted:
+			t.Errorf("Result %s was ranked after a demoted result: %v", result.ID, results)
+		}
+	}
+}
+
+func TestRecommendErrors(t *testing.T) {
+	manager := newRecommendManager(t)
+
+	tests := []struct {
+		name  string
+		db    string
+		query RecommendQuery
+		k     int
+		want  error
+	}{
+		{"missing database", "missing", RecommendQuery{Positive: []string{"1"}}, 5, ErrDatabaseNotFound},
+		{"no positives", "test", RecommendQuery{Negative: []string{"1"}}, 5, ErrInvalidParameter},
+		{"missing example", "test", RecommendQuery{Positive: []string{"1"}, Negative: []string{"missing"}}, 5, ErrVectorNotFound},
+		{"wrong dimensions", "test", RecommendQuery{PositiveVectors: [][]float32{{1}}}, 5, ErrInvalidDimensions},
+		{"unknown strategy", "test", RecommendQuery{Positive: []string{"1"}, Strategy: "median"}, 5, ErrInvalidParameter},
+		{"zero k", "test", RecommendQuery{Positive: []string{"1"}}, 0, ErrInvalidParameter},
+	}
+	for _, test := range tests {
+		if _, err := manager.Recommend(test.db, test.query, test.k, SearchOptions{}); !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
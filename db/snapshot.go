@@ -0,0 +1,341 @@
+package db
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotsDir holds the numbered snapshot directories of a database
+	snapshotsDir = "snapshots"
+	// manifestFile lists the files of a snapshot with their checksums
+	manifestFile = "manifest.json"
+	// snapshotTmpPrefix marks snapshot directories that are still being written
+	snapshotTmpPrefix = ".tmp-"
+	// walSegmentPrefix names the write-ahead log segments kept next to the snapshots
+	walSegmentPrefix = "wal-"
+	// snapshotManifestVersion is the current manifest format
+	snapshotManifestVersion = 1
+	// defaultSnapshotRetention is the number of snapshots kept when none is configured
+	defaultSnapshotRetention = 3
+)
+
+// ErrSnapshotCorrupted is returned when a snapshot fails checksum verification
+var ErrSnapshotCorrupted = errors.New("snapshot corrupted")
+
+/*
+snapshotFile describes one file of a snapshot
+*/
+type snapshotFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+/*
+snapshotManifest is written last into a snapshot directory and lists every file
+it contains, so a snapshot without a readable manifest is never trusted
+*/
+type snapshotManifest struct {
+	Version   int            `json:"version"`
+	Sequence  uint64         `json:"sequence"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []snapshotFile `json:"files"`
+}
+
+/*
+file looks up a file entry by name
+*/
+func (m *snapshotManifest) file(name string) (snapshotFile, bool) {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return snapshotFile{}, false
+}
+
+/*
+snapshotWriter builds a snapshot in a temporary directory and publishes it with a rename
+*/
+type snapshotWriter struct {
+	root     string
+	tmpDir   string
+	manifest snapshotManifest
+}
+
+/*
+newSnapshotWriter prepares a temporary directory for the next snapshot under root
+*/
+func newSnapshotWriter(root string) (*snapshotWriter, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	sequences, err := listSnapshots(root)
+	if err != nil {
+		return nil, err
+	}
+	var sequence uint64 = 1
+	if len(sequences) > 0 {
+		sequence = sequences[len(sequences)-1] + 1
+	}
+
+	tmpDir := filepath.Join(root, snapshotTmpPrefix+snapshotName(sequence))
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &snapshotWriter{
+		root:   root,
+		tmpDir: tmpDir,
+		manifest: snapshotManifest{
+			Version:   snapshotManifestVersion,
+			Sequence:  sequence,
+			CreatedAt: time.Now().UTC(),
+		},
+	}, nil
+}
+
+/*
+writeFile streams a file into the snapshot, recording its size and checksum
+and syncing it to disk before returning
+*/
+func (w *snapshotWriter) writeFile(name string, write func(io.Writer) error) error {
+	file, err := os.Create(filepath.Join(w.tmpDir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	buffered := bufio.NewWriterSize(io.MultiWriter(file, hasher, counter), 1<<16)
+
+	if err := write(buffered); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	w.manifest.Files = append(w.manifest.Files, snapshotFile{
+		Name:   name,
+		Size:   counter.n,
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+	})
+	return nil
+}
+
+/*
+commit writes the manifest and atomically renames the temporary directory into place
+*/
+func (w *snapshotWriter) commit() error {
+	err := w.writeFile(manifestFile, func(out io.Writer) error {
+		return json.NewEncoder(out).Encode(w.manifest)
+	})
+	if err != nil {
+		return err
+	}
+	// The manifest does not list itself
+	w.manifest.Files = w.manifest.Files[:len(w.manifest.Files)-1]
+
+	if err := syncDir(w.tmpDir); err != nil {
+		return err
+	}
+	finalDir := filepath.Join(w.root, snapshotName(w.manifest.Sequence))
+	if err := os.Rename(w.tmpDir, finalDir); err != nil {
+		return err
+	}
+	return syncDir(w.root)
+}
+
+/*
+abort removes the temporary directory of a snapshot that failed to be written
+*/
+func (w *snapshotWriter) abort() {
+	os.RemoveAll(w.tmpDir)
+}
+
+/*
+snapshotReader reads the files of a snapshot, verifying them against its manifest.
+A reader without a manifest reads the legacy flat layout unverified.
+*/
+type snapshotReader struct {
+	dir      string
+	manifest *snapshotManifest
+}
+
+/*
+openSnapshot reads and checks the manifest of a snapshot directory
+*/
+func openSnapshot(dir string) (*snapshotReader, error) {
+	var manifest snapshotManifest
+	if err := readJSONFile(filepath.Join(dir, manifestFile), &manifest); err != nil {
+		return nil, fmt.Errorf("%w: unreadable manifest: %v", ErrSnapshotCorrupted, err)
+	}
+	if manifest.Version != snapshotManifestVersion {
+		return nil, fmt.Errorf("%w: unsupported manifest version %d", ErrSnapshotCorrupted, manifest.Version)
+	}
+	return &snapshotReader{dir: dir, manifest: &manifest}, nil
+}
+
+/*
+has reports whether the snapshot contains the named file
+*/
+func (r *snapshotReader) has(name string) bool {
+	if r.manifest == nil {
+		_, err := os.Stat(filepath.Join(r.dir, name))
+		return err == nil
+	}
+	_, ok := r.manifest.file(name)
+	return ok
+}
+
+/*
+readFile streams a file of the snapshot into read. The whole file is hashed while
+it is consumed, and a size or checksum mismatch is reported as ErrSnapshotCorrupted.
+*/
+func (r *snapshotReader) readFile(name string, read func(io.Reader) error) error {
+	file, err := os.Open(filepath.Join(r.dir, name))
+	if err != nil {
+		if r.manifest != nil {
+			return fmt.Errorf("%w: %v", ErrSnapshotCorrupted, err)
+		}
+		return err
+	}
+	defer file.Close()
+
+	if r.manifest == nil {
+		return read(bufio.NewReaderSize(file, 1<<16))
+	}
+
+	expected, ok := r.manifest.file(name)
+	if !ok {
+		return fmt.Errorf("%w: %s is not listed in the manifest", ErrSnapshotCorrupted, name)
+	}
+
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	tee := io.TeeReader(bufio.NewReaderSize(file, 1<<16), io.MultiWriter(hasher, counter))
+
+	readErr := read(tee)
+	// Hash whatever the decoder left unread
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return err
+	}
+
+	if counter.n != expected.Size || hex.EncodeToString(hasher.Sum(nil)) != expected.SHA256 {
+		return fmt.Errorf("%w: checksum mismatch in %s", ErrSnapshotCorrupted, name)
+	}
+	return readErr
+}
+
+/*
+listSnapshots returns the sequence numbers of the published snapshots under root, oldest first
+*/
+func listSnapshots(root string) ([]uint64, error) {
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sequences := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), snapshotTmpPrefix) {
+			continue
+		}
+		sequence, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		sequences = append(sequences, sequence)
+	}
+
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	return sequences, nil
+}
+
+/*
+pruneSnapshots removes all but the newest keep snapshots and any abandoned temporary directories
+*/
+func pruneSnapshots(root string, keep int) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), snapshotTmpPrefix) {
+			if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	sequences, err := listSnapshots(root)
+	if err != nil {
+		return err
+	}
+	for len(sequences) > keep {
+		if err := os.RemoveAll(filepath.Join(root, snapshotName(sequences[0]))); err != nil {
+			return err
+		}
+		sequences = sequences[1:]
+	}
+	return nil
+}
+
+/*
+snapshotName formats a sequence number so directory names sort chronologically
+*/
+func snapshotName(sequence uint64) string {
+	return fmt.Sprintf("%020d", sequence)
+}
+
+/*
+syncDir fsyncs a directory so renames and new entries in it are durable
+*/
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+/*
+countingWriter counts the bytes written through it
+*/
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -1,5 +1,7 @@
 package db
 
+import "fmt"
+
 /*
 Vector represents a vector in the database
 */
@@ -8,3 +10,49 @@ type Vector struct {
 	Data     []float32              `json:"data"`
 	Metadata map[string]interface{} `json:"metadata"`
 }
+
+/*
+SearchResult represents a single ranked hit returned by a similarity search
+*/
+type SearchResult struct {
+	ID       string                 `json:"id"`
+	Distance float32                `json:"distance"`
+	Score    float32                `json:"score"`
+	Data     []float32              `json:"data,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+/*
+SearchOptions controls how a similarity search is executed and what it returns.
+
+The zero value returns full results including vector data and metadata.
+*/
+type SearchOptions struct {
+	// Leave the vector data out of the results
+	OmitVectors bool `json:"omit_vectors"`
+	// Leave the metadata out of the results
+	OmitMetadata bool `json:"omit_metadata"`
+	// Only return vectors whose metadata matches this expression
+	Filter *Filter `json:"filter,omitempty"`
+	// Size of the candidate list in layer 0, trading speed for recall (0 uses the graph's EfSearch)
+	Ef int `json:"ef,omitempty"`
+	// Early-stop slack in layer 0: candidates up to this factor farther than the worst
+	// result are still explored. Must be at least 1; 0 uses the default of 1.1.
+	QualityThreshold float32 `json:"quality_threshold,omitempty"`
+	// Maximum number of nodes visited in layer 0, bounding the latency of a search at
+	// the cost of recall (0 means no limit)
+	MaxVisited int `json:"max_visited,omitempty"`
+}
+
+/*
+validate checks the search-time tuning options
+*/
+func (o SearchOptions) validate() error {
+	if o.Ef < 0 || o.MaxVisited < 0 {
+		return ErrInvalidParameter
+	}
+	if o.QualityThreshold != 0 && !(o.QualityThreshold >= 1) {
+		return fmt.Errorf("%w: quality threshold must be at least 1", ErrInvalidParameter)
+	}
+	return nil
+}
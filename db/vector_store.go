@@ -0,0 +1,81 @@
+package db
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+/*
+diskVectorStore keeps the full-precision vectors of a quantized graph on disk, one
+fixed-size record per internal ID, so only the codes of the vectors stay in memory.
+
+The file is scratch space rather than a durable copy, which snapshots already are:
+it is removed as soon as it is created, so the space is reclaimed when the store is
+closed or the process exits. Records are read and written at their offset, so any
+number of goroutines can use the store at once.
+*/
+type diskVectorStore struct {
+	file       *os.File
+	dimensions int
+	// path to remove on close, where the file could not be removed while open
+	path string
+}
+
+/*
+newDiskVectorStore creates an empty store for vectors of the given dimensions
+*/
+func newDiskVectorStore(dimensions int) (*diskVectorStore, error) {
+	file, err := os.CreateTemp("", "hnsw-vectors-*")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &diskVectorStore{file: file, dimensions: dimensions}
+	if err := os.Remove(file.Name()); err != nil {
+		// Some platforms cannot remove an open file
+		s.path = file.Name()
+	}
+	return s, nil
+}
+
+/*
+write stores the components of a vector under an internal ID, replacing any before
+*/
+func (s *diskVectorStore) write(id uint32, data []float32) error {
+	record := make([]byte, 4*s.dimensions)
+	for i, value := range data {
+		binary.LittleEndian.PutUint32(record[4*i:], math.Float32bits(value))
+	}
+	_, err := s.file.WriteAt(record, int64(id)*int64(len(record)))
+	return err
+}
+
+/*
+read returns the components stored under an internal ID in a new slice
+*/
+func (s *diskVectorStore) read(id uint32) ([]float32, error) {
+	record := make([]byte, 4*s.dimensions)
+	if _, err := s.file.ReadAt(record, int64(id)*int64(len(record))); err != nil {
+		return nil, err
+	}
+
+	data := make([]float32, s.dimensions)
+	for i := range data {
+		data[i] = math.Float32frombits(binary.LittleEndian.Uint32(record[4*i:]))
+	}
+	return data, nil
+}
+
+/*
+close closes the store and releases its file
+*/
+func (s *diskVectorStore) close() error {
+	err := s.file.Close()
+	if s.path != "" {
+		if removeErr := os.Remove(s.path); err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
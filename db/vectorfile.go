@@ -0,0 +1,312 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+/*
+The binary vector file stores a set of vectors of equal dimensions:
+
+	header    magic "GVEC", format version (uint32), dimensions (uint32), count (uint64)
+	vectors   count records of: ID length (uint32), ID bytes, dimensions float32 values,
+	          metadata length (uint32), JSON-encoded metadata (empty when nil)
+
+All integers and floats are little-endian. Each record is complete on its own, so
+a reader can hand every vector on as soon as it is decoded instead of holding the
+whole set. Version 1 files kept the metadata of all vectors in a block after the
+vector records; they are still read, which requires holding their vectors until
+the metadata block is reached.
+*/
+const (
+	vectorFileMagic   = "GVEC"
+	vectorFileVersion = 2
+	// vectorFileMaxRecord bounds ID and metadata records, so a damaged length
+	// cannot trigger a huge allocation before the checksum is verified
+	vectorFileMaxRecord = 1 << 26
+)
+
+// ErrInvalidVectorFile is returned when a binary vector file is malformed or of an unknown version
+var ErrInvalidVectorFile = errors.New("invalid vector file")
+
+/*
+WriteVectors streams vectors to w in the binary vector file format.
+
+Vectors are written in ID order, so the output is deterministic. Each vector is
+encoded through a small reusable buffer and no copy of the data set is made.
+*/
+func WriteVectors(w io.Writer, dimensions int, vectors map[string]Vector) error {
+	return writeVectorFile(w, dimensions, vectors, func(vector Vector) ([]float32, error) {
+		return vector.Data, nil
+	})
+}
+
+/*
+writeVectorFile streams vectors to w like WriteVectors, taking the data of each
+vector from load rather than from the map, so the data never has to be held in
+memory all at once
+*/
+func writeVectorFile(w io.Writer, dimensions int, vectors map[string]Vector, load func(Vector) ([]float32, error)) error {
+	ids := make([]string, 0, len(vectors))
+	for id := range vectors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	header := make([]byte, 0, 20)
+	header = append(header, vectorFileMagic...)
+	header = binary.LittleEndian.AppendUint32(header, vectorFileVersion)
+	header = binary.LittleEndian.AppendUint32(header, uint32(dimensions))
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(ids)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4*dimensions)
+	var length [4]byte
+
+	for _, id := range ids {
+		vector := vectors[id]
+		data, err := load(vector)
+		if err != nil {
+			return fmt.Errorf("vector with ID %s: %w", id, err)
+		}
+		if len(data) != dimensions {
+			return fmt.Errorf("vector with ID %s: %w", id, ErrInvalidDimensions)
+		}
+		var metadata []byte
+		if vector.Metadata != nil {
+			if metadata, err = json.Marshal(vector.Metadata); err != nil {
+				return fmt.Errorf("metadata of vector %s: %w", id, err)
+			}
+		}
+
+		binary.LittleEndian.PutUint32(length[:], uint32(len(id)))
+		if _, err := w.Write(length[:]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, id); err != nil {
+			return err
+		}
+
+		for i, value := range data {
+			binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(value))
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+
+		binary.LittleEndian.PutUint32(length[:], uint32(len(metadata)))
+		if _, err := w.Write(length[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(metadata); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ReadVectors streams a binary vector file from r, returning the dimensions stored in
+its header and the vectors keyed by ID. Float data is decoded directly into the
+slice of each vector.
+*/
+func ReadVectors(r io.Reader) (int, map[string]Vector, error) {
+	reader, err := newVectorFileReader(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// The count is only a size hint; a damaged one must not reserve a huge map up front
+	hint := reader.remaining
+	if hint > 1<<20 {
+		hint = 1 << 20
+	}
+	vectors := make(map[string]Vector, hint)
+	for {
+		vector, err := reader.next()
+		if err == io.EOF {
+			return reader.dimensions, vectors, nil
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		vectors[vector.ID] = vector
+	}
+}
+
+/*
+vectorFileReader decodes a binary vector file one vector at a time, so the caller
+can store each vector where it belongs and let go of it before the next one is read
+*/
+type vectorFileReader struct {
+	r io.Reader
+	// dimensions of the vectors, from the header
+	dimensions int
+	// number of vector records not read yet
+	remaining uint64
+	// float data of the current record
+	buf []byte
+	// version 1 files only: whether the vectors were decoded up front, and the
+	// ones not handed out yet
+	legacy  bool
+	loaded  bool
+	pending []Vector
+}
+
+/*
+newVectorFileReader reads the header of a binary vector file
+*/
+func newVectorFileReader(r io.Reader) (*vectorFileReader, error) {
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, vectorFileError(err)
+	}
+	if string(header[:4]) != vectorFileMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidVectorFile)
+	}
+	version := binary.LittleEndian.Uint32(header[4:])
+	if version != 1 && version != vectorFileVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidVectorFile, version)
+	}
+	dimensions := int(binary.LittleEndian.Uint32(header[8:]))
+	if 4*dimensions > vectorFileMaxRecord {
+		return nil, fmt.Errorf("%w: %d dimensions", ErrInvalidVectorFile, dimensions)
+	}
+
+	return &vectorFileReader{
+		r:          r,
+		dimensions: dimensions,
+		remaining:  binary.LittleEndian.Uint64(header[12:]),
+		buf:        make([]byte, 4*dimensions),
+		legacy:     version == 1,
+	}, nil
+}
+
+/*
+next returns the next vector of the file, or io.EOF once all were read
+*/
+func (v *vectorFileReader) next() (Vector, error) {
+	if v.legacy {
+		if !v.loaded {
+			if err := v.readLegacy(); err != nil {
+				return Vector{}, err
+			}
+			v.loaded = true
+		}
+		if len(v.pending) == 0 {
+			return Vector{}, io.EOF
+		}
+		vector := v.pending[0]
+		v.pending[0] = Vector{}
+		v.pending = v.pending[1:]
+		return vector, nil
+	}
+
+	if v.remaining == 0 {
+		return Vector{}, io.EOF
+	}
+	v.remaining--
+
+	vector, err := v.readVector()
+	if err != nil {
+		return Vector{}, err
+	}
+	if err := v.readMetadata(&vector); err != nil {
+		return Vector{}, err
+	}
+	return vector, nil
+}
+
+/*
+readLegacy decodes all vectors of a version 1 file, then their metadata
+*/
+func (v *vectorFileReader) readLegacy() error {
+	for ; v.remaining > 0; v.remaining-- {
+		vector, err := v.readVector()
+		if err != nil {
+			return err
+		}
+		v.pending = append(v.pending, vector)
+	}
+	for i := range v.pending {
+		if err := v.readMetadata(&v.pending[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+readVector decodes the ID and float data of a vector record
+*/
+func (v *vectorFileReader) readVector() (Vector, error) {
+	id, err := readVectorFileRecord(v.r)
+	if err != nil {
+		return Vector{}, err
+	}
+
+	if _, err := io.ReadFull(v.r, v.buf); err != nil {
+		return Vector{}, vectorFileError(err)
+	}
+	data := make([]float32, v.dimensions)
+	for i := range data {
+		data[i] = math.Float32frombits(binary.LittleEndian.Uint32(v.buf[4*i:]))
+	}
+	return Vector{ID: string(id), Data: data}, nil
+}
+
+/*
+readMetadata decodes a metadata record into a vector
+*/
+func (v *vectorFileReader) readMetadata(vector *Vector) error {
+	record, err := readVectorFileRecord(v.r)
+	if err != nil {
+		return err
+	}
+	if len(record) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(record, &vector.Metadata); err != nil {
+		return fmt.Errorf("%w: metadata of vector %s: %v", ErrInvalidVectorFile, vector.ID, err)
+	}
+	return nil
+}
+
+/*
+readVectorFileRecord reads a length-prefixed record
+*/
+func readVectorFileRecord(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, vectorFileError(err)
+	}
+
+	size := binary.LittleEndian.Uint32(length[:])
+	if size > vectorFileMaxRecord {
+		return nil, fmt.Errorf("%w: record of %d bytes", ErrInvalidVectorFile, size)
+	}
+
+	record := make([]byte, size)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, vectorFileError(err)
+	}
+	return record, nil
+}
+
+/*
+vectorFileError reports a file that ends early as malformed
+*/
+func vectorFileError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: unexpected end of file", ErrInvalidVectorFile)
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestVectorFileRoundTrip(t *testing.T) {
+	vectors := make(map[string]Vector)
+	for i := 0; i < 100; i++ {
+		data := make([]float32, 32)
+		for j := range data {
+			data[j] = rand.Float32()*2 - 1
+		}
+		vector := Vector{ID: fmt.Sprintf("vec-%d", i), Data: data}
+		if i%2 == 0 {
+			vector.Metadata = map[string]interface{}{"index": float64(i), "tag": "even", "nested": map[string]interface{}{"ok": true}}
+		}
+		vectors[vector.ID] = vector
+	}
+
+	// The binary form is much smaller than the JSON one
+	var buf bytes.Buffer
+	if err := WriteVectors(&buf, 32, vectors); err != nil {
+		t.Fatalf("Failed to write vectors: %v", err)
+	}
+	encoded, _ := json.Marshal(vectors)
+	if buf.Len()*2 > len(encoded) {
+		t.Errorf("Expected binary file (%d bytes) to be less than half of JSON (%d bytes)", buf.Len(), len(encoded))
+	}
+
+	// Special values that JSON cannot represent and non-ASCII IDs survive bit for bit
+	vectors["spécial ✓"] = Vector{ID: "spécial ✓", Data: append([]float32{float32(math.Inf(1)), float32(math.Copysign(0, -1))}, make([]float32, 30)...)}
+	buf.Reset()
+	if err := WriteVectors(&buf, 32, vectors); err != nil {
+		t.Fatalf("Failed to write vectors: %v", err)
+	}
+
+	dimensions, decoded, err := ReadVectors(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read vectors: %v", err)
+	}
+	if dimensions != 32 {
+		t.Errorf("Expected 32 dimensions, got %d", dimensions)
+	}
+	if !reflect.DeepEqual(decoded, vectors) {
+		t.Error("Decoded vectors differ from the written ones")
+	}
+	if !math.Signbit(float64(decoded["spécial ✓"].Data[1])) {
+		t.Error("Expected negative zero to keep its sign")
+	}
+
+	// Output is deterministic
+	var again bytes.Buffer
+	WriteVectors(&again, 32, decoded)
+	if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+		t.Error("Expected identical output when writing the same vectors twice")
+	}
+}
+
+func TestVectorFileErrors(t *testing.T) {
+	vectors := map[string]Vector{"a": {ID: "a", Data: []float32{1, 2, 3}}}
+
+	if err := WriteVectors(&bytes.Buffer{}, 4, vectors); !errors.Is(err, ErrInvalidDimensions) {
+		t.Errorf("Expected ErrInvalidDimensions, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteVectors(&buf, 3, vectors); err != nil {
+		t.Fatalf("Failed to write vectors: %v", err)
+	}
+	data := buf.Bytes()
+
+	// Every truncation is detected
+	for n := 0; n < len(data); n++ {
+		if _, _, err := ReadVectors(bytes.NewReader(data[:n])); !errors.Is(err, ErrInvalidVectorFile) {
+			t.Fatalf("Expected ErrInvalidVectorFile for %d of %d bytes, got %v", n, len(data), err)
+		}
+	}
+
+	future := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(future[4:], vectorFileVersion+1)
+	if _, _, err := ReadVectors(bytes.NewReader(future)); !errors.Is(err, ErrInvalidVectorFile) {
+		t.Errorf("Expected ErrInvalidVectorFile for unknown version, got %v", err)
+	}
+
+	if _, _, err := ReadVectors(bytes.NewReader([]byte(`{"a":{"id":"a"}}`))); !errors.Is(err, ErrInvalidVectorFile) {
+		t.Errorf("Expected ErrInvalidVectorFile for JSON input, got %v", err)
+	}
+}
+
+func TestVectorFileReadsVersion1(t *testing.T) {
+	vectors := map[string]Vector{
+		"a": {ID: "a", Data: []float32{1, 2}, Metadata: map[string]interface{}{"tag": "x"}},
+		"b": {ID: "b", Data: []float32{3, 4}},
+	}
+
+	// Version 1 keeps the metadata of all vectors in a block after the vector records
+	var buf bytes.Buffer
+	buf.WriteString(vectorFileMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	binary.Write(&buf, binary.LittleEndian, uint32(2))
+	binary.Write(&buf, binary.LittleEndian, uint64(len(vectors)))
+	for _, id := range []string{"a", "b"} {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(id)))
+		buf.WriteString(id)
+		binary.Write(&buf, binary.LittleEndian, vectors[id].Data)
+	}
+	for _, id := range []string{"a", "b"} {
+		var metadata []byte
+		if m := vectors[id].Metadata; m != nil {
+			metadata, _ = json.Marshal(m)
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(len(metadata)))
+		buf.Write(metadata)
+	}
+
+	dimensions, decoded, err := ReadVectors(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read version 1 file: %v", err)
+	}
+	if dimensions != 2 || !reflect.DeepEqual(decoded, vectors) {
+		t.Errorf("Expected %v with 2 dimensions, got %v with %d", vectors, decoded, dimensions)
+	}
+}
@@ -0,0 +1,364 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"vector-db/config"
+)
+
+/*
+WALOp is the kind of write recorded in the write-ahead log
+*/
+type WALOp string
+
+const (
+	WALOpAdd    WALOp = "add"
+	WALOpUpsert WALOp = "upsert"
+	WALOpDelete WALOp = "delete"
+)
+
+/*
+WALEntry is a single write recorded in the write-ahead log
+*/
+type WALEntry struct {
+	Op     WALOp   `json:"op"`
+	ID     string  `json:"id,omitempty"`
+	Vector *Vector `json:"vector,omitempty"`
+}
+
+/*
+WALOptions controls when appended entries are flushed to stable storage
+*/
+type WALOptions struct {
+	// fsync policy: every write, every BatchSize writes, or every Interval
+	SyncPolicy config.WALSyncPolicy
+	// number of writes between fsyncs for the batch policy
+	BatchSize int
+	// time between fsyncs for the interval policy
+	Interval time.Duration
+}
+
+/*
+WAL is an append-only log of the writes applied to one database since its last snapshot.
+
+Each entry is stored as one line holding the CRC32 of the entry followed by its JSON
+encoding. Entries are written to the file immediately, so they survive a process crash
+under every policy; the sync policy only decides how often they are forced to disk.
+*/
+type WAL struct {
+	path    string
+	file    *os.File
+	options WALOptions
+	// writes appended since the last fsync
+	pending int
+	mu      sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// ErrWALClosed is returned when appending to a closed write-ahead log
+var ErrWALClosed = errors.New("write-ahead log is closed")
+
+/*
+OpenWAL opens the write-ahead log at path for appending, creating it if necessary
+*/
+func OpenWAL(path string, options WALOptions) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.BatchSize <= 0 {
+		options.BatchSize = 64
+	}
+	if options.Interval <= 0 {
+		options.Interval = time.Second
+	}
+
+	w := &WAL{
+		path:    path,
+		file:    file,
+		options: options,
+	}
+
+	if options.SyncPolicy == config.WALSyncInterval {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncWorker()
+	}
+
+	return w, nil
+}
+
+/*
+Append writes an entry to the end of the log and syncs it according to the policy
+*/
+func (w *WAL) Append(entry WALEntry) error {
+	record, err := encodeWALEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return ErrWALClosed
+	}
+
+	if _, err := w.file.Write(record); err != nil {
+		return err
+	}
+	w.pending++
+
+	switch w.options.SyncPolicy {
+	case config.WALSyncAlways:
+		return w.syncLocked()
+	case config.WALSyncBatch:
+		if w.pending >= w.options.BatchSize {
+			return w.syncLocked()
+		}
+	}
+
+	return nil
+}
+
+/*
+AppendBatch writes several entries to the end of the log with a single write.
+The batch counts as one write for the sync policy, so bulk loads are not slowed
+down by an fsync per entry.
+*/
+func (w *WAL) AppendBatch(entries []WALEntry) error {
+	var records []byte
+	for _, entry := range entries {
+		record, err := encodeWALEntry(entry)
+		if err != nil {
+			return err
+		}
+		records = append(records, record...)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return ErrWALClosed
+	}
+
+	if _, err := w.file.Write(records); err != nil {
+		return err
+	}
+	w.pending++
+
+	switch w.options.SyncPolicy {
+	case config.WALSyncAlways:
+		return w.syncLocked()
+	case config.WALSyncBatch:
+		if w.pending >= w.options.BatchSize {
+			return w.syncLocked()
+		}
+	}
+
+	return nil
+}
+
+/*
+Sync forces all appended entries to stable storage
+*/
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return ErrWALClosed
+	}
+	return w.syncLocked()
+}
+
+func (w *WAL) syncLocked() error {
+	if w.pending == 0 {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.pending = 0
+	return nil
+}
+
+/*
+Rotate moves every entry in the log to a segment file at path and starts an empty
+log. It is called once a snapshot containing those entries has been written; the
+segment is kept so the entries can be replayed on top of an older snapshot should
+the new one turn out to be unreadable.
+*/
+func (w *WAL) Rotate(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return ErrWALClosed
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.pending = 0
+	closeErr := w.file.Close()
+	w.file = nil
+	if closeErr != nil {
+		return closeErr
+	}
+
+	// Reopen the log even if the rename fails, so writes keep being logged
+	renameErr := os.Rename(w.path, path)
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+	return syncDir(filepath.Dir(w.path))
+}
+
+/*
+Close syncs outstanding entries and closes the log
+*/
+func (w *WAL) Close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+		w.stop = nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	syncErr := w.syncLocked()
+	closeErr := w.file.Close()
+	w.file = nil
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
+
+/*
+syncWorker periodically syncs the log for the interval policy
+*/
+func (w *WAL) syncWorker() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.file != nil {
+				w.syncLocked()
+			}
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+/*
+encodeWALEntry serializes an entry as "<crc32 hex> <json>\n"
+*/
+func encodeWALEntry(entry WALEntry) ([]byte, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, 0, len(payload)+10)
+	record = fmt.Appendf(record, "%08x ", crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+	record = append(record, '\n')
+	return record, nil
+}
+
+/*
+ReplayWAL calls apply for every intact entry in the log at path, in order.
+
+Replay stops at the first torn or corrupted record, which can only be the tail
+left by a crash in the middle of a write; the file is truncated there so new
+entries are not appended after garbage. A missing log replays nothing.
+*/
+func ReplayWAL(path string, apply func(WALEntry) error) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		entry, ok := decodeWALEntry(line)
+		if !ok {
+			// Drop the torn tail so the log ends with a complete record
+			return file.Truncate(offset)
+		}
+
+		if err := apply(entry); err != nil {
+			return err
+		}
+		offset += int64(len(line))
+	}
+}
+
+/*
+decodeWALEntry parses a record written by encodeWALEntry, verifying its checksum
+*/
+func decodeWALEntry(line []byte) (WALEntry, bool) {
+	var entry WALEntry
+
+	line, complete := bytes.CutSuffix(line, []byte{'\n'})
+	if !complete || len(line) < 10 || line[8] != ' ' {
+		return entry, false
+	}
+
+	var checksum uint32
+	if _, err := fmt.Sscanf(string(line[:8]), "%08x", &checksum); err != nil {
+		return entry, false
+	}
+	payload := line[9:]
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return entry, false
+	}
+
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"vector-db/config"
+)
+
+func TestWALAppendAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+
+	for _, policy := range []config.WALSyncPolicy{config.WALSyncAlways, config.WALSyncBatch, config.WALSyncInterval} {
+		os.Remove(path)
+
+		wal, err := OpenWAL(path, WALOptions{SyncPolicy: policy, BatchSize: 2})
+		if err != nil {
+			t.Fatalf("Failed to open WAL: %v", err)
+		}
+		entries := []WALEntry{
+			{Op: WALOpAdd, Vector: &Vector{ID: "a", Data: []float32{1, 2}, Metadata: map[string]interface{}{"k": "v"}}},
+			{Op: WALOpUpsert, Vector: &Vector{ID: "a", Data: []float32{3, 4}}},
+			{Op: WALOpDelete, ID: "a"},
+		}
+		for _, entry := range entries {
+			if err := wal.Append(entry); err != nil {
+				t.Fatalf("Failed to append entry with policy %s: %v", policy, err)
+			}
+		}
+		if err := wal.Close(); err != nil {
+			t.Fatalf("Failed to close WAL: %v", err)
+		}
+		if err := wal.Append(entries[0]); err != ErrWALClosed {
+			t.Errorf("Expected ErrWALClosed, got %v", err)
+		}
+
+		var replayed []WALEntry
+		if err := ReplayWAL(path, func(entry WALEntry) error {
+			replayed = append(replayed, entry)
+			return nil
+		}); err != nil {
+			t.Fatalf("Failed to replay WAL: %v", err)
+		}
+		if len(replayed) != len(entries) {
+			t.Fatalf("Expected %d replayed entries with policy %s, got %d", len(entries), policy, len(replayed))
+		}
+		if replayed[0].Op != WALOpAdd || replayed[0].Vector.Data[1] != 2 || replayed[0].Vector.Metadata["k"] != "v" {
+			t.Errorf("First entry replayed incorrectly: %+v", replayed[0])
+		}
+		if replayed[2].Op != WALOpDelete || replayed[2].ID != "a" {
+			t.Errorf("Last entry replayed incorrectly: %+v", replayed[2])
+		}
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+
+	wal, err := OpenWAL(path, WALOptions{SyncPolicy: config.WALSyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	wal.Append(WALEntry{Op: WALOpDelete, ID: "a"})
+	wal.Append(WALEntry{Op: WALOpDelete, ID: "b"})
+	wal.Close()
+
+	info, _ := os.Stat(path)
+	intactSize := info.Size()
+
+	// Simulate a crash in the middle of writing a third record
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`0badc0de {"op":"delete","id":"c`)
+	file.Close()
+
+	count := 0
+	if err := ReplayWAL(path, func(WALEntry) error { count++; return nil }); err != nil {
+		t.Fatalf("Failed to replay WAL: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 intact entries, got %d", count)
+	}
+
+	info, _ = os.Stat(path)
+	if info.Size() != intactSize {
+		t.Errorf("Expected torn tail to be truncated to %d bytes, got %d", intactSize, info.Size())
+	}
+
+	// A missing log replays nothing
+	if err := ReplayWAL(filepath.Join(t.TempDir(), "missing.log"), func(WALEntry) error { count++; return nil }); err != nil || count != 2 {
+		t.Errorf("Expected missing log to replay nothing, got err %v", err)
+	}
+}
+
+func TestWALRecovery(t *testing.T) {
+	dbConfig := config.DatabaseConfig{
+		HNSW: config.HNSWConfig{
+			M:              8,
+			EfConstruction: 100,
+			Dimensions:     4,
+			DistanceType:   config.DistanceTypeEuclidean,
+		},
+	}
+
+	basePath := t.TempDir()
+	persistence := NewPersistenceManager(basePath)
+	persistence.EnableWAL(WALOptions{SyncPolicy: config.WALSyncAlways})
+
+	manager := NewManager(&config.Config{})
+	manager.SetPersistence(persistence)
+	if _, err := manager.CreateDatabase("test", dbConfig); err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		vector := Vector{ID: fmt.Sprintf("%d", i), Data: []float32{float32(i), 0, 0, 0}}
+		if err := manager.AddVector("test", vector); err != nil {
+			t.Fatalf("Failed to add vector: %v", err)
+		}
+	}
+	if err := manager.UpsertVector("test", Vector{ID: "3", Data: []float32{30, 0, 0, 0}}); err != nil {
+		t.Fatalf("Failed to upsert vector: %v", err)
+	}
+	if err := manager.DeleteVector("test", "5"); err != nil {
+		t.Fatalf("Failed to delete vector: %v", err)
+	}
+	batch := []Vector{
+		{ID: "batch_0", Data: []float32{0, 1, 0, 0}},
+		{ID: "batch_1", Data: []float32{0, 2, 0, 0}},
+		{ID: "0", Data: []float32{0, 3, 0, 0}},
+	}
+	if errs, err := manager.AddVectors("test", batch); err != nil || errs[2] != ErrVectorExists {
+		t.Fatalf("Failed to add batch: %v %v", errs, err)
+	}
+
+	// Simulate a crash: nothing was snapshotted since creation, so only the log has the writes
+	recovered := NewPersistenceManager(basePath)
+	db, err := recovered.LoadDatabase("test")
+	if err != nil {
+		t.Fatalf("Failed to load database: %v", err)
+	}
+	if len(db.Vectors) != 11 {
+		t.Errorf("Expected 11 vectors after replay, got %d", len(db.Vectors))
+	}
+	if _, exists := db.Vectors["5"]; exists {
+		t.Error("Deleted vector 5 came back after replay")
+	}
+	upserted, _ := db.Graph.GetVector("3")
+	kept, _ := db.Graph.GetVector("0")
+	batched, _ := db.Graph.GetVector("batch_1")
+	if upserted.Data[0] != 30 || kept.Data[1] != 0 || batched.Data[1] != 2 {
+		t.Errorf("Expected upserted and batch-added vectors, got %v, %v and %v", upserted, kept, batched)
+	}
+	if db.Graph.Len() != 11 {
+		t.Errorf("Expected 11 vectors in the graph after replay, got %d", db.Graph.Len())
+	}
+
+	// A snapshot moves the log into a segment
+	original, _ := manager.GetDatabase("test")
+	if err := persistence.SaveDatabase(original); err != nil {
+		t.Fatalf("Failed to save database: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(basePath, "test", "wal.log"))
+	if err != nil || info.Size() != 0 {
+		t.Errorf("Expected an empty log after snapshot, got %v (err %v)", info, err)
+	}
+
+	// Deleting the database removes it from disk
+	if err := manager.DeleteDatabase("test"); err != nil {
+		t.Fatalf("Failed to delete database: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(basePath, "test")); !os.IsNotExist(err) {
+		t.Errorf("Expected database directory to be removed, got %v", err)
+	}
+	manager.Close()
+}
@@ -33,18 +33,41 @@ func main() {
 	// Print welcome message
 	printWelcome()
 
+	if err := cfg.Storage.Validate(); err != nil {
+		log.Fatal("Invalid storage configuration: ", err)
+	}
+
 	// Create database manager
 	dbManager := db.NewManager(cfg)
-	persistence := db.NewPersistenceManager(cfg.Storage.DataPath)
-
-	// Load existing databases
-	if err := loadDatabases(dbManager, persistence); err != nil {
-		log.Fatal("Failed to load databases: ", err)
-	}
 
-	// Start persistence worker
+	// Without the persistence engine every database lives in memory only
+	var persistence *db.PersistenceManager
 	stopPersistence := make(chan struct{})
-	go persistenceWorker(dbManager, persistence, cfg.Storage.PersistenceInterval, stopPersistence)
+	if cfg.Storage.PersistenceEngine {
+		persistence = db.NewPersistenceManager(cfg.Storage.DataPath)
+		persistence.SetSnapshotRetention(cfg.Storage.SnapshotRetention)
+		walOptions := db.WALOptions{
+			SyncPolicy: cfg.Storage.WALSyncPolicy,
+			BatchSize:  cfg.Storage.WALBatchSize,
+			Interval:   time.Duration(cfg.Storage.WALSyncInterval) * time.Millisecond,
+		}
+		if cfg.Storage.WAL {
+			persistence.EnableWAL(walOptions)
+		} else {
+			persistence.SetWALOptions(walOptions)
+		}
+
+		// Load existing databases
+		if err := loadDatabases(dbManager, persistence); err != nil {
+			log.Fatal("Failed to load databases: ", err)
+		}
+		dbManager.SetPersistence(persistence)
+
+		// Start persistence worker
+		go persistenceWorker(dbManager, persistence, cfg.Storage.PersistenceInterval, stopPersistence)
+	} else {
+		log.Info("Persistence engine disabled, databases are kept in memory only")
+	}
 
 	// Create and start API server
 	apiServer := api.NewServer(dbManager)
@@ -64,12 +87,19 @@ func main() {
 	<-sigChan
 	log.Info("Shutting down...")
 
-	// Stop persistence worker
-	close(stopPersistence)
+	if persistence != nil {
+		// Stop persistence worker
+		close(stopPersistence)
+
+		// Save all databases one last time
+		if err := saveAllDatabases(dbManager, persistence); err != nil {
+			log.Error("Failed to save databases during shutdown: ", err)
+		}
+	}
 
-	// Save all databases one last time
-	if err := saveAllDatabases(dbManager, persistence); err != nil {
-		log.Error("Failed to save databases during shutdown: ", err)
+	// Flush and close the write-ahead logs
+	if err := dbManager.Close(); err != nil {
+		log.Error("Failed to close write-ahead logs: ", err)
 	}
 }
 
@@ -86,8 +116,8 @@ func loadDatabases(dbManager *db.Manager, persistence *db.PersistenceManager) er
 			continue
 		}
 
-		if _, err := dbManager.CreateDatabase(name, db.Config); err != nil {
-			log.Errorf("Failed to create database %s: %v", name, err)
+		if err := dbManager.AttachDatabase(db); err != nil {
+			log.Errorf("Failed to attach database %s: %v", name, err)
 			continue
 		}
 	}
@@ -103,6 +133,14 @@ func saveAllDatabases(dbManager *db.Manager, persistence *db.PersistenceManager)
 			continue
 		}
 
+		// Databases unchanged since their last snapshot are already on disk,
+		// and ephemeral ones never go there
+		pending := db.PendingChanges()
+		if pending == 0 || db.Config.Durability == config.DurabilityEphemeral {
+			continue
+		}
+		log.Debugf("Saving database %s with %d pending changes", name, pending)
+
 		if err := persistence.SaveDatabase(db); err != nil {
 			log.Errorf("Failed to save database %s: %v", name, err)
 			continue
@@ -143,6 +181,11 @@ func parseFlags() *config.Config {
 	flag.StringVar(&cfg.Storage.DataPath, "data-path", cfg.Storage.DataPath, "Path to store data files")
 	flag.BoolVar(&cfg.Storage.PersistenceEngine, "persistence", cfg.Storage.PersistenceEngine, "Enable persistence engine")
 	flag.IntVar(&cfg.Storage.PersistenceInterval, "persistence-interval", cfg.Storage.PersistenceInterval, "Persistence interval in seconds")
+	flag.BoolVar(&cfg.Storage.WAL, "wal", cfg.Storage.WAL, "Record writes in a write-ahead log between snapshots")
+	flag.StringVar((*string)(&cfg.Storage.WALSyncPolicy), "wal-sync", string(cfg.Storage.WALSyncPolicy), "Write-ahead log fsync policy (always, batch, interval)")
+	flag.IntVar(&cfg.Storage.WALBatchSize, "wal-batch-size", cfg.Storage.WALBatchSize, "Writes between fsyncs for the batch policy")
+	flag.IntVar(&cfg.Storage.WALSyncInterval, "wal-sync-interval", cfg.Storage.WALSyncInterval, "Milliseconds between fsyncs for the interval policy")
+	flag.IntVar(&cfg.Storage.SnapshotRetention, "snapshot-retention", cfg.Storage.SnapshotRetention, "Number of snapshots kept per database")
 
 	// Default database HNSW flags
 	defaultDB := cfg.Databases["default"]
@@ -84,7 +84,7 @@ func TestSimilarWords(t *testing.T) {
 		}
 
 		// Get vector for the word
-		vector, ok := graph.Vectors[word]
+		vector, ok := graph.GetVector(word)
 		if !ok {
 			t.Errorf("Word '%s' not found in graph, but was in vocabulary", word)
 			continue
@@ -123,8 +123,8 @@ type SimilarWord struct {
 
 // searchSimilarWords finds words similar to the query word
 func searchSimilarWords(graph *db.HNSWGraph, queryVector []float32, queryWord string, topK int) ([]SimilarWord, error) {
-	// Search for nearest neighbors
-	neighbors, err := graph.Search(queryVector, topK+1) // +1 to account for the query word itself
+	// Search for nearest neighbors; the vectors themselves are not needed, only the scores
+	neighbors, err := graph.SearchWithOptions(queryVector, topK+1, db.SearchOptions{OmitVectors: true, OmitMetadata: true}) // +1 to account for the query word itself
 	if err != nil {
 		return nil, err
 	}
@@ -133,11 +133,10 @@ func searchSimilarWords(graph *db.HNSWGraph, queryVector []float32, queryWord st
 	var results []SimilarWord
 	for _, neighbor := range neighbors {
 		if neighbor.ID != queryWord {
-			// Calculate similarity (1 - distance for cosine, since we want similarity not distance)
-			similarity := 1.0 - float32(graph.Distance(queryVector, neighbor.Data))
+			// For cosine graphs the score is the cosine similarity
 			results = append(results, SimilarWord{
 				Word:       neighbor.ID,
-				Similarity: similarity,
+				Similarity: neighbor.Score,
 			})
 
 			if len(results) >= topK {
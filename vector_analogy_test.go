@@ -292,17 +292,17 @@ func loadCustomAnalogies(filePath string) ([]AnalogyTest, error) {
 // testAnalogy tests a single word analogy (A - B + C = D)
 func testAnalogy(graph *db.HNSWGraph, wordA, wordB, wordC, expected string, topK int) (analogyResult, error) {
 	// Get vectors for words
-	vecA, ok := graph.Vectors[wordA]
+	vecA, ok := graph.GetVector(wordA)
 	if !ok {
 		return analogyResult{}, fmt.Errorf("word '%s' not found in vocabulary", wordA)
 	}
 
-	vecB, ok := graph.Vectors[wordB]
+	vecB, ok := graph.GetVector(wordB)
 	if !ok {
 		return analogyResult{}, fmt.Errorf("word '%s' not found in vocabulary", wordB)
 	}
 
-	vecC, ok := graph.Vectors[wordC]
+	vecC, ok := graph.GetVector(wordC)
 	if !ok {
 		return analogyResult{}, fmt.Errorf("word '%s' not found in vocabulary", wordC)
 	}
@@ -353,7 +353,7 @@ func testAnalogy(graph *db.HNSWGraph, wordA, wordB, wordC, expected string, topK
 			result.rank = i + 1
 
 			// Calculate similarity with expected vector
-			expectedVec, ok := graph.Vectors[expected]
+			expectedVec, ok := graph.GetVector(expected)
 			if ok {
 				similarity, _ := db.CosineSimilarity(resultVec, expectedVec.Data)
 				result.similarity = similarity